@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+)
+
+/*
+Messages from other bots are discarded unless the bot or its app is allowed.
+
+To avoid two bots answering each other forever, the number of bot messages
+processed per channel is limited over a sliding window.
+*/
+
+// botFilter decide which bot messages can be processed
+type botFilter struct {
+	// Allowed bot IDs or app IDs
+	allowed map[string]bool
+	// Maximum number of bot messages processed per channel during window
+	maxMessages int
+	window      time.Duration
+	history     map[string][]time.Time
+	lock        sync.Mutex
+}
+
+// newBotFilter return a new bot filter
+func newBotFilter(allowed []string, maxMessages int, window time.Duration) *botFilter {
+	f := &botFilter{
+		allowed:     map[string]bool{},
+		maxMessages: maxMessages,
+		window:      window,
+		history:     map[string][]time.Time{},
+	}
+	for _, a := range allowed {
+		f.allowed[a] = true
+	}
+	return f
+}
+
+// botID return the bot id of the message author if it's a bot
+func botID(ev *slack.MessageEvent) string {
+	if ev.SubType == "message_changed" && ev.SubMessage != nil {
+		return ev.SubMessage.BotID
+	}
+	return ev.BotID
+}
+
+// Allow tell if a bot message can be processed
+func (f *botFilter) Allow(ev *slack.MessageEvent) bool {
+
+	id := botID(ev)
+	if id == "" || len(f.allowed) == 0 {
+		return false
+	}
+
+	if !f.allowed[id] {
+		infos, err := bot.GetCachedBotInfos(id)
+		if err != nil || !f.allowed[infos.AppID] {
+			return false
+		}
+	}
+
+	return f.withinLimit(ev.Channel, time.Now())
+}
+
+// withinLimit record a bot message received at now for a channel and tell if the limit is not reached
+func (f *botFilter) withinLimit(channel string, now time.Time) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	recent := []time.Time{}
+	for _, t := range f.history[channel] {
+		if now.Sub(t) < f.window {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= f.maxMessages {
+		f.history[channel] = recent
+		zap.L().Warn("Too many bot messages in channel, possible bot loop", zap.String("channel", channel), zap.Int("messages", len(recent)), zap.Duration("window", f.window))
+		return false
+	}
+
+	f.history[channel] = append(recent, now)
+	return true
+}
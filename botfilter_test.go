@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CyrilPeponnet/slackhal/plugin/plugintest"
+	"github.com/slack-go/slack"
+)
+
+func TestBotFilterAllow(t *testing.T) {
+
+	// Bot infos are looked up with the bot of the package
+	b := plugintest.NewBot(t)
+	b.AddBot(slack.Bot{ID: "B0000APP", Name: "deployer", AppID: "A0000001"})
+	b.AddBot(slack.Bot{ID: "B0000OTHER", Name: "spammer", AppID: "A0000002"})
+	api := bot.API
+	bot.API = b.API
+	t.Cleanup(func() { bot.API = api })
+
+	tests := []struct {
+		name    string
+		allowed []string
+		msg     slack.Message
+		want    bool
+	}{
+		{"allowed bot", []string{"B0000ID"}, slack.Message{Msg: slack.Msg{BotID: "B0000ID"}}, true},
+		{"allowed app", []string{"A0000001"}, slack.Message{Msg: slack.Msg{BotID: "B0000APP"}}, true},
+		{"other app", []string{"A0000001"}, slack.Message{Msg: slack.Msg{BotID: "B0000OTHER"}}, false},
+		{"unknown bot", []string{"A0000001"}, slack.Message{Msg: slack.Msg{BotID: "B0000GONE"}}, false},
+		{"nothing allowed", nil, slack.Message{Msg: slack.Msg{BotID: "B0000ID"}}, false},
+		{"not a bot", []string{"B0000ID"}, slack.Message{Msg: slack.Msg{User: "U1"}}, false},
+		{"edited by allowed bot", []string{"B0000ID"}, slack.Message{Msg: slack.Msg{SubType: "message_changed"}, SubMessage: &slack.Msg{BotID: "B0000ID"}}, true},
+		{"edited by other bot", []string{"B0000ID"}, slack.Message{Msg: slack.Msg{SubType: "message_changed", BotID: "B0000ID"}, SubMessage: &slack.Msg{BotID: "B0000OTHER"}}, false},
+	}
+
+	for _, test := range tests {
+		f := newBotFilter(test.allowed, 5, time.Minute)
+		test.msg.Channel = "C1"
+		ev := slack.MessageEvent(test.msg)
+		if got := f.Allow(&ev); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBotFilterWithinLimit(t *testing.T) {
+
+	f := newBotFilter([]string{"B0000ID"}, 2, time.Minute)
+	start := time.Now()
+
+	// Every step runs on the messages recorded by the previous ones
+	tests := []struct {
+		name    string
+		channel string
+		at      time.Duration
+		want    bool
+	}{
+		{"first", "C1", 0, true},
+		{"second", "C1", 10 * time.Second, true},
+		{"limit reached", "C1", 20 * time.Second, false},
+		{"other channel", "C2", 20 * time.Second, true},
+		{"refused not recorded", "C1", 59 * time.Second, false},
+		{"first out of window", "C1", time.Minute, true},
+		{"limit reached again", "C1", 65 * time.Second, false},
+		{"second out of window", "C1", 70 * time.Second, true},
+		{"all out of window", "C1", 3 * time.Minute, true},
+	}
+
+	for _, test := range tests {
+		if got := f.withinLimit(test.channel, start.Add(test.at)); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
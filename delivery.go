@@ -0,0 +1,469 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/CyrilPeponnet/slackhal/pkg/logutils"
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/slack-go/slack"
+)
+
+/*
+The deliverer sends the responses of the plugins to slack.
+
+Responses are queued per channel and sent in order at the pace allowed by
+slack (about one message per second per channel). Rate limited and transient
+errors are retried, responses still failing are logged to the dead letters log.
+The queue of a channel is removed once idle for the pacing interval.
+*/
+
+// maxBackoff is the maximum delay between two attempts of a transient error
+const maxBackoff = time.Minute
+
+// errNoEphemeralUser is the error of an ephemeral response without user
+var errNoEphemeralUser = errors.New("no user set for ephemeral message")
+
+// transientErrors are slack api errors worth a retry
+var transientErrors = map[string]bool{
+	"internal_error":      true,
+	"fatal_error":         true,
+	"service_unavailable": true,
+	"request_timeout":     true,
+	"ratelimited":         true,
+}
+
+// channelQueue is the queue of responses of a channel
+type channelQueue struct {
+	pending []*plugin.SlackResponse
+	running bool
+	last    time.Time
+}
+
+// deliverer send responses through per channel queues
+type deliverer struct {
+	bot *plugin.Bot
+	// Token and client used for the calls not supported by the slack client
+	token  string
+	client *http.Client
+	// Minimum delay between two messages in a channel
+	interval time.Duration
+	// Number of retries before giving up
+	maxRetries int
+	// Logger of undeliverable responses
+	deadLetters *zap.Logger
+	queues      map[string]*channelQueue
+	lock        sync.Mutex
+}
+
+// newDeliverer return a new deliverer, dead letters are written to deadLettersFile if set
+func newDeliverer(bot *plugin.Bot, token string, interval time.Duration, maxRetries int, deadLettersFile string) *deliverer {
+
+	deadLetters := zap.L().Named("deadletters")
+	if deadLettersFile != "" {
+		deadLetters, _ = logutils.NewLogger("deadletters", "info", "json", deadLettersFile, true, false)
+	}
+
+	return &deliverer{
+		bot:         bot,
+		token:       token,
+		client:      httpClient,
+		interval:    interval,
+		maxRetries:  maxRetries,
+		deadLetters: deadLetters,
+		queues:      map[string]*channelQueue{},
+	}
+}
+
+// Enqueue a response to be delivered
+func (d *deliverer) Enqueue(msg *plugin.SlackResponse) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	q, ok := d.queues[msg.Channel]
+	if !ok {
+		q = &channelQueue{}
+		d.queues[msg.Channel] = q
+	}
+
+	q.pending = append(q.pending, msg)
+
+	if !q.running {
+		q.running = true
+		go d.run(msg.Channel, q)
+	}
+}
+
+// run deliver the responses of the queue of a channel until it is empty
+func (d *deliverer) run(channel string, q *channelQueue) {
+	for {
+		d.lock.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			d.evict(channel, q)
+			d.lock.Unlock()
+			return
+		}
+		msg := q.pending[0]
+		q.pending = q.pending[1:]
+		// Only posted messages are paced
+		paced := msg.Mode == plugin.ModePost || msg.Mode == plugin.ModeEphemeral
+		wait := d.interval - time.Since(q.last)
+		d.lock.Unlock()
+
+		if paced && wait > 0 {
+			time.Sleep(wait)
+		}
+
+		d.deliver(msg)
+
+		if paced {
+			d.lock.Lock()
+			q.last = time.Now()
+			d.lock.Unlock()
+		}
+	}
+}
+
+// evict remove the queue of a channel once idle, as soon as its last message
+// no longer delays the next one. It must be called with the lock held.
+func (d *deliverer) evict(channel string, q *channelQueue) {
+
+	// The queue is evicted by its runner once empty
+	if q.running || len(q.pending) > 0 || d.queues[channel] != q {
+		return
+	}
+
+	if wait := d.interval - time.Since(q.last); wait > 0 {
+		time.AfterFunc(wait, func() {
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			d.evict(channel, q)
+		})
+		return
+	}
+
+	delete(d.queues, channel)
+}
+
+// deliver a response, retrying on rate limits and transient errors
+func (d *deliverer) deliver(msg *plugin.SlackResponse) {
+	for attempt := 0; ; attempt++ {
+
+		result, err := d.send(msg)
+		if err == nil {
+			d.done(msg, result)
+			return
+		}
+
+		wait, retry := retryDelay(err, attempt)
+		if !retry || attempt >= d.maxRetries {
+			d.deadLetter(msg, err, attempt+1)
+			d.done(msg, plugin.DeliveryResult{Channel: msg.Channel, Err: err})
+			return
+		}
+
+		zap.L().Warn("Failed to deliver message, retrying", zap.String("channel", msg.Channel), zap.Int("attempt", attempt+1), zap.Duration("retryAfter", wait), zap.Error(err))
+		time.Sleep(wait)
+	}
+}
+
+// done report the result of a delivery to the plugin if it asked for it.
+// The permalink of a posted message is looked up aside so the next messages
+// of the channel are not delayed.
+func (d *deliverer) done(msg *plugin.SlackResponse, result plugin.DeliveryResult) {
+
+	if msg.Done == nil {
+		return
+	}
+
+	if result.Err == nil && result.Permalink == "" && result.Timestamp != "" && msg.Mode == plugin.ModePost {
+		go func() {
+			permalink, err := d.bot.RTM.GetPermalink(&slack.PermalinkParameters{Channel: result.Channel, Ts: result.Timestamp})
+			if err != nil {
+				zap.L().Warn("Cannot get message permalink", zap.String("channel", result.Channel), zap.String("ts", result.Timestamp), zap.Error(err))
+			}
+			result.Permalink = permalink
+			d.callback(msg, result)
+		}()
+		return
+	}
+
+	d.callback(msg, result)
+}
+
+// callback call the Done callback of a response
+func (d *deliverer) callback(msg *plugin.SlackResponse, result plugin.DeliveryResult) {
+	err := plugin.Protect(func() error {
+		msg.Done(result)
+		return nil
+	})
+	if perr, ok := err.(*plugin.PanicError); ok {
+		zap.L().Error("Plugin panicked in delivery callback", zap.String("plugin", msg.Plugin), zap.Reflect("panic", perr.Value), zap.ByteString("stack", perr.Stack))
+		plugin.PluginManager.Failed(msg.Plugin)
+	}
+}
+
+// send a response according to its delivery mode
+func (d *deliverer) send(msg *plugin.SlackResponse) (plugin.DeliveryResult, error) {
+
+	bot := d.bot
+	result := plugin.DeliveryResult{Channel: msg.Channel}
+
+	switch msg.Mode {
+
+	case plugin.ModeEphemeral:
+		// Never post to the channel what is meant for a single user
+		if msg.User == "" {
+			return result, errNoEphemeralUser
+		}
+		ts, err := bot.RTM.PostEphemeral(msg.Channel, msg.User, msg.Options...)
+		result.Timestamp = ts
+		return result, err
+
+	case plugin.ModeScheduled:
+		if err := d.cancelScheduled(msg); err != nil {
+			return result, err
+		}
+		id, err := d.schedule(msg)
+		if err != nil {
+			return result, err
+		}
+		zap.L().Debug("Scheduled message", zap.String("channel", msg.Channel), zap.String("id", id), zap.Time("postAt", msg.PostAt))
+		if msg.TrackerID != "" {
+			bot.Tracker.Schedule(trackerKey(msg), msg.Channel, id, msg.PostAt)
+		}
+		return result, nil
+
+	case plugin.ModeCancelScheduled:
+		return result, d.cancelScheduled(msg)
+
+	case plugin.ModeDelete:
+		channel, ts := d.target(msg)
+		if ts == "" {
+			zap.L().Warn("No message to delete", zap.String("channel", msg.Channel), zap.String("trackerID", msg.TrackerID))
+			return result, nil
+		}
+		result.Channel, result.Timestamp = channel, ts
+		if _, _, err := bot.RTM.DeleteMessage(channel, ts); err != nil && err.Error() != "message_not_found" {
+			return result, err
+		}
+		if msg.TrackerID != "" && msg.Timestamp == "" {
+			bot.Tracker.Untrack(trackerKey(msg))
+		}
+		return result, nil
+
+	case plugin.ModeAddReaction, plugin.ModeRemoveReaction:
+		channel, ts := d.target(msg)
+		if ts == "" || msg.Reaction == "" {
+			zap.L().Warn("No message or reaction to react with", zap.String("channel", msg.Channel), zap.String("reaction", msg.Reaction))
+			return result, nil
+		}
+		result.Channel, result.Timestamp = channel, ts
+		item := slack.NewRefToMessage(channel, ts)
+		reaction := strings.Trim(msg.Reaction, ":")
+		if msg.Mode == plugin.ModeAddReaction {
+			if err := bot.RTM.AddReaction(reaction, item); err != nil && err.Error() != "already_reacted" {
+				return result, err
+			}
+			return result, nil
+		}
+		if err := bot.RTM.RemoveReaction(reaction, item); err != nil && err.Error() != "no_reaction" {
+			return result, err
+		}
+		return result, nil
+	}
+
+	if msg.Snippet != nil {
+		permalink, err := d.upload(msg)
+		result.Permalink = permalink
+		return result, err
+	}
+
+	if msg.TrackerID != "" {
+		key := trackerKey(msg)
+		if tracker, found := bot.Tracker.Get(key); found {
+			c, _, _, e := bot.RTM.UpdateMessage(tracker.TrackedChannel, tracker.TimeStamp, msg.Options...)
+			if e != nil {
+				return result, e
+			}
+			zap.L().Debug("Updated message", zap.String("channel", c))
+			// Update the tracker
+			bot.Tracker.Track(key, tracker.TrackedChannel, tracker.TimeStamp, msg.TrackedFor)
+			result.Channel, result.Timestamp = tracker.TrackedChannel, tracker.TimeStamp
+			return result, nil
+		}
+	}
+
+	// Else post message
+	c, t, e := bot.RTM.PostMessage(msg.Channel, msg.Options...)
+	if e != nil {
+		return result, e
+	}
+	// If the message need to be tracked
+	if msg.TrackerID != "" {
+		bot.Tracker.Track(trackerKey(msg), c, t, msg.TrackedFor)
+	}
+	result.Channel, result.Timestamp = c, t
+	return result, nil
+}
+
+// upload the text of a response as a snippet and return its permalink
+func (d *deliverer) upload(msg *plugin.SlackResponse) (string, error) {
+
+	_, values, err := slack.UnsafeApplyMsgOptions("", msg.Channel, "", msg.Options...)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := d.bot.RTM.UploadFile(slack.FileUploadParameters{
+		Content:         values.Get("text"),
+		Filename:        msg.Snippet.Filename,
+		Filetype:        msg.Snippet.Filetype,
+		Title:           msg.Snippet.Title,
+		Channels:        []string{msg.Channel},
+		ThreadTimestamp: values.Get("thread_ts"),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return file.Permalink, nil
+}
+
+// trackerKey return the key of the message tracked by a response
+func trackerKey(msg *plugin.SlackResponse) plugin.TrackerKey {
+	return plugin.TrackerKey{Plugin: msg.Plugin, Channel: msg.Channel, Key: msg.TrackerID}
+}
+
+// target return the channel and the timestamp of the message targeted by a response
+func (d *deliverer) target(msg *plugin.SlackResponse) (string, string) {
+	if msg.Timestamp != "" {
+		return msg.Channel, msg.Timestamp
+	}
+	if msg.TrackerID != "" {
+		if tracker, found := d.bot.Tracker.Get(trackerKey(msg)); found {
+			return tracker.TrackedChannel, tracker.TimeStamp
+		}
+	}
+	return msg.Channel, ""
+}
+
+// schedule a message and return its id.
+// The slack client does not return the id of scheduled messages so the call is done here.
+func (d *deliverer) schedule(msg *plugin.SlackResponse) (string, error) {
+
+	options := append(append([]slack.MsgOption{}, msg.Options...), slack.MsgOptionSchedule(strconv.FormatInt(msg.PostAt.Unix(), 10)))
+	endpoint, values, err := slack.UnsafeApplyMsgOptions(d.token, msg.Channel, slack.APIURL, options...)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := d.client.PostForm(endpoint, values)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retry, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return "", &slack.RateLimitedError{RetryAfter: time.Duration(retry) * time.Second}
+	}
+
+	response := struct {
+		Ok                 bool   `json:"ok"`
+		Error              string `json:"error"`
+		ScheduledMessageID string `json:"scheduled_message_id"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("invalid response from slack (%s): %v", resp.Status, err)
+	}
+
+	if !response.Ok {
+		return "", errors.New(response.Error)
+	}
+
+	return response.ScheduledMessageID, nil
+}
+
+// cancelScheduled cancel the message scheduled with the tracker of a response if any
+func (d *deliverer) cancelScheduled(msg *plugin.SlackResponse) error {
+
+	if msg.TrackerID == "" {
+		return nil
+	}
+
+	key := trackerKey(msg)
+
+	s, ok := d.bot.Tracker.GetScheduled(key)
+	if !ok {
+		return nil
+	}
+
+	_, err := d.bot.RTM.DeleteScheduledMessage(&slack.DeleteScheduledMessageParameters{Channel: s.ScheduledChannel, ScheduledMessageID: s.ID})
+	// Already posted or deleted
+	if err != nil && err.Error() != "invalid_scheduled_message_id" {
+		return err
+	}
+
+	zap.L().Debug("Cancelled scheduled message", zap.String("channel", s.ScheduledChannel), zap.String("id", s.ID))
+
+	d.bot.Tracker.Unschedule(key)
+
+	return nil
+}
+
+// deadLetter log a response which cannot be delivered
+func (d *deliverer) deadLetter(msg *plugin.SlackResponse, err error, attempts int) {
+
+	zap.L().Error("Failed to deliver message", zap.String("channel", msg.Channel), zap.Int("attempts", attempts), zap.Error(err))
+
+	fields := []zap.Field{
+		zap.String("channel", msg.Channel),
+		zap.String("plugin", msg.Plugin),
+		zap.String("trackerID", msg.TrackerID),
+		zap.Int("attempts", attempts),
+		zap.Error(err),
+	}
+
+	// Options are functions, render them to log the content
+	if _, values, e := slack.UnsafeApplyMsgOptions("", msg.Channel, "", msg.Options...); e == nil {
+		values.Del("token")
+		fields = append(fields, zap.Reflect("message", values))
+	}
+
+	d.deadLetters.Error("Undeliverable message", fields...)
+}
+
+// retryDelay tell if an error is worth a retry and how long to wait before
+func retryDelay(err error, attempt int) (time.Duration, bool) {
+
+	if rl, ok := err.(*slack.RateLimitedError); ok {
+		return rl.RetryAfter, true
+	}
+
+	backoff := time.Second << uint(attempt)
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	switch e := err.(type) {
+	case interface{ Retryable() bool }:
+		return backoff, e.Retryable()
+	case net.Error:
+		return backoff, e.Temporary() || e.Timeout()
+	}
+
+	// Slack api errors are plain errors
+	return backoff, transientErrors[err.Error()]
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+)
+
+func TestEvictIdleQueues(t *testing.T) {
+
+	d := &deliverer{interval: 20 * time.Millisecond, queues: map[string]*channelQueue{}}
+
+	idle := &channelQueue{}
+	paced := &channelQueue{last: time.Now()}
+	running := &channelQueue{running: true}
+	d.queues = map[string]*channelQueue{"idle": idle, "paced": paced, "running": running}
+
+	d.lock.Lock()
+	for channel, q := range d.queues {
+		d.evict(channel, q)
+	}
+	d.lock.Unlock()
+
+	// queues return if the queues of the channels are kept
+	queues := func() (kept map[string]bool) {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		kept = map[string]bool{}
+		for channel := range d.queues {
+			kept[channel] = true
+		}
+		return
+	}
+
+	if kept := queues(); kept["idle"] || !kept["paced"] || !kept["running"] {
+		t.Errorf("got queues %v", kept)
+	}
+
+	// The paced queue is evicted once its last message no longer delays the next one
+	time.Sleep(3 * d.interval)
+	if kept := queues(); kept["paced"] || !kept["running"] {
+		t.Errorf("got queues %v after the interval", kept)
+	}
+}
+
+func TestEphemeralWithoutUser(t *testing.T) {
+
+	d := &deliverer{deadLetters: zap.NewNop(), queues: map[string]*channelQueue{}}
+
+	var result plugin.DeliveryResult
+	d.deliver(&plugin.SlackResponse{
+		Channel: "C1",
+		Mode:    plugin.ModeEphemeral,
+		Options: []slack.MsgOption{slack.MsgOptionText("for your eyes only", false)},
+		Done:    func(r plugin.DeliveryResult) { result = r },
+	})
+
+	// Not posted to the channel nor retried
+	if result.Err != errNoEphemeralUser || result.Timestamp != "" {
+		t.Errorf("got result %+v", result)
+	}
+}
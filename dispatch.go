@@ -2,187 +2,160 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
-	"regexp"
+	"os"
 	"strings"
-	"time"
+	"sync"
 
 	"go.uber.org/zap"
 
 	"github.com/CyrilPeponnet/slackhal/plugin"
 	"github.com/slack-go/slack"
+	"github.com/spf13/viper"
 )
 
 // DispatchResponses will process responses from the channel
+// They are queued per channel to be delivered at the pace allowed by slack.
+// Failures are reported asynchronously as callbacks may send new responses.
 func DispatchResponses(output chan *plugin.SlackResponse, bot *plugin.Bot) {
 
+	d := newDeliverer(bot,
+		viper.GetString("bot.token"),
+		viper.GetDuration("bot.delivery.interval"),
+		viper.GetInt("bot.delivery.maxRetries"),
+		os.ExpandEnv(viper.GetString("bot.delivery.deadLetters")))
+
+	maxLength := viper.GetInt("bot.delivery.maxLength")
+	snippetThreshold := viper.GetInt("bot.delivery.snippetThreshold")
+
+	resolver := &resolveQueue{
+		bot:     bot,
+		pending: map[string][]*plugin.SlackResponse{},
+		resolved: func(msg *plugin.SlackResponse, channels []string, err error) {
+			if err != nil {
+				zap.L().Warn("Cannot resolve the channel", zap.String("channel", msg.Channel), zap.Error(err))
+				d.done(msg, plugin.DeliveryResult{Channel: msg.Channel, Err: err})
+				return
+			}
+			// Fan out to every channel, tracking only makes sense for a single one
+			for _, c := range channels {
+				r := *msg
+				r.Channel = c
+				if len(channels) > 1 {
+					r.TrackerID = ""
+				}
+				// Long responses are split or uploaded as snippets
+				for _, part := range splitResponse(&r, maxLength, snippetThreshold) {
+					d.Enqueue(part)
+				}
+			}
+		},
+	}
+
 	for msg := range output {
 
 		switch {
 
 		case msg.Channel == "":
 			zap.L().Warn("No channel found", zap.Reflect("message", msg))
+			go d.done(msg, plugin.DeliveryResult{Err: fmt.Errorf("no channel set")})
 
-		case msg.Options == nil:
+		case msg.Options == nil && (msg.Mode == plugin.ModePost || msg.Mode == plugin.ModeEphemeral || msg.Mode == plugin.ModeScheduled):
 			zap.L().Warn("Nothing to send", zap.Reflect("message", msg))
+			go d.done(msg, plugin.DeliveryResult{Channel: msg.Channel, Err: fmt.Errorf("nothing to send")})
 
 		default:
-			if msg.TrackerID != 0 && bot.Tracker.GetTimeStampFor(msg.TrackerID) != "" {
-				ts := bot.Tracker.GetTimeStampFor(msg.TrackerID)
-				c, _, _, e := bot.RTM.UpdateMessage(msg.Channel, ts, msg.Options...)
-				if e != nil {
-					zap.L().Error("Error while updating message", zap.Error(e))
-				} else {
-					zap.L().Debug("Updated message", zap.String("channel", c))
-					// Update the tracker
-					bot.Tracker.Track(plugin.Tracker{TrackerID: msg.TrackerID, TimeStamp: ts, TTL: 300})
-				}
-			} else {
-				// Else post message
-				_, t, e := bot.RTM.PostMessage(msg.Channel, msg.Options...)
-				if e != nil {
-					zap.L().Error("Error while sending message", zap.Error(e))
-				} else {
-					// zap.L().Debug("Sent message", zap.String("channel", c))
-					// If the message need to be tracked
-					if msg.TrackerID != 0 && bot.Tracker.GetTimeStampFor(msg.TrackerID) == "" {
-						bot.Tracker.Track(plugin.Tracker{TrackerID: msg.TrackerID, TimeStamp: t, TTL: 300})
-					}
-				}
-			}
+			// Resolving may call slack, keep the loop free
+			resolver.Add(msg)
 		}
 	}
 }
 
-// checkForCommand will try to detect a command in a message
-// It will tokenise the message (split by space) for that.
-func checkForCommand(text string, command string) bool {
-
-	for _, word := range strings.Split(text, " ") {
-		if strings.ToLower(word) == command {
-			return true
-		}
-	}
-	return false
+// resolveQueue resolve the destinations of the responses off the dispatch loop.
+// Responses to a same destination are resolved in order.
+type resolveQueue struct {
+	bot *plugin.Bot
+	// resolved is called with the channels of a response
+	resolved func(msg *plugin.SlackResponse, channels []string, err error)
+	// Pending responses per destination, a destination is being resolved while present
+	pending map[string][]*plugin.SlackResponse
+	lock    sync.Mutex
 }
 
-// DispatchMessage to plugins
-func DispatchMessage(prefix string, msg *slack.MessageEvent, output chan *plugin.SlackResponse) {
+// Add a response to be resolved
+func (r *resolveQueue) Add(msg *plugin.SlackResponse) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 
-	// Check if this is an edited message
-	// if so fill up as if it was a message
-	if msg.SubType == "message_changed" {
-		msg.Msg.Text = msg.SubMessage.Text
-		msg.User = msg.SubMessage.User
+	q, running := r.pending[msg.Channel]
+	r.pending[msg.Channel] = append(q, msg)
+	if !running {
+		go r.run(msg.Channel)
 	}
+}
 
-	// Build our authz context once if not set
-	userChansID := []string{}
-	ch, err := bot.GetCachedUserChans(msg.User)
-	if err != nil {
-		return
-	}
-	for _, c := range ch {
-		userChansID = append(userChansID, c.ID)
-	}
+// run resolve the responses of a destination until there is none
+func (r *resolveQueue) run(destination string) {
+	for {
+		r.lock.Lock()
+		q := r.pending[destination]
+		if len(q) == 0 {
+			delete(r.pending, destination)
+			r.lock.Unlock()
+			return
+		}
+		msg := q[0]
+		r.pending[destination] = q[1:]
+		r.lock.Unlock()
 
-	userInfo, err := bot.GetCachedUserInfos(msg.User)
-	if err != nil {
-		return
+		channels, err := r.bot.ResolveChannel(destination)
+		r.resolved(msg, channels, err)
 	}
+}
+
+// DispatchCommand dispatch a command as if the bot sent it in a channel.
+// The channel can be an ID or the #name of a channel.
+func DispatchCommand(prefix string, channel string, command string, output chan *plugin.SlackResponse) {
 
-	// Every direct message goes through the autorizer chat handler
-	// This is where the rbac is configured before plugins are called
-	if strings.HasPrefix(msg.Channel, "D") {
-		if response := AuthzHandleChat(msg); response != "" {
-			o := new(plugin.SlackResponse)
-			o.Channel = msg.Msg.Channel
-			o.Options = append(o.Options, slack.MsgOptionText(response, false))
-			output <- o
+	if strings.HasPrefix(channel, "#") {
+		channels, err := bot.ResolveChannel(channel)
+		if err != nil {
+			zap.L().Warn("Cannot find channel to dispatch command", zap.String("channel", channel), zap.String("command", command), zap.Error(err))
 			return
 		}
+		channel = channels[0]
 	}
 
-	message := msg.Msg
-
-	// mentionned is true id direct message or message contains mention to us
-	mentionned := strings.HasPrefix(msg.Channel, "D") || strings.Contains(message.Text, fmt.Sprintf("<@%v>", bot.ID))
-
-	// Process active triggers
-	// For each plugins
-	func() {
-
-		replied := false
-		for _, p := range plugin.PluginManager.Plugins {
+	msg := &slack.MessageEvent{Msg: slack.Msg{
+		Type:    "message",
+		Channel: channel,
+		User:    bot.ID,
+		Text:    prefix + command,
+	}}
 
-			// Get metadata
-			info := p.GetMetadata()
-			if info.Disabled {
-				continue
-			}
+	DispatchMessage(prefix, msg, output)
+}
 
-			// Process active triggers
-			for _, c := range info.ActiveTriggers {
-				if (mentionned && info.WhenMentioned) || !info.WhenMentioned {
-					// Look for !action or @bot action or DM with action
-					if strings.Contains(message.Text, prefix+c.Name) ||
-						(strings.HasPrefix(message.Text, fmt.Sprintf("<@%v> ", bot.ID)) && checkForCommand(message.Text, c.Name)) ||
-						(strings.HasPrefix(msg.Channel, "D") && strings.HasPrefix(strings.ToLower(message.Text), c.Name)) {
-
-						// Check context authorization
-						if !authz.IsGranted(c.Name, msg.User, msg.Channel, userChansID...) {
-							o := new(plugin.SlackResponse)
-							o.Channel = msg.Msg.Channel
-							o.Options = append(o.Options, slack.MsgOptionText(fmt.Sprintf("I'm sorry, %s I'm afraid I can't do that.", userInfo.RealName), false))
-
-							output <- o
-							return
-						}
-
-						zap.L().Debug("Dispatching to active plugin", zap.String("plugin", info.Name), zap.String("command", c.Name))
-						// Replace our prefixed action with the action
-						message.Text = strings.Replace(message.Text, prefix+c.Name, c.Name, 1)
-						p.ProcessMessage(c.Name, message)
-
-						// stop processing if active is matching
-						return
-					}
-				}
-			}
+// DispatchMessage to plugins
+func DispatchMessage(prefix string, msg *slack.MessageEvent, output chan *plugin.SlackResponse) {
 
-			// Process one or many passive triggers
-			for _, r := range info.PassiveTriggers {
-				// Check for mention if required by plugin
-				if (mentionned && info.WhenMentioned) || !info.WhenMentioned {
-
-					// Check if the user have permissions to use this plugin.
-					reg, err := regexp.Compile(r.Name)
-					if err != nil {
-						zap.L().Error("Passive trigger is not a valid regular expression", zap.String("trigger", r.Name), zap.String("plugin", info.Name))
-					} else {
-						matches := reg.FindAllString(message.Text, -1)
-						if len(matches) > 0 {
-							zap.L().Debug("Dispatching to passive plugin", zap.String("trigger", r.Name), zap.String("plugin", info.Name))
-							for _, m := range matches {
-								replied = p.ProcessMessage(m, message)
-							}
-						}
-					}
-				}
+	d := plugin.Dispatcher{
+		Bot:     &bot,
+		Prefix:  prefix,
+		Timeout: viper.GetDuration("bot.pluginTimeout"),
+		// Messages from allowed bots are only dispatched to plugins accepting them
+		IsBot:     plugin.IsBotMessage,
+		IsGranted: authz.IsGranted,
+		// Every direct message goes through the autorizer chat handler
+		// This is where the rbac is configured before plugins are called
+		// Plugins are managed the same way
+		DirectMessage: func(msg *slack.MessageEvent) []slack.MsgOption {
+			if response := AuthzHandleChat(msg); response != nil {
+				return response
 			}
+			return PluginsHandleChat(msg)
+		},
+		DefaultAnswers: defaultAnswers,
+	}
 
-		}
-
-		// If I was mentioned or in dm and nothing matched send a response
-		// From our default response list.
-		if (mentionned || strings.HasPrefix(msg.Channel, "D")) && !replied {
-			rand.Seed(time.Now().Unix())
-			o := new(plugin.SlackResponse)
-			o.Channel = message.Channel
-			o.Options = append(o.Options, slack.MsgOptionText(defaultAnswers[rand.Intn(len(defaultAnswers))], false))
-			output <- o
-		}
-
-	}()
-
+	d.Dispatch(msg, output)
 }
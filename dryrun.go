@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyrilPeponnet/slackhal/pkg/logutils"
+	"go.uber.org/zap"
+)
+
+/*
+Dry-run mode.
+
+The bot connects and dispatches as usual but the slack API calls having side
+effects (posting, updating, deleting, reacting, uploading...) are recorded as
+JSON lines instead of being sent. Calls reading data are sent to slack so
+plugins keep working with the real users and channels. Opening a conversation
+is not a read, a fake direct message channel is returned instead.
+
+Successful fake responses are returned so the bot and the plugins behave as if
+the calls were done.
+*/
+
+// dryRunSeeds are the databases of the live bot copied to the dry-run data
+// directory at startup, so the dry-run bot grants the same permissions and
+// the plugins start from the same state
+var dryRunSeeds = []string{"authz.db", "storage.db"}
+
+// readMethods are the slack methods sent to slack in dry-run mode, besides
+// the ones matched by readSuffixes
+var readMethods = map[string]bool{
+	"auth.test":             true,
+	"rtm.connect":           true,
+	"rtm.start":             true,
+	"chat.getPermalink":     true,
+	"users.lookupByEmail":   true,
+	"users.getPresence":     true,
+	"users.profile.get":     true,
+	"usergroups.users.list": true,
+}
+
+// readSuffixes are the suffixes of the slack methods reading data
+var readSuffixes = []string{".list", ".info", ".history", ".replies", ".members", ".conversations"}
+
+// dryRunTransport record the slack calls with side effects instead of sending them
+type dryRunTransport struct {
+	next     http.RoundTripper
+	recorder *zap.Logger
+	count    int
+	lock     sync.Mutex
+}
+
+// newDryRunClient return an http client recording the slack calls in file,
+// or in the bot log if file is not set
+func newDryRunClient(file string) *http.Client {
+
+	recorder := zap.L().Named("dryrun")
+	if file != "" {
+		recorder, _ = logutils.NewLogger("dryrun", "info", "json", file, true, false)
+	}
+
+	return &http.Client{Transport: &dryRunTransport{next: http.DefaultTransport, recorder: recorder}}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	method := path.Base(req.URL.Path)
+	if isReadMethod(method) {
+		return t.next.RoundTrip(req)
+	}
+
+	params, err := requestParams(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.lock.Lock()
+	t.count++
+	ts := fmt.Sprintf("%d.%06d", time.Now().Unix(), t.count%1000000)
+	id := fmt.Sprintf("DRYRUN%d", t.count)
+	t.lock.Unlock()
+
+	t.recorder.Info("Recorded slack call", zap.String("method", method), zap.String("ts", ts), zap.Reflect("params", params))
+
+	// Enough fields for every method the bot and the plugins rely on
+	response := map[string]interface{}{
+		"ok":                   true,
+		"channel":              params["channel"],
+		"ts":                   ts,
+		"message_ts":           ts,
+		"text":                 params["text"],
+		"scheduled_message_id": id,
+		"file":                 map[string]interface{}{"id": id, "permalink": "https://slack.invalid/dry-run/" + id},
+	}
+	if method == "conversations.open" {
+		response["channel"] = map[string]interface{}{"id": "D" + id, "is_im": true}
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// isReadMethod return if a slack method only read data
+func isReadMethod(method string) bool {
+	if readMethods[method] {
+		return true
+	}
+	for _, s := range readSuffixes {
+		if strings.HasSuffix(method, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestParams return the parameters of a slack call without the token
+func requestParams(req *http.Request) (map[string]string, error) {
+
+	params := map[string]string{}
+	values := req.URL.Query()
+
+	if req.Body != nil {
+		content, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		switch contentType := req.Header.Get("Content-Type"); {
+		case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+			form, err := url.ParseQuery(string(content))
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range form {
+				values[k] = v
+			}
+		case strings.HasPrefix(contentType, "application/json"):
+			params["json"] = string(content)
+		case len(content) > 0:
+			params["content_type"] = contentType
+			params["size"] = fmt.Sprint(len(content))
+		}
+	}
+
+	for k := range values {
+		if k != "token" {
+			params[k] = values.Get(k)
+		}
+	}
+
+	return params, nil
+}
+
+// seedDryRun copy the databases of the live data directory to the dry-run one,
+// replacing the copies of a previous run
+func seedDryRun(live, dir string) error {
+
+	for _, name := range dryRunSeeds {
+		src, dst := filepath.Join(live, name), filepath.Join(dir, name)
+		if src == dst {
+			continue
+		}
+		content, err := ioutil.ReadFile(src)
+		if os.IsNotExist(err) {
+			// Start empty like the live bot
+			if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// Never leave a partial copy
+		if err := ioutil.WriteFile(dst+".tmp", content, 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(dst+".tmp", dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
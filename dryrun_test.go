@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeedDryRun(t *testing.T) {
+
+	live, err := ioutil.TempDir("", "live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(live)
+	dir := filepath.Join(live, "dryrun")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		filepath.Join(live, "authz.db"):     "roles",
+		filepath.Join(live, "scheduler.db"): "jobs",
+		// Left by a previous run
+		filepath.Join(dir, "authz.db"):   "old roles",
+		filepath.Join(dir, "storage.db"): "old state",
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := seedDryRun(live, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// Expected content, empty if missing
+		want string
+	}{
+		{"authz.db", "roles"},
+		// Missing in the live directory
+		{"storage.db", ""},
+		// Not a seed
+		{"scheduler.db", ""},
+	}
+
+	for _, test := range tests {
+		content, _ := ioutil.ReadFile(filepath.Join(dir, test.name))
+		if string(content) != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, content, test.want)
+		}
+	}
+}
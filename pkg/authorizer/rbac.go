@@ -11,24 +11,32 @@ import (
 	"go.uber.org/zap"
 )
 
-type role struct {
+// Role grants its permissions and the ones of its parents
+type Role struct {
 	Name        string `storm:"id"`
 	Description string
-	Permissions []permission
+	Permissions []Permission
 	Parents     []string
 }
 
-type rolebinding struct {
+// RoleBinding bind an identity of a kind (user, channel, memberOf...) to roles
+type RoleBinding struct {
 	Name  string `storm:"id"`
 	Kind  string
 	Roles []string
 }
 
-type permission struct {
+// Permission is a named right checked by IsGranted
+type Permission struct {
 	Name        string `storm:"id"`
 	Description string
 }
 
+// Stored types, storm names the buckets after them
+type role Role
+type rolebinding RoleBinding
+type permission Permission
+
 // Authorizer is a authorizer type
 type Authorizer struct {
 	db   *storm.DB
@@ -276,7 +284,7 @@ func (a *Authorizer) RemovePermission(name string) error {
 		for i, p := range r.Permissions {
 			if p.Name == name {
 				r.Permissions[i] = r.Permissions[len(r.Permissions)-1]
-				r.Permissions[len(r.Permissions)-1] = permission{}
+				r.Permissions[len(r.Permissions)-1] = Permission{}
 				r.Permissions = r.Permissions[:len(r.Permissions)-1]
 				break
 			}
@@ -320,7 +328,7 @@ func (a *Authorizer) AttachPermission(permName string, roleName string) error {
 	}
 
 	// Add it
-	r.Permissions = append(r.Permissions, p)
+	r.Permissions = append(r.Permissions, Permission(p))
 
 	// Save
 	err = a.db.Save(&r)
@@ -353,7 +361,7 @@ func (a *Authorizer) DettachPermission(permName string, roleName string) error {
 	for i, cp := range r.Permissions {
 		if cp.Name == permName {
 			r.Permissions[i] = r.Permissions[len(r.Permissions)-1]
-			r.Permissions[len(r.Permissions)-1] = permission{}
+			r.Permissions[len(r.Permissions)-1] = Permission{}
 			r.Permissions = r.Permissions[:len(r.Permissions)-1]
 			break
 		}
@@ -393,7 +401,7 @@ func (a *Authorizer) AddRole(name string, description string, parents ...string)
 		r = role{
 			Name:        name,
 			Description: description,
-			Permissions: []permission{},
+			Permissions: []Permission{},
 			Parents:     parents,
 		}
 	}
@@ -450,40 +458,51 @@ func (a *Authorizer) RemoveRole(name string) (err error) {
 
 }
 
-// Dump will dump current data
-func (a *Authorizer) Dump() interface{} {
+// Snapshot contains the whole rbac data
+type Snapshot struct {
+	Roles       []Role
+	Bindings    []RoleBinding
+	Permissions []Permission
+}
+
+// Dump will dump current data, it returns nil if there is nothing set
+func (a *Authorizer) Dump() *Snapshot {
 
 	roleList := []role{}
 	if err := a.db.All(&roleList); err != nil {
-		return ""
+		zap.L().Error("Failed to get role list", zap.Error(err))
+		return nil
 	}
 
 	// List binding
 	rolebindings := []rolebinding{}
 	if err := a.db.All(&rolebindings); err != nil {
-		return ""
+		zap.L().Error("Failed to get rolebinding list", zap.Error(err))
+		return nil
 	}
 
 	// List permissions
 	permissions := []permission{}
 	if err := a.db.All(&permissions); err != nil {
-		return ""
-	}
-
-	data := struct {
-		Roles       []role
-		Bindings    []rolebinding
-		Permissions []permission
-	}{
-		roleList,
-		rolebindings,
-		permissions,
+		zap.L().Error("Failed to get permission list", zap.Error(err))
+		return nil
 	}
 
 	if len(roleList) == 0 && len(rolebindings) == 0 && len(permissions) == 0 {
 		return nil
 	}
 
+	data := &Snapshot{}
+	for _, r := range roleList {
+		data.Roles = append(data.Roles, Role(r))
+	}
+	for _, b := range rolebindings {
+		data.Bindings = append(data.Bindings, RoleBinding(b))
+	}
+	for _, p := range permissions {
+		data.Permissions = append(data.Permissions, Permission(p))
+	}
+
 	return data
 
 }
@@ -491,11 +510,7 @@ func (a *Authorizer) Dump() interface{} {
 // Load will Load current data
 func (a *Authorizer) Load(raw []byte) error {
 
-	data := struct {
-		Roles       []role
-		Bindings    []rolebinding
-		Permissions []permission
-	}{}
+	data := Snapshot{}
 
 	err := json.Unmarshal(raw, &data)
 	if err != nil {
@@ -503,19 +518,19 @@ func (a *Authorizer) Load(raw []byte) error {
 	}
 
 	for _, i := range data.Bindings {
-		if err := a.db.Save(&i); err != nil {
+		if err := a.db.Save((*rolebinding)(&i)); err != nil {
 			return err
 		}
 	}
 
 	for _, i := range data.Roles {
-		if err := a.db.Save(&i); err != nil {
+		if err := a.db.Save((*role)(&i)); err != nil {
 			return err
 		}
 	}
 
 	for _, i := range data.Permissions {
-		if err := a.db.Save(&i); err != nil {
+		if err := a.db.Save((*permission)(&i)); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,88 @@
+package authorizer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// newTestAuthorizer return an authorizer with a database in a temporary directory
+func newTestAuthorizer(t *testing.T) *Authorizer {
+	dir, err := ioutil.TempDir("", "authorizer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Authorizer{}
+	if err := a.Init(filepath.Join(dir, "authz.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.db.Close()
+		os.RemoveAll(dir)
+	})
+	return a
+}
+
+func TestDumpLoad(t *testing.T) {
+
+	a := newTestAuthorizer(t)
+	if a.Dump() != nil {
+		t.Error("got a snapshot without data")
+	}
+
+	if err := a.AddPermission("deploy", "Deploy the services"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddRole("ops", "Operators"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AttachPermission("deploy", "ops"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.BindToRole("user", "U1", "ops"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Snapshot{
+		Roles:       []Role{{Name: "ops", Description: "Operators", Permissions: []Permission{{Name: "deploy", Description: "Deploy the services"}}}},
+		Bindings:    []RoleBinding{{Name: "U1", Kind: "user", Roles: []string{"ops"}}},
+		Permissions: []Permission{{Name: "deploy", Description: "Deploy the services"}},
+	}
+	got := a.Dump()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got snapshot %+v, want %+v", got, want)
+	}
+
+	// The data stays in the buckets of the previous versions
+	err := a.db.Bolt.View(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{"role", "rolebinding", "permission"} {
+			if tx.Bucket([]byte(bucket)) == nil {
+				t.Errorf("bucket %s not found", bucket)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := newTestAuthorizer(t)
+	if err := loaded.Load(raw); err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Dump(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got loaded snapshot %+v, want %+v", got, want)
+	}
+	if !loaded.IsGranted("deploy", "U1", "C1") || loaded.IsGranted("deploy", "U2", "C1") {
+		t.Error("loaded permissions not applied")
+	}
+}
@@ -0,0 +1,292 @@
+// Package blocks is a small Block Kit message builder for plugins.
+//
+// Every builder keeps a plain text version of what has been added so the
+// message can always be sent as a simple text message when blocks cannot be
+// used (too many blocks, or a caller explicitly asking for text).
+package blocks
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/slack-go/slack"
+)
+
+// Slack limits enforced by the builder
+const (
+	// MaxBlocks is the maximum number of blocks in a message
+	MaxBlocks = 50
+	// MaxTextLength is the maximum length of a section text
+	MaxTextLength = 3000
+	// MaxFieldLength is the maximum length of a section field
+	MaxFieldLength = 2000
+	// MaxFields is the maximum number of fields in a section
+	MaxFields = 10
+	// MaxContextElements is the maximum number of elements in a context block
+	MaxContextElements = 10
+	// MaxButtons is the maximum number of buttons in an actions block
+	MaxButtons = 5
+	// MaxButtonTextLength is the maximum length of a button text
+	MaxButtonTextLength = 75
+	// MaxButtonValueLength is the maximum length of a button value
+	MaxButtonValueLength = 2000
+)
+
+const ellipsis = "…"
+
+// codeFence delimit the code sections
+const codeFence = "```"
+
+// Message is a Block Kit message being built
+type Message struct {
+	blocks   []slack.Block
+	fallback []string
+	overflow bool
+}
+
+// New return a new empty Message
+func New() *Message {
+	return &Message{}
+}
+
+// Section add a markdown section, a text longer than MaxTextLength is split
+// on line boundaries across several sections keeping code blocks balanced
+func (m *Message) Section(text string) *Message {
+	if text == "" {
+		return m
+	}
+	sections := []slack.Block{}
+	for _, part := range Split(text, MaxTextLength) {
+		sections = append(sections, slack.NewSectionBlock(markdown(part), nil, nil))
+	}
+	m.add(text, sections...)
+	return m
+}
+
+// Fields add a section made of fields displayed in two columns.
+// Fields beyond MaxFields are moved to new sections.
+func (m *Message) Fields(fields ...string) *Message {
+	for len(fields) > 0 {
+		n := len(fields)
+		if n > MaxFields {
+			n = MaxFields
+		}
+		objects := []*slack.TextBlockObject{}
+		for _, f := range fields[:n] {
+			objects = append(objects, markdown(Truncate(f, MaxFieldLength)))
+		}
+		m.add(strings.Join(fields[:n], "\n"), slack.NewSectionBlock(nil, objects, nil))
+		fields = fields[n:]
+	}
+	return m
+}
+
+// Table add a table rendered as an aligned code block, split on rows if too long
+func (m *Message) Table(headers []string, rows [][]string) *Message {
+	if len(rows) == 0 {
+		return m
+	}
+	buf := new(bytes.Buffer)
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	if len(headers) > 0 {
+		fmt.Fprintln(w, strings.Join(headers, "\t"))
+	}
+	for _, r := range rows {
+		fmt.Fprintln(w, strings.Join(r, "\t"))
+	}
+	w.Flush() // nolint
+	return m.Code(strings.TrimRight(buf.String(), "\n"))
+}
+
+// Code add a preformatted code section, a text longer than MaxTextLength is
+// split on line boundaries across several sections
+func (m *Message) Code(text string) *Message {
+	if text == "" {
+		return m
+	}
+	sections := []slack.Block{}
+	for _, part := range split(text, MaxTextLength-2*len(codeFence)) {
+		sections = append(sections, slack.NewSectionBlock(markdown(codeFence+part+codeFence), nil, nil))
+	}
+	m.add(codeFence+text+codeFence, sections...)
+	return m
+}
+
+// Context add a context block with small markdown elements
+func (m *Message) Context(elements ...string) *Message {
+	if len(elements) > MaxContextElements {
+		elements = elements[:MaxContextElements]
+	}
+	mixed := []slack.MixedElement{}
+	for _, e := range elements {
+		if e != "" {
+			mixed = append(mixed, markdown(Truncate(e, MaxTextLength)))
+		}
+	}
+	if len(mixed) == 0 {
+		return m
+	}
+	m.add("_"+strings.Join(elements, " | ")+"_", slack.NewContextBlock("", mixed...))
+	return m
+}
+
+// Divider add a divider
+func (m *Message) Divider() *Message {
+	m.add("---", slack.NewDividerBlock())
+	return m
+}
+
+// Button add a button. Consecutive buttons share the same actions block.
+func (m *Message) Button(actionID, value, text string) *Message {
+	b := slack.NewButtonBlockElement(actionID, Truncate(value, MaxButtonValueLength), plainText(Truncate(text, MaxButtonTextLength)))
+	if l := len(m.blocks); l > 0 {
+		if a, ok := m.blocks[l-1].(*slack.ActionBlock); ok && len(a.Elements.ElementSet) < MaxButtons {
+			a.Elements.ElementSet = append(a.Elements.ElementSet, b)
+			m.fallback[len(m.fallback)-1] += " [" + text + "]"
+			return m
+		}
+	}
+	m.add("["+text+"]", slack.NewActionBlock("", b))
+	return m
+}
+
+// Len return the number of blocks
+func (m *Message) Len() int {
+	return len(m.blocks)
+}
+
+// Overflow tell if the message exceed the number of blocks allowed
+func (m *Message) Overflow() bool {
+	return m.overflow
+}
+
+// Blocks return the blocks of the message
+func (m *Message) Blocks() []slack.Block {
+	return m.blocks
+}
+
+// Text return the plain text version of the message
+func (m *Message) Text() string {
+	return strings.Join(m.fallback, "\n")
+}
+
+// Options return the message options: blocks with the text as notification fallback
+// or only the text if the message cannot be sent as blocks.
+func (m *Message) Options() []slack.MsgOption {
+	if m.overflow || len(m.blocks) == 0 {
+		return m.TextOptions()
+	}
+	return []slack.MsgOption{slack.MsgOptionText(m.Text(), false), slack.MsgOptionBlocks(m.blocks...)}
+}
+
+// TextOptions return the message options for a plain text message
+func (m *Message) TextOptions() []slack.MsgOption {
+	return []slack.MsgOption{slack.MsgOptionText(m.Text(), false)}
+}
+
+// Response return a SlackResponse for the given channel
+func (m *Message) Response(channel string) *plugin.SlackResponse {
+	o := new(plugin.SlackResponse)
+	o.Channel = channel
+	o.Options = m.Options()
+	return o
+}
+
+// add blocks and their text version
+func (m *Message) add(text string, blocks ...slack.Block) {
+	m.fallback = append(m.fallback, text)
+	for _, b := range blocks {
+		if len(m.blocks) >= MaxBlocks {
+			m.overflow = true
+			return
+		}
+		m.blocks = append(m.blocks, b)
+	}
+}
+
+// split a text in parts of at most max runes on line boundaries, lines too long are cut
+func split(text string, max int) (parts []string) {
+
+	var current strings.Builder
+	length := 0
+
+	flush := func() {
+		if part := strings.TrimSuffix(current.String(), "\n"); strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+		current.Reset()
+		length = 0
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		for utf8.RuneCountInString(line) > max {
+			flush()
+			runes := []rune(line)
+			parts = append(parts, string(runes[:max]))
+			line = string(runes[max:])
+		}
+		l := utf8.RuneCountInString(line)
+		if length+l > max {
+			flush()
+		}
+		current.WriteString(line)
+		length += l
+	}
+	flush()
+
+	return parts
+}
+
+// Split a text in parts of at most max runes on line boundaries, lines too long are cut.
+// Code blocks spanning several parts are closed at the end of a part and opened again
+// in the next one. Parts do not end with a new line and blank parts are dropped.
+func Split(text string, max int) []string {
+	// Room to close and open again a code block
+	limit := max - 2*(len(codeFence)+1)
+	if limit < 1 {
+		limit = 1
+	}
+	return fenced(split(text, limit))
+}
+
+// fenced close the code blocks left open at the end of a part and open them again in the next one
+func fenced(parts []string) []string {
+	open := false
+	for i, part := range parts {
+		if open {
+			part = codeFence + "\n" + part
+		}
+		open = strings.Count(part, codeFence)%2 == 1
+		if open {
+			part += "\n" + codeFence
+		}
+		parts[i] = part
+	}
+	return parts
+}
+
+// Truncate a text to max runes adding an ellipsis if needed
+func Truncate(text string, max int) string {
+	r := []rune(text)
+	switch {
+	case len(r) <= max:
+		return text
+	case max <= 0:
+		return ""
+	case max == 1:
+		return ellipsis
+	}
+	return string(r[:max-1]) + ellipsis
+}
+
+func markdown(text string) *slack.TextBlockObject {
+	return slack.NewTextBlockObject(slack.MarkdownType, text, false, false)
+}
+
+func plainText(text string) *slack.TextBlockObject {
+	return slack.NewTextBlockObject(slack.PlainTextType, text, true, false)
+}
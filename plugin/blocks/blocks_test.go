@@ -0,0 +1,148 @@
+package blocks
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+)
+
+// sectionTexts return the texts of the section blocks of a message
+func sectionTexts(m *Message) (texts []string) {
+	for _, b := range m.Blocks() {
+		if s, ok := b.(*slack.SectionBlock); ok && s.Text != nil {
+			texts = append(texts, s.Text.Text)
+		}
+	}
+	return
+}
+
+func TestSplitLongContent(t *testing.T) {
+
+	rows := [][]string{}
+	for i := 0; i < 200; i++ {
+		rows = append(rows, []string{"role", strings.Repeat("permission", 3)})
+	}
+	long := strings.Repeat(strings.Repeat("word ", 30)+"\n", 40)
+
+	tests := []struct {
+		name    string
+		message *Message
+		// Every line expected in the rendered blocks
+		lines []string
+		fence bool
+	}{
+		{"section", New().Section(long), strings.Split(strings.TrimSpace(long), "\n"), false},
+		{"code", New().Code(long), strings.Split(strings.TrimSpace(long), "\n"), true},
+		{"table", New().Table([]string{"NAME", "PERMISSIONS"}, rows), nil, true},
+		{"section with code", New().Section("Roles:\n```\n" + long + "```"), strings.Split(strings.TrimSpace(long), "\n"), false},
+	}
+
+	for _, test := range tests {
+		texts := sectionTexts(test.message)
+		if len(texts) < 2 {
+			t.Errorf("%s: not split, got %d sections", test.name, len(texts))
+			continue
+		}
+		rendered := strings.Join(texts, "\n")
+		for i, text := range texts {
+			if l := utf8.RuneCountInString(text); l > MaxTextLength {
+				t.Errorf("%s: section %d is %d long", test.name, i, l)
+			}
+			if strings.Count(text, codeFence)%2 != 0 {
+				t.Errorf("%s: section %d has unbalanced code blocks", test.name, i)
+			}
+			if test.fence && (!strings.HasPrefix(text, codeFence) || !strings.HasSuffix(text, codeFence)) {
+				t.Errorf("%s: section %d is not a code block", test.name, i)
+			}
+			if strings.Contains(text, ellipsis) {
+				t.Errorf("%s: section %d is truncated", test.name, i)
+			}
+		}
+		for _, line := range test.lines {
+			if !strings.Contains(rendered, strings.TrimSpace(line)) {
+				t.Errorf("%s: line %q lost", test.name, line)
+				break
+			}
+		}
+		if test.name == "table" && strings.Count(rendered, "role") != len(rows) {
+			t.Errorf("%s: got %d rows, want %d", test.name, strings.Count(rendered, "role"), len(rows))
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+
+	tests := []struct {
+		text string
+		max  int
+		want []string
+	}{
+		{"short", 10, []string{"short"}},
+		{"one\ntwo\nthree", 8, []string{"one\ntwo", "three"}},
+		{"one\ntwo\nthree\n", 5, []string{"one", "two", "three"}},
+		{"abcdefghij\nk", 4, []string{"abcd", "efgh", "ij\nk"}},
+		{"\n\n", 4, nil},
+		{"ééééé", 2, []string{"éé", "éé", "é"}},
+	}
+
+	for _, test := range tests {
+		got := split(test.text, test.max)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") || len(got) != len(test.want) {
+			t.Errorf("%q by %d: got %q, want %q", test.text, test.max, got, test.want)
+		}
+	}
+}
+
+func TestSplitCodeBlocks(t *testing.T) {
+
+	code := "```\n" + strings.Repeat("line of code\n", 6) + "```"
+
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want []string
+	}{
+		{"short", "hello\nworld", 20, []string{"hello\nworld"}},
+		{"lines", "one\ntwo\nthree", 13, []string{"one", "two", "three"}},
+		{"long line", strings.Repeat("a", 10), 12, []string{"aaaa", "aaaa", "aa"}},
+		{"code", "before\n" + code + "\nafter", 40, []string{
+			"before\n```\nline of code\n```",
+			"```\nline of code\nline of code\n```",
+			"```\nline of code\nline of code\n```",
+			"```\nline of code\n```\nafter",
+		}},
+		{"inline code", "a `b` c\n```x``` y\nz", 20, []string{"a `b` c", "```x``` y\nz"}},
+	}
+
+	for _, test := range tests {
+		got := Split(test.text, test.max)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+		for i, part := range got {
+			if l := utf8.RuneCountInString(part); l > test.max {
+				t.Errorf("%s: part %d is %d long", test.name, i, l)
+			}
+			if strings.Count(part, codeFence)%2 != 0 {
+				t.Errorf("%s: part %d has unbalanced code blocks: %q", test.name, i, part)
+			}
+		}
+	}
+}
+
+func TestOverflow(t *testing.T) {
+
+	m := New()
+	for i := 0; i < MaxBlocks+1; i++ {
+		m.Divider()
+	}
+	if !m.Overflow() || m.Len() != MaxBlocks {
+		t.Errorf("got %d blocks, overflow %v", m.Len(), m.Overflow())
+	}
+	if len(m.Options()) != 1 {
+		t.Error("overflowing message not sent as text")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/karlseguin/ccache"
@@ -13,15 +14,29 @@ import (
 
 // Bot is the bot structure
 type Bot struct {
-	API              *slack.Client
-	RTM              *slack.RTM
-	Name             string
-	ID               string
-	Tracker          TrackerManager
+	API        *slack.Client
+	RTM        *slack.RTM
+	Name       string
+	ID         string
+	Tracker    TrackerManager
+	Scheduler  Scheduler
+	Translator Translator
+	Storage    Storage
+	Loggers    Loggers
+	// Directory of the data of the bot, where plugins keep their databases
+	DataDir string
+	// The bot configuration file, read for the plugins configuration
+	ConfigFile       string
+	configs          configs
 	cachedUserInfos  *ccache.Cache
 	cachedUserChans  *ccache.Cache
 	cachedChanInfos  *ccache.Cache
 	cachedGroupInfos *ccache.Cache
+	cachedBotInfos   *ccache.Cache
+	cachedDirectory  *ccache.Cache
+	cachedDMs        *ccache.Cache
+	// Create the caches of the resolution of destinations
+	resolveCaches sync.Once
 }
 
 // FeatureType represent a feature
@@ -161,14 +176,14 @@ func (s *Bot) GetCachedUserChans(user string) ([]slack.Channel, error) {
 	// If not set build it
 	if items == nil {
 
-		for {
-			p := slack.GetConversationsForUserParameters{
-				UserID:          user,
-				Types:           []string{"public_channel,private_channel"},
-				Limit:           0,
-				ExcludeArchived: true,
-			}
+		p := slack.GetConversationsForUserParameters{
+			UserID:          user,
+			Types:           []string{"public_channel,private_channel"},
+			Limit:           0,
+			ExcludeArchived: true,
+		}
 
+		for {
 			currentChans, n, err := s.API.GetConversationsForUser(&p)
 
 			if err != nil {
@@ -298,3 +313,29 @@ func (s *Bot) GetCachedGroupInfos(group string) ([]string, error) {
 	return nil, fmt.Errorf("Cannot cast cache members to slack.UserGroup")
 
 }
+
+// GetCachedBotInfos - Find bot info for a bot id
+func (s *Bot) GetCachedBotInfos(botID string) (slack.Bot, error) {
+	if s.cachedBotInfos == nil {
+		s.cachedBotInfos = ccache.New(ccache.Configure().MaxSize(1000).ItemsToPrune(100))
+	}
+	item := s.cachedBotInfos.Get(botID)
+
+	// if item is nil get it from API
+	if item == nil {
+		infos, err := s.API.GetBotInfo(botID)
+		if err != nil {
+			zap.L().Error("Error while getting bot info", zap.String("bot", botID), zap.Error(err))
+			return slack.Bot{}, err
+		}
+		s.cachedBotInfos.Set(botID, infos, 24*time.Hour)
+		return *infos, nil
+	}
+
+	if infos, ok := item.Value().(*slack.Bot); ok {
+		return *infos, nil
+	}
+
+	return slack.Bot{}, fmt.Errorf("Cannot cast cache item to slack.Bot")
+
+}
@@ -0,0 +1,395 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+/*
+Configuration of the plugins.
+
+The configuration of a plugin is read, by increasing precedence, from:
+- the defaults set by the plugin
+- the plugins.<name> section of the bot configuration file
+- the plugin-<name> file (yml, json or toml) in one of the ConfigPaths
+- the SLACKHAL_PLUGINS_<NAME>_<KEY> environment variables, like SLACKHAL_PLUGINS_REMINDERS_LATE
+
+Unmarshal sees the environment variables of the keys having a value or a
+default, and of the fields of the struct it decodes.
+
+Files are watched and the configuration is reloaded when they change.
+*/
+
+// ConfigPaths are the directories where the configuration files of the plugins are looked for
+var ConfigPaths = []string{"/etc/slackhal/", "$HOME/.slackhal", "."}
+
+// Validator is implemented by configuration structs checking their values
+type Validator interface {
+	Validate() error
+}
+
+// Config is the configuration of a plugin
+type Config struct {
+	name string
+	// The bot configuration file
+	mainFile string
+	defaults map[string]interface{}
+	// Keys bound to their environment variable
+	env    map[string]bool
+	values *viper.Viper
+	// The plugin configuration file used if any
+	file     string
+	handlers []func(*Config) error
+	lock     sync.RWMutex
+}
+
+// configs are the configurations of the plugins
+type configs struct {
+	configs map[string]*Config
+	watcher sync.Once
+	lock    sync.Mutex
+}
+
+// Config return the configuration of a plugin
+func (s *Bot) Config(name string) *Config {
+
+	s.configs.lock.Lock()
+	defer s.configs.lock.Unlock()
+
+	if c, ok := s.configs.configs[name]; ok {
+		return c
+	}
+
+	c := &Config{name: name, mainFile: s.ConfigFile, defaults: map[string]interface{}{}}
+	if err := c.Reload(); err != nil {
+		zap.L().Error("Cannot read plugin configuration", zap.String("plugin", name), zap.Error(err))
+		c.values = c.newViper()
+	}
+
+	if s.configs.configs == nil {
+		s.configs.configs = map[string]*Config{}
+	}
+	s.configs.configs[name] = c
+
+	s.configs.watcher.Do(s.watchConfigs)
+
+	return c
+}
+
+// SetDefault set the default value of a key
+func (c *Config) SetDefault(key string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.defaults[key] = value
+	c.values.SetDefault(key, value)
+}
+
+// Get return the value of a key
+func (c *Config) Get(key string) interface{} {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.values.Get(key)
+}
+
+// GetString return the value of a key as a string
+func (c *Config) GetString(key string) string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.values.GetString(key)
+}
+
+// GetBool return the value of a key as a bool
+func (c *Config) GetBool(key string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.values.GetBool(key)
+}
+
+// GetInt return the value of a key as an int
+func (c *Config) GetInt(key string) int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.values.GetInt(key)
+}
+
+// GetDuration return the value of a key as a duration
+func (c *Config) GetDuration(key string) time.Duration {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.values.GetDuration(key)
+}
+
+// GetStringSlice return the value of a key as a slice of strings
+func (c *Config) GetStringSlice(key string) []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.values.GetStringSlice(key)
+}
+
+// IsSet return if a key is set
+func (c *Config) IsSet(key string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.values.IsSet(key)
+}
+
+// Unmarshal decode the configuration in v, v is validated if it is a Validator.
+// The fields of v are bound to their environment variable.
+func (c *Config) Unmarshal(v interface{}) error {
+	c.lock.Lock()
+	c.bindEnv(structKeys("", reflect.TypeOf(v)))
+	err := c.values.Unmarshal(v)
+	c.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return validate(v)
+}
+
+// UnmarshalKey decode the value of a key in v, v is validated if it is a Validator.
+// The fields of v are bound to their environment variable.
+func (c *Config) UnmarshalKey(key string, v interface{}) error {
+	c.lock.Lock()
+	c.bindEnv(structKeys(key, reflect.TypeOf(v)))
+	err := c.values.UnmarshalKey(key, v)
+	c.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return validate(v)
+}
+
+// bindEnv bind keys to their environment variable, now and on reload.
+// The lock must be held.
+func (c *Config) bindEnv(keys []string) {
+	if c.env == nil {
+		c.env = map[string]bool{}
+	}
+	for _, k := range keys {
+		c.env[k] = true
+		c.values.BindEnv(k)
+	}
+}
+
+// structKeys return the keys of the fields of a struct, nested under prefix
+func structKeys(prefix string, t reflect.Type) (keys []string) {
+
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		if prefix != "" {
+			keys = append(keys, prefix)
+		}
+		return keys
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		// Unexported
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("mapstructure"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		key := strings.ToLower(name)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		keys = append(keys, structKeys(key, f.Type)...)
+	}
+	return keys
+}
+
+// OnChange register a function called when the configuration changed.
+// The previous configuration is restored if a function returns an error,
+// and the functions which accepted the new one are called again with it.
+func (c *Config) OnChange(f func(c *Config) error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.handlers = append(c.handlers, f)
+}
+
+// Reload read the configuration again and notify the changes if any
+func (c *Config) Reload() error {
+
+	values, file, err := c.load()
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	previous := c.values
+	c.values = values
+	c.file = file
+	handlers := c.handlers
+	c.lock.Unlock()
+
+	if previous == nil || reflect.DeepEqual(previous.AllSettings(), values.AllSettings()) {
+		return nil
+	}
+
+	zap.L().Info("Plugin configuration changed", zap.String("plugin", c.name))
+
+	for i, f := range handlers {
+		if err := Protect(func() error { return f(c) }); err != nil {
+			c.lock.Lock()
+			c.values = previous
+			c.lock.Unlock()
+			// Revert the handlers already notified
+			for _, applied := range handlers[:i] {
+				if err := Protect(func() error { return applied(c) }); err != nil {
+					zap.L().Error("Cannot restore previous plugin configuration", zap.String("plugin", c.name), zap.Error(err))
+				}
+			}
+			return fmt.Errorf("configuration rejected: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// newViper return a viper instance with the defaults and the environment variables of the plugin
+func (c *Config) newViper() *viper.Viper {
+	v := viper.New()
+	v.SetEnvPrefix("SLACKHAL_PLUGINS_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(c.name)))
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	c.lock.RLock()
+	for k, d := range c.defaults {
+		v.SetDefault(k, d)
+	}
+	for k := range c.env {
+		v.BindEnv(k)
+	}
+	c.lock.RUnlock()
+	return v
+}
+
+// load read the configuration and return it with the plugin configuration file used if any
+func (c *Config) load() (*viper.Viper, string, error) {
+
+	v := c.newViper()
+
+	if c.mainFile != "" {
+		main := viper.New()
+		main.SetConfigFile(c.mainFile)
+		if err := main.ReadInConfig(); err != nil {
+			return nil, "", err
+		}
+		if section := main.GetStringMap("plugins." + c.name); len(section) > 0 {
+			if err := v.MergeConfigMap(section); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	sidecar := viper.New()
+	for _, p := range ConfigPaths {
+		sidecar.AddConfigPath(p)
+	}
+	sidecar.SetConfigName("plugin-" + c.name)
+	err := sidecar.ReadInConfig()
+	if _, notFound := err.(viper.ConfigFileNotFoundError); notFound {
+		return v, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if err := v.MergeConfigMap(sidecar.AllSettings()); err != nil {
+		return nil, "", err
+	}
+
+	return v, sidecar.ConfigFileUsed(), nil
+}
+
+// validate v if it is a Validator
+func validate(v interface{}) error {
+	if validator, ok := v.(Validator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+// watchConfigs reload the configurations of the plugins when their files change
+func (s *Bot) watchConfigs() {
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		zap.L().Error("Cannot watch plugins configuration", zap.Error(err))
+		return
+	}
+
+	// Directories are watched as editors often replace files
+	dirs := map[string]bool{}
+	for _, p := range ConfigPaths {
+		dirs[filepath.Clean(os.ExpandEnv(p))] = true
+	}
+	if s.ConfigFile != "" {
+		dirs[filepath.Dir(s.ConfigFile)] = true
+	}
+	for dir := range dirs {
+		if _, err := os.Stat(dir); err == nil {
+			if err := watcher.Add(dir); err != nil {
+				zap.L().Warn("Cannot watch directory", zap.String("directory", dir), zap.Error(err))
+			}
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				s.reloadConfigs(event.Name)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				zap.L().Warn("Error while watching plugins configuration", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// reloadConfigs reload the configurations read from a file
+func (s *Bot) reloadConfigs(file string) {
+
+	file, _ = filepath.Abs(file)
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	main, _ := filepath.Abs(s.ConfigFile)
+
+	s.configs.lock.Lock()
+	configs := []*Config{}
+	for _, c := range s.configs.configs {
+		if file == main || name == "plugin-"+c.name {
+			configs = append(configs, c)
+		}
+	}
+	s.configs.lock.Unlock()
+
+	for _, c := range configs {
+		if err := c.Reload(); err != nil {
+			zap.L().Error("Cannot reload plugin configuration", zap.String("plugin", c.name), zap.String("file", file), zap.Error(err))
+		}
+	}
+}
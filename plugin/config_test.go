@@ -0,0 +1,236 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestConfig return the configuration of a plugin read from a bot configuration file
+// and the plugin configuration files of a temporary directory, which is returned.
+// Files are not watched, the tests reload the configuration.
+func newTestConfig(t *testing.T, name string, main string) (*Config, string) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPaths := ConfigPaths
+	ConfigPaths = []string{dir}
+	t.Cleanup(func() {
+		ConfigPaths = configPaths
+		os.RemoveAll(dir)
+	})
+	writeFile(t, filepath.Join(dir, "slackhal.yml"), main)
+	c := &Config{name: name, mainFile: filepath.Join(dir, "slackhal.yml"), defaults: map[string]interface{}{}}
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	return c, dir
+}
+
+// writeFile write content to a file
+func writeFile(t *testing.T, path string, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setenv set an environment variable until the test ends
+func setenv(t *testing.T, key string, value string) {
+	previous, set := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if set {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// testConfiguration is the configuration of a test plugin
+type testConfiguration struct {
+	Greeting string
+	Repeat   int
+	Delay    time.Duration
+	Channels []string
+	Database struct {
+		Path string
+	}
+}
+
+// Validate interface implementation
+func (c *testConfiguration) Validate() error {
+	if c.Repeat < 1 {
+		return fmt.Errorf("repeat must be positive")
+	}
+	return nil
+}
+
+func TestConfigPrecedence(t *testing.T) {
+
+	c, dir := newTestConfig(t, "greeter", `
+bot:
+  token: xoxb
+plugins:
+  greeter:
+    greeting: hello from section
+    repeat: 2
+    channels: [general]
+  other:
+    greeting: not mine
+`)
+	c.SetDefault("greeting", "hello from default")
+	c.SetDefault("repeat", 1)
+	c.SetDefault("delay", "1s")
+	c.SetDefault("database.path", "greeter.db")
+
+	writeFile(t, filepath.Join(dir, "plugin-greeter.yml"), "repeat: 3\ndelay: 2s\n")
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, "SLACKHAL_PLUGINS_GREETER_DELAY", "4s")
+	setenv(t, "SLACKHAL_PLUGINS_GREETER_DATABASE_PATH", "/var/lib/greeter.db")
+
+	tests := []struct {
+		key  string
+		want interface{}
+	}{
+		{"greeting", "hello from section"},
+		{"repeat", 3},
+		{"delay", 4 * time.Second},
+		{"channels", []string{"general"}},
+		{"database.path", "/var/lib/greeter.db"},
+	}
+	for _, test := range tests {
+		var got interface{}
+		switch test.want.(type) {
+		case int:
+			got = c.GetInt(test.key)
+		case time.Duration:
+			got = c.GetDuration(test.key)
+		case []string:
+			got = c.GetStringSlice(test.key)
+		default:
+			got = c.GetString(test.key)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got %s %v, want %v", test.key, got, test.want)
+		}
+	}
+	if c.IsSet("token") {
+		t.Error("bot configuration visible to the plugin")
+	}
+
+	// The same precedence applies to the decoded structs
+	conf := testConfiguration{}
+	if err := c.Unmarshal(&conf); err != nil {
+		t.Fatal(err)
+	}
+	want := testConfiguration{Greeting: "hello from section", Repeat: 3, Delay: 4 * time.Second, Channels: []string{"general"}}
+	want.Database.Path = "/var/lib/greeter.db"
+	if !reflect.DeepEqual(conf, want) {
+		t.Errorf("got %+v, want %+v", conf, want)
+	}
+
+	// Fields without value nor default are bound to the environment once decoded
+	setenv(t, "SLACKHAL_PLUGINS_GREETER_GREETING", "hello from env")
+	if err := c.Unmarshal(&conf); err != nil || conf.Greeting != "hello from env" {
+		t.Errorf("got greeting %q, %v", conf.Greeting, err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+
+	c, dir := newTestConfig(t, "greeter", "plugins:\n  greeter:\n    repeat: 0\n")
+
+	conf := testConfiguration{}
+	if err := c.Unmarshal(&conf); err == nil || !strings.Contains(err.Error(), "repeat must be positive") {
+		t.Errorf("got error %v", err)
+	}
+
+	writeFile(t, filepath.Join(dir, "plugin-greeter.yml"), "repeat: 2\n")
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UnmarshalKey("repeat", &conf.Repeat); err != nil || conf.Repeat != 2 {
+		t.Errorf("got repeat %d, %v", conf.Repeat, err)
+	}
+
+	// Invalid files are not applied
+	writeFile(t, filepath.Join(dir, "plugin-greeter.yml"), "repeat: [\n")
+	if err := c.Reload(); err == nil {
+		t.Error("expected an error for an invalid file")
+	}
+	if got := c.GetInt("repeat"); got != 2 {
+		t.Errorf("got repeat %d after an invalid file", got)
+	}
+}
+
+func TestConfigOnChange(t *testing.T) {
+
+	c, dir := newTestConfig(t, "greeter", "plugins:\n  greeter:\n    repeat: 1\n")
+
+	// Repeat values seen by the handlers
+	first, second := []int{}, []int{}
+	c.OnChange(func(c *Config) error {
+		conf := testConfiguration{}
+		if err := c.Unmarshal(&conf); err != nil {
+			return err
+		}
+		first = append(first, conf.Repeat)
+		return nil
+	})
+	c.OnChange(func(c *Config) error {
+		if c.GetInt("repeat") > 5 {
+			return errors.New("too many repeats")
+		}
+		second = append(second, c.GetInt("repeat"))
+		return nil
+	})
+	c.OnChange(func(c *Config) error {
+		if c.GetInt("repeat") == 4 {
+			panic("unlucky")
+		}
+		return nil
+	})
+
+	tests := []struct {
+		name   string
+		config string
+		err    string
+		repeat int
+		first  []int
+		second []int
+	}{
+		{"unchanged", "repeat: 1\n", "", 1, []int{}, []int{}},
+		{"accepted", "repeat: 2\n", "", 2, []int{2}, []int{2}},
+		{"rejected by validation", "repeat: 0\n", "repeat must be positive", 2, []int{2}, []int{2}},
+		{"rejected after notified", "repeat: 6\n", "too many repeats", 2, []int{2, 6, 2}, []int{2}},
+		{"handler panic", "repeat: 4\n", "unlucky", 2, []int{2, 6, 2, 4, 2}, []int{2, 4, 2}},
+		{"accepted after rejection", "repeat: 3\n", "", 3, []int{2, 6, 2, 4, 2, 3}, []int{2, 4, 2, 3}},
+	}
+
+	for _, test := range tests {
+		writeFile(t, filepath.Join(dir, "plugin-greeter.yml"), test.config)
+		err := c.Reload()
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+		if got := c.GetInt("repeat"); got != test.repeat {
+			t.Errorf("%s: got repeat %d, want %d", test.name, got, test.repeat)
+		}
+		if !reflect.DeepEqual(first, test.first) || !reflect.DeepEqual(second, test.second) {
+			t.Errorf("%s: got notifications %v %v, want %v %v", test.name, first, second, test.first, test.second)
+		}
+	}
+}
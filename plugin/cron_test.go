@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"30-10 * * * *",
+		"* * * foo *",
+		"@every",
+		"@every 1x",
+		"@every 500ms",
+		"@sometimes",
+	}
+
+	for _, spec := range tests {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%q: parsed", spec)
+		}
+	}
+}
+
+func TestParseField(t *testing.T) {
+
+	tests := []struct {
+		field  string
+		bounds bounds
+		want   []uint
+	}{
+		{"*", hourBounds, []uint{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23}},
+		{"5", minuteBounds, []uint{5}},
+		{"1,15,30", minuteBounds, []uint{1, 15, 30}},
+		{"9-12", hourBounds, []uint{9, 10, 11, 12}},
+		{"*/15", minuteBounds, []uint{0, 15, 30, 45}},
+		{"10-20/5", minuteBounds, []uint{10, 15, 20}},
+		{"50/4", minuteBounds, []uint{50, 54, 58}},
+		{"*/5", domBounds, []uint{1, 6, 11, 16, 21, 26, 31}},
+		{"jan,JUN-aug", monthBounds, []uint{1, 6, 7, 8}},
+		{"mon-fri", dowBounds, []uint{1, 2, 3, 4, 5}},
+		{"1-3,20-22", domBounds, []uint{1, 2, 3, 20, 21, 22}},
+	}
+
+	for _, test := range tests {
+		bits, err := parseField(test.field, test.bounds)
+		if err != nil {
+			t.Errorf("%q: %v", test.field, err)
+			continue
+		}
+		want := uint64(0)
+		for _, v := range test.want {
+			want |= 1 << v
+		}
+		if bits != want {
+			t.Errorf("%q: got %b, want %b", test.field, bits, want)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no timezone database:", err)
+	}
+
+	// A wednesday
+	now := time.Date(2020, 5, 13, 10, 30, 20, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", now, time.Date(2020, 5, 13, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", now, time.Date(2020, 5, 13, 10, 45, 0, 0, time.UTC)},
+		{"0,30 * * * *", now, time.Date(2020, 5, 13, 11, 0, 0, 0, time.UTC)},
+		{"0 9-17 * * *", now, time.Date(2020, 5, 13, 11, 0, 0, 0, time.UTC)},
+		{"0 9 * * *", now, time.Date(2020, 5, 14, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2020, 5, 15, 10, 0, 0, 0, time.UTC), time.Date(2020, 5, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", now, time.Date(2020, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", now, time.Date(2020, 5, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2020, 5, 31, 12, 0, 0, 0, time.UTC), time.Date(2020, 7, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", now, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Either the day of month or the day of week when both are restricted
+		{"0 0 1 * fri", now, time.Date(2020, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", now, time.Date(2020, 5, 13, 11, 0, 0, 0, time.UTC)},
+		{"@daily", now, time.Date(2020, 5, 14, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", now, time.Date(2020, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", now, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", now, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", now, time.Date(2020, 5, 13, 10, 31, 50, 0, time.UTC)},
+		{"@every 1h", now, time.Date(2020, 5, 13, 11, 30, 20, 0, time.UTC)},
+		// Evaluated in the location of the time
+		{"0 9 * * *", now.In(paris), time.Date(2020, 5, 14, 9, 0, 0, 0, paris)},
+	}
+
+	for _, test := range tests {
+		s, err := ParseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+			continue
+		}
+		if got := s.Next(test.from); !got.Equal(test.want) {
+			t.Errorf("%q from %s: got %s, want %s", test.spec, test.from, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+)
+
+// SchedulerIdentity is the RBAC identity of the commands sent by the bot itself, like the scheduled ones
+const SchedulerIdentity = "scheduler"
+
+// Dispatcher dispatch the messages to the plugins
+type Dispatcher struct {
+	Bot    *Bot
+	Prefix string
+	// The plugins to dispatch to, the ones of PluginManager if nil
+	Plugins map[string]Plugin
+	// Timeout of a plugin call, none if zero
+	Timeout time.Duration
+	// IsGranted tell if an identity can use a command in a channel, everything is granted if nil
+	IsGranted func(permission, identity string, channel string, memberOf ...string) bool
+	// DirectMessage handle the direct messages before the plugins and return the options of the response if any
+	DirectMessage func(msg *slack.MessageEvent) []slack.MsgOption
+	// IsBot tell if a message was sent by a bot, like IsBotMessage, messages from bots are only dispatched to plugins accepting them
+	IsBot func(msg *slack.MessageEvent) bool
+	// DefaultAnswers are used when the bot is mentioned and no plugin replied
+	DefaultAnswers []string
+}
+
+// IsBotMessage tell if a message, or the new version of an edited message, was sent by a bot
+func IsBotMessage(msg *slack.MessageEvent) bool {
+	if msg.SubType == "message_changed" && msg.SubMessage != nil {
+		return msg.SubMessage.SubType == "bot_message" || msg.SubMessage.BotID != ""
+	}
+	return msg.SubType == "bot_message" || msg.BotID != ""
+}
+
+// Dispatch a message to the plugins
+func (d *Dispatcher) Dispatch(msg *slack.MessageEvent, output chan<- *SlackResponse) {
+
+	bot := d.Bot
+
+	fromBot := d.IsBot != nil && d.IsBot(msg)
+
+	// Check if this is an edited message
+	// if so fill up as if it was a message
+	if msg.SubType == "message_changed" && msg.SubMessage != nil {
+		msg.Msg.Text = msg.SubMessage.Text
+		msg.User = msg.SubMessage.User
+		msg.BotID = msg.SubMessage.BotID
+		msg.Timestamp = msg.SubMessage.Timestamp
+	}
+
+	// Build our authz context once if not set
+	// Bots are identified by their bot id and the commands sent by the bot have their own identity
+	identity := msg.User
+	userChansID := []string{}
+	userInfo := slack.User{}
+
+	if msg.User == bot.ID {
+		identity = SchedulerIdentity
+	}
+
+	if fromBot {
+		identity = msg.BotID
+		userInfo.RealName = msg.Username
+	} else {
+		ch, err := bot.GetCachedUserChans(msg.User)
+		if err != nil {
+			return
+		}
+		for _, c := range ch {
+			userChansID = append(userChansID, c.ID)
+		}
+
+		userInfo, err = bot.GetCachedUserInfos(msg.User)
+		if err != nil {
+			return
+		}
+	}
+
+	// Every direct message goes through the direct message handler first
+	if strings.HasPrefix(msg.Channel, "D") && !fromBot && d.DirectMessage != nil {
+		if response := d.DirectMessage(msg); response != nil {
+			o := new(SlackResponse)
+			o.Channel = msg.Msg.Channel
+			o.Options = response
+			output <- o
+			return
+		}
+	}
+
+	message := msg.Msg
+
+	// mentionned is true id direct message or message contains mention to us
+	mentionned := strings.HasPrefix(msg.Channel, "D") || strings.Contains(message.Text, fmt.Sprintf("<@%v>", bot.ID))
+
+	// Build the context and the request shared by plugins
+	ctx := WithCorrelationID(context.Background(), NewCorrelationID())
+
+	channelInfo, err := bot.GetCachedChanInfos(msg.Channel)
+	if err != nil {
+		channelInfo = slack.Channel{}
+		channelInfo.ID = msg.Channel
+	}
+
+	request := Request{
+		User:      userInfo,
+		Channel:   channelInfo,
+		Thread:    message.ThreadTimestamp,
+		Mentioned: mentionned,
+	}
+
+	plugins := d.Plugins
+	if plugins == nil {
+		plugins = PluginManager.All()
+	}
+
+	replied := false
+	for _, p := range plugins {
+
+		// Get metadata
+		info := p.GetMetadata()
+		if PluginManager.Disabled(info.Name) || (fromBot && !info.AcceptBots) {
+			continue
+		}
+
+		// Process active triggers
+		for _, c := range info.ActiveTriggers {
+			if (mentionned && info.WhenMentioned) || !info.WhenMentioned {
+				// Look for !action or @bot action or DM with action
+				if strings.Contains(message.Text, d.Prefix+c.Name) ||
+					(strings.HasPrefix(message.Text, fmt.Sprintf("<@%v> ", bot.ID)) && checkForCommand(message.Text, c.Name)) ||
+					(strings.HasPrefix(msg.Channel, "D") && strings.HasPrefix(strings.ToLower(message.Text), c.Name)) {
+
+					// Check context authorization
+					if d.IsGranted != nil && !d.IsGranted(c.Name, identity, msg.Channel, userChansID...) {
+						// Nobody is there to be told
+						if identity == SchedulerIdentity {
+							zap.L().Warn("Scheduled command denied", zap.String("command", c.Name), zap.String("channel", msg.Channel), zap.String("identity", identity))
+							return
+						}
+						o := new(SlackResponse)
+						o.Channel = msg.Msg.Channel
+						o.Options = append(o.Options, slack.MsgOptionText(bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", userInfo.RealName), false))
+						// Only the user needs to know, unless we are in a direct message
+						if !strings.HasPrefix(msg.Channel, "D") && !fromBot {
+							o.Mode = ModeEphemeral
+							o.User = msg.User
+						}
+
+						output <- o
+						return
+					}
+
+					bot.Logger(info.Name).Debug("Dispatching to active plugin", zap.String("command", c.Name), zap.String("correlation", CorrelationID(ctx)))
+					// Replace our prefixed action with the action
+					message.Text = strings.Replace(message.Text, d.Prefix+c.Name, c.Name, 1)
+
+					req := request
+					req.Trigger = c.Name
+					req.Active = true
+					req.Args = commandArgs(message.Text, c.Name)
+					req.Message = message
+					d.call(ctx, p, &req, output)
+
+					// stop processing if active is matching
+					return
+				}
+			}
+		}
+
+		// Process one or many passive triggers
+		for _, r := range info.PassiveTriggers {
+			// Check for mention if required by plugin
+			if (mentionned && info.WhenMentioned) || !info.WhenMentioned {
+
+				reg, err := regexp.Compile(r.Name)
+				if err != nil {
+					zap.L().Error("Passive trigger is not a valid regular expression", zap.String("trigger", r.Name), zap.String("plugin", info.Name))
+				} else {
+					matches := reg.FindAllStringSubmatch(message.Text, -1)
+					if len(matches) > 0 {
+						bot.PassiveLogger(info.Name).Debug("Dispatching to passive plugin", zap.String("trigger", r.Name), zap.String("correlation", CorrelationID(ctx)))
+						for _, m := range matches {
+							req := request
+							req.Trigger = m[0]
+							req.Captures = m[1:]
+							req.Message = message
+							if d.call(ctx, p, &req, output) {
+								replied = true
+							}
+						}
+					}
+				}
+			}
+		}
+
+	}
+
+	// If I was mentioned or in dm and nothing matched send a response
+	// From our default response list. Never answer bots this way to avoid loops.
+	if (mentionned || strings.HasPrefix(msg.Channel, "D")) && !replied && !fromBot && len(d.DefaultAnswers) > 0 {
+		rand.Seed(time.Now().Unix())
+		o := new(SlackResponse)
+		o.Channel = message.Channel
+		o.Options = append(o.Options, slack.MsgOptionText(bot.T(msg.User, d.DefaultAnswers[rand.Intn(len(d.DefaultAnswers))]), false))
+		output <- o
+	}
+}
+
+// call a plugin with a request and send the responses of the result.
+// It returns if the plugin handled the request.
+func (d *Dispatcher) call(ctx context.Context, p Plugin, req *Request, output chan<- *SlackResponse) bool {
+
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	bot := d.Bot
+	info := p.GetMetadata()
+
+	var result *Result
+	err := Protect(func() (err error) {
+		result, err = AsV2(p).Handle(ctx, req)
+		return err
+	})
+
+	if perr, ok := err.(*PanicError); ok {
+		zap.L().Error("Plugin panicked while processing message",
+			zap.String("plugin", info.Name),
+			zap.String("command", req.Trigger),
+			zap.String("correlation", CorrelationID(ctx)),
+			zap.Reflect("panic", perr.Value),
+			zap.ByteString("stack", perr.Stack))
+		text := bot.T(req.User.ID, "I'm sorry, `%s` crashed while processing `%s` (reference `%s`).", info.Name, req.Trigger, CorrelationID(ctx))
+		if PluginManager.Failed(info.Name) {
+			zap.L().Warn("Plugin disabled after too many failures", zap.String("plugin", info.Name), zap.Int("failures", PluginManager.Failures(info.Name)))
+			text += " " + bot.T(req.User.ID, "It failed too many times and has been disabled until an administrator enables it again.")
+		}
+		o := new(SlackResponse)
+		o.Channel = req.Channel.ID
+		o.Options = append(o.Options, slack.MsgOptionText(text, false))
+		output <- o
+		return true
+	}
+
+	if err != nil {
+		zap.L().Error("Plugin failed to process message",
+			zap.String("plugin", info.Name),
+			zap.String("trigger", req.Trigger),
+			zap.String("correlation", CorrelationID(ctx)),
+			zap.Error(err))
+		o := new(SlackResponse)
+		o.Channel = req.Channel.ID
+		o.Options = append(o.Options, slack.MsgOptionText(bot.T(req.User.ID, "I'm sorry, something went wrong while processing `%s` (reference `%s`).", req.Trigger, CorrelationID(ctx)), false))
+		output <- o
+		return true
+	}
+
+	if result == nil {
+		return false
+	}
+
+	for _, r := range result.Responses {
+		if r.Channel == "" {
+			r.Channel = req.Channel.ID
+		}
+		r.Plugin = info.Name
+		output <- r
+	}
+
+	return result.Handled
+}
+
+// checkForCommand will try to detect a command in a message
+// It will tokenise the message (split by space) for that.
+func checkForCommand(text string, command string) bool {
+
+	for _, word := range strings.Split(text, " ") {
+		if strings.ToLower(word) == command {
+			return true
+		}
+	}
+	return false
+}
+
+// commandArgs return the words following a command
+func commandArgs(text string, command string) []string {
+	i := strings.Index(strings.ToLower(text), command)
+	if i == -1 {
+		return nil
+	}
+	return strings.Fields(text[i+len(command):])
+}
@@ -0,0 +1,622 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+)
+
+/*
+External plugins are executables found in the plugin directories.
+
+An executable is first run with --describe and must print its metadata as JSON:
+
+	{"name": "uptime", "description": "...", "version": "1.0",
+	 "active_triggers": [{"name": "uptime", "short_description": "...", "long_description": "..."}],
+	 "passive_triggers": [{"name": "(?i)is it up"}],
+	 "when_mentioned": false, "accept_bots": false}
+
+It is then started without argument and kept running, restarted if it exits.
+Each request is written as a JSON line on its stdin:
+
+	{"id": "...", "trigger": "uptime", "active": true, "args": [], "captures": [],
+	 "user": {"id": "U...", "name": "...", "real_name": "...", "email": "..."},
+	 "channel": {"id": "C...", "name": "..."}, "thread": "", "mentioned": false,
+	 "text": "!uptime", "timestamp": "..."}
+
+The executable writes JSON lines on its stdout. A response to a request carries its id,
+the fields are the ones of SlackResponse and all are optional:
+
+	{"id": "...", "channel": "C...", "text": "...", "blocks": [...], "attachments": [...],
+	 "thread": "...", "tracker_id": "...", "tracked_ttl": "1h", "mode": "post",
+	 "user": "U...", "post_at": "2006-01-02T15:04:05Z", "timestamp": "...", "reaction": "...",
+	 "snippet": {"filename": "...", "filetype": "...", "title": "..."}}
+
+The processing of a request ends with a line like:
+
+	{"id": "...", "done": true, "handled": true, "error": ""}
+
+Lines without id are sent as is, their channel must be set. Lines written on stderr are logged
+with the logger of the plugin. A process not reading its requests is killed and restarted.
+*/
+
+// describeTimeout is the time an executable has to print its metadata
+const describeTimeout = 10 * time.Second
+
+// maxRestartDelay is the maximum delay before restarting an external plugin
+const maxRestartDelay = time.Minute
+
+// externalModes are the delivery modes of external plugins responses
+var externalModes = map[string]DeliveryMode{
+	"":                 ModePost,
+	"post":             ModePost,
+	"ephemeral":        ModeEphemeral,
+	"scheduled":        ModeScheduled,
+	"cancel_scheduled": ModeCancelScheduled,
+	"delete":           ModeDelete,
+	"add_reaction":     ModeAddReaction,
+	"remove_reaction":  ModeRemoveReaction,
+}
+
+// externalCommand is a trigger of an external plugin
+type externalCommand struct {
+	Name             string `json:"name"`
+	ShortDescription string `json:"short_description"`
+	LongDescription  string `json:"long_description"`
+}
+
+// externalMetadata is the description printed by an external plugin
+type externalMetadata struct {
+	Name            string            `json:"name"`
+	Description     string            `json:"description"`
+	Version         string            `json:"version"`
+	ActiveTriggers  []externalCommand `json:"active_triggers"`
+	PassiveTriggers []externalCommand `json:"passive_triggers"`
+	WhenMentioned   bool              `json:"when_mentioned"`
+	AcceptBots      bool              `json:"accept_bots"`
+}
+
+// externalUser is the user of a request sent to an external plugin
+type externalUser struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	RealName string `json:"real_name"`
+	Email    string `json:"email"`
+}
+
+// externalChannel is the channel of a request sent to an external plugin
+type externalChannel struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// externalRequest is a request sent to an external plugin
+type externalRequest struct {
+	ID        string          `json:"id"`
+	Trigger   string          `json:"trigger"`
+	Active    bool            `json:"active"`
+	Args      []string        `json:"args"`
+	Captures  []string        `json:"captures"`
+	User      externalUser    `json:"user"`
+	Channel   externalChannel `json:"channel"`
+	Thread    string          `json:"thread"`
+	Mentioned bool            `json:"mentioned"`
+	Text      string          `json:"text"`
+	Timestamp string          `json:"timestamp"`
+}
+
+// externalMessage is a line written by an external plugin, a response or the end of a request
+type externalMessage struct {
+	ID string `json:"id"`
+	// End of a request
+	Done    bool   `json:"done"`
+	Handled bool   `json:"handled"`
+	Error   string `json:"error"`
+	// Response
+	Channel     string             `json:"channel"`
+	Text        string             `json:"text"`
+	Blocks      json.RawMessage    `json:"blocks"`
+	Attachments []slack.Attachment `json:"attachments"`
+	Thread      string             `json:"thread"`
+	TrackerID   string             `json:"tracker_id"`
+	TrackedTTL  string             `json:"tracked_ttl"`
+	Mode        string             `json:"mode"`
+	User        string             `json:"user"`
+	PostAt      string             `json:"post_at"`
+	Timestamp   string             `json:"timestamp"`
+	Reaction    string             `json:"reaction"`
+	Snippet     *Snippet           `json:"snippet"`
+}
+
+// describedMetadata is the metadata of a plugin describing itself.
+// It is replaced as a whole when the plugin is described again, so that
+// the dispatcher never reads a metadata being updated.
+type describedMetadata struct {
+	metadata *Metadata
+	lock     sync.RWMutex
+}
+
+// GetMetadata interface implementation
+func (d *describedMetadata) GetMetadata() *Metadata {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.metadata
+}
+
+// name return the name of the plugin
+func (d *describedMetadata) name() string {
+	return d.GetMetadata().Name
+}
+
+// setMetadata replace the metadata by a copy named name with the described one applied.
+// The metadata is new if the plugin had no name yet.
+func (d *describedMetadata) setMetadata(name string, described externalMetadata) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	m := NewMetadata(name)
+	if d.metadata != nil && d.metadata.Name == name {
+		m = *d.metadata
+	}
+	m.applyMetadata(described)
+	d.metadata = &m
+}
+
+// external is a plugin running as a separate process
+type external struct {
+	describedMetadata
+	path   string
+	output chan<- *SlackResponse
+	log    *zap.Logger
+	// The running process and its stdin, nil if not running
+	process *os.Process
+	stdin   io.WriteCloser
+	// Lines of the requests being processed
+	pending map[string]chan *externalMessage
+	// The process is stopped while the plugin is disabled, resumed is closed when it is enabled again
+	suspended bool
+	resumed   chan struct{}
+	lock      sync.Mutex
+	// Serialize the writes of requests
+	writeLock sync.Mutex
+}
+
+// LoadPLugins will load external plugins, the executables found in PluginDirs.
+// Executables which cannot describe themselves are skipped.
+func (m *Manager) LoadPLugins() error {
+
+	for _, dir := range m.PluginDirs {
+
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			zap.L().Debug("Plugin directory not found", zap.String("directory", dir))
+			continue
+		}
+
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() || info.Mode()&0111 == 0 {
+				return nil
+			}
+			d, err := describe(path)
+			if err != nil {
+				zap.L().Error("Cannot load external plugin", zap.String("path", path), zap.Error(err))
+				return nil
+			}
+			e := &external{path: path, pending: map[string]chan *externalMessage{}}
+			e.setMetadata(d.Name, d)
+			if _, exists := m.Plugin(e.name()); exists {
+				zap.L().Error("A plugin with the same name is already registered", zap.String("plugin", e.name()), zap.String("path", path))
+				return nil
+			}
+			zap.L().Info("Found external plugin", zap.String("plugin", e.name()), zap.String("path", path))
+			m.RegisterV2(e)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// describe run an executable with --describe and return its metadata
+func describe(path string) (d externalMetadata, err error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), describeTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "--describe").Output()
+	if err != nil {
+		return d, err
+	}
+
+	if err := json.Unmarshal(out, &d); err != nil {
+		return d, fmt.Errorf("invalid description: %v", err)
+	}
+	if d.Name == "" {
+		return d, fmt.Errorf("invalid description: no name")
+	}
+
+	return d, nil
+}
+
+// applyMetadata set the metadata described by an external or remote plugin, except its name
+func (m *Metadata) applyMetadata(d externalMetadata) {
+	if d.Description != "" {
+		m.Description = d.Description
+	}
+	if d.Version != "" {
+		m.Version = d.Version
+	}
+	active := []Command{}
+	for _, c := range d.ActiveTriggers {
+		active = append(active, Command{Name: c.Name, ShortDescription: c.ShortDescription, LongDescription: c.LongDescription})
+	}
+	passive := []Command{}
+	for _, c := range d.PassiveTriggers {
+		passive = append(passive, Command{Name: c.Name, ShortDescription: c.ShortDescription, LongDescription: c.LongDescription})
+	}
+	m.ActiveTriggers = active
+	m.PassiveTriggers = passive
+	m.WhenMentioned = d.WhenMentioned
+	m.AcceptBots = d.AcceptBots
+}
+
+// newExternalRequest return the document sent to external and remote plugins for a request
+func newExternalRequest(id string, req *Request) externalRequest {
+	return externalRequest{
+		ID:        id,
+		Trigger:   req.Trigger,
+		Active:    req.Active,
+		Args:      req.Args,
+		Captures:  req.Captures,
+		User:      externalUser{ID: req.User.ID, Name: req.User.Name, RealName: req.User.RealName, Email: req.User.Profile.Email},
+		Channel:   externalChannel{ID: req.Channel.ID, Name: req.Channel.Name},
+		Thread:    req.Thread,
+		Mentioned: req.Mentioned,
+		Text:      req.Message.Text,
+		Timestamp: req.Message.Timestamp,
+	}
+}
+
+// Init interface implementation, start the process
+func (e *external) Init(output chan<- *SlackResponse, bot *Bot) {
+	e.output = output
+	e.log = bot.Logger(e.name())
+	go e.supervise()
+}
+
+// Handle interface implementation, send the request to the process and
+// wait for the end of its processing
+func (e *external) Handle(ctx context.Context, req *Request) (*Result, error) {
+
+	id := NewCorrelationID()
+	lines := make(chan *externalMessage, 64)
+
+	e.lock.Lock()
+	e.pending[id] = lines
+	e.lock.Unlock()
+
+	defer func() {
+		e.lock.Lock()
+		delete(e.pending, id)
+		e.lock.Unlock()
+	}()
+
+	if err := e.write(ctx, id, req); err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	for {
+		select {
+		case m, ok := <-lines:
+			if !ok {
+				return result, fmt.Errorf("plugin %s exited while processing the request", e.name())
+			}
+			if m.Done {
+				result.Handled = m.Handled
+				if m.Error != "" {
+					return result, errors.New(m.Error)
+				}
+				return result, nil
+			}
+			r, err := m.response()
+			if err != nil {
+				e.log.Warn("Invalid response from external plugin", zap.Error(err))
+				continue
+			}
+			result.Responses = append(result.Responses, r)
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+	}
+}
+
+// Reload interface implementation, describe the plugin again and restart its process
+func (e *external) Reload() error {
+
+	d, err := describe(e.path)
+	if err != nil {
+		return err
+	}
+	if d.Name != e.name() {
+		return fmt.Errorf("plugin %s is now named %s, restart the bot to rename it", e.name(), d.Name)
+	}
+	e.setMetadata(d.Name, d)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.process != nil {
+		return e.process.Kill()
+	}
+	return nil
+}
+
+// Suspend interface implementation, stop the process while the plugin is disabled
+func (e *external) Suspend() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.suspended = true
+	if e.process != nil {
+		return e.process.Kill()
+	}
+	return nil
+}
+
+// Resume interface implementation, start the process again
+func (e *external) Resume() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.suspended = false
+	if e.resumed != nil {
+		close(e.resumed)
+		e.resumed = nil
+	}
+	return nil
+}
+
+// write a request on the stdin of the process.
+// A process which does not read its requests before the end of ctx is killed, and restarted.
+func (e *external) write(ctx context.Context, id string, req *Request) error {
+
+	line, err := json.Marshal(newExternalRequest(id, req))
+	if err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	stdin, process := e.stdin, e.process
+	e.lock.Unlock()
+	if stdin == nil {
+		return fmt.Errorf("plugin %s is not running", e.name())
+	}
+
+	// The write ends with an error once the process is killed
+	written := make(chan error, 1)
+	go func() {
+		e.writeLock.Lock()
+		defer e.writeLock.Unlock()
+		_, err := stdin.Write(append(line, '\n'))
+		written <- err
+	}()
+
+	select {
+	case err := <-written:
+		return err
+	case <-ctx.Done():
+		e.log.Error("External plugin does not read its requests, restarting", zap.Error(ctx.Err()))
+		e.lock.Lock()
+		if e.process == process {
+			process.Kill()
+		}
+		e.lock.Unlock()
+		return fmt.Errorf("plugin %s does not read its requests: %v", e.name(), ctx.Err())
+	}
+}
+
+// supervise run the process and restart it when it exits
+func (e *external) supervise() {
+
+	delay := time.Second
+
+	for {
+		started := time.Now()
+		err := e.run()
+
+		// Wait to be enabled again to start it
+		e.lock.Lock()
+		if e.suspended {
+			resumed := make(chan struct{})
+			e.resumed = resumed
+			e.lock.Unlock()
+			e.log.Info("External plugin stopped while disabled")
+			<-resumed
+			delay = time.Second
+			continue
+		}
+		e.lock.Unlock()
+
+		// Start again quickly if it was running fine for a while
+		if time.Since(started) > maxRestartDelay {
+			delay = time.Second
+		}
+
+		e.log.Warn("External plugin exited, restarting", zap.Duration("delay", delay), zap.Error(err))
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// run the process until it exits
+func (e *external) run() error {
+
+	cmd := exec.Command(e.path)
+	cmd.Env = append(os.Environ(), "SLACKHAL_PLUGIN="+e.name())
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	e.process = cmd.Process
+	e.stdin = stdin
+	// Disabled while starting
+	if e.suspended {
+		cmd.Process.Kill()
+	}
+	e.lock.Unlock()
+
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			e.log.Info(scanner.Text())
+		}
+	}()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		e.receive(scanner.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		e.log.Error("Error while reading external plugin output", zap.Error(err))
+		cmd.Process.Kill()
+	}
+
+	err = cmd.Wait()
+
+	// Requests being processed will not end
+	e.lock.Lock()
+	e.process = nil
+	e.stdin = nil
+	for id, lines := range e.pending {
+		close(lines)
+		delete(e.pending, id)
+	}
+	e.lock.Unlock()
+
+	return err
+}
+
+// receive a line written by the process
+func (e *external) receive(line []byte) {
+
+	m := &externalMessage{}
+	if err := json.Unmarshal(line, m); err != nil {
+		e.log.Warn("Invalid line from external plugin", zap.ByteString("line", line), zap.Error(err))
+		return
+	}
+
+	if m.ID != "" {
+		e.lock.Lock()
+		lines, ok := e.pending[m.ID]
+		if ok {
+			select {
+			case lines <- m:
+			default:
+				e.log.Warn("Too many responses from external plugin, dropping", zap.String("id", m.ID))
+			}
+		}
+		e.lock.Unlock()
+		if ok {
+			return
+		}
+	}
+
+	if m.Done {
+		return
+	}
+
+	// Responses sent on their own, or for a request which timed out
+	r, err := m.response()
+	if err != nil {
+		e.log.Warn("Invalid response from external plugin", zap.Error(err))
+		return
+	}
+	e.output <- r
+}
+
+// response return the SlackResponse of a line
+func (m *externalMessage) response() (*SlackResponse, error) {
+
+	mode, ok := externalModes[m.Mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown mode %s", m.Mode)
+	}
+
+	r := &SlackResponse{
+		Channel:   m.Channel,
+		TrackerID: m.TrackerID,
+		Mode:      mode,
+		User:      m.User,
+		Timestamp: m.Timestamp,
+		Reaction:  m.Reaction,
+		Snippet:   m.Snippet,
+	}
+
+	if m.TrackedTTL != "" {
+		ttl, err := time.ParseDuration(m.TrackedTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tracked_ttl: %v", err)
+		}
+		r.TrackedFor = ttl
+	}
+
+	if m.PostAt != "" {
+		at, err := time.Parse(time.RFC3339, m.PostAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid post_at: %v", err)
+		}
+		r.PostAt = at
+	}
+
+	if m.Text != "" {
+		r.Options = append(r.Options, slack.MsgOptionText(m.Text, false))
+	}
+	if len(m.Blocks) > 0 {
+		blocks := slack.Blocks{}
+		if err := json.Unmarshal(m.Blocks, &blocks); err != nil {
+			return nil, fmt.Errorf("invalid blocks: %v", err)
+		}
+		r.Options = append(r.Options, slack.MsgOptionBlocks(blocks.BlockSet...))
+	}
+	if len(m.Attachments) > 0 {
+		r.Options = append(r.Options, slack.MsgOptionAttachments(m.Attachments...))
+	}
+	if m.Thread != "" {
+		r.Options = append(r.Options, slack.MsgOptionTS(m.Thread))
+	}
+
+	return r, nil
+}
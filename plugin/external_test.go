@@ -0,0 +1,252 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// helperEnv is set when the test binary runs as an external plugin
+const helperEnv = "SLACKHAL_EXTERNAL_HELPER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(helperEnv) != "" {
+		externalHelper()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// externalHelper is an external plugin answering the triggers:
+// echo its arguments, fail, crash, get stuck or tick on its own
+func externalHelper() {
+
+	out := json.NewEncoder(os.Stdout)
+
+	if len(os.Args) > 1 && os.Args[1] == "--describe" {
+		out.Encode(externalMetadata{
+			Name:            "helper",
+			Version:         "1.0",
+			ActiveTriggers:  []externalCommand{{Name: "echo"}, {Name: "fail"}, {Name: "crash"}, {Name: "stuck"}, {Name: "tick"}},
+			PassiveTriggers: []externalCommand{{Name: "(?i)helper"}},
+		})
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "helper started")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		req := externalRequest{}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		done := externalMessage{ID: req.ID, Done: true, Handled: true}
+		switch req.Trigger {
+		case "echo":
+			out.Encode(externalMessage{ID: req.ID, Text: strings.Join(req.Args, " ")})
+		case "fail":
+			done.Error = "boom"
+		case "crash":
+			os.Exit(2)
+		case "stuck":
+			// Stop reading the requests
+			select {}
+		case "tick":
+			out.Encode(externalMessage{Channel: "C1", Text: "tick"})
+		}
+		out.Encode(done)
+	}
+}
+
+// eventually return if cond becomes true before timeout
+func eventually(timeout time.Duration, cond func() bool) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+// text return the text of a response
+func text(r *SlackResponse) string {
+	_, values, _ := slack.UnsafeApplyMsgOptions("", r.Channel, "", r.Options...)
+	return values.Get("text")
+}
+
+func TestExternalPlugin(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("external plugins are run through a shell script")
+	}
+
+	logs, restore := observeLogs()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "external")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]struct {
+		content string
+		mode    os.FileMode
+	}{
+		"helper":     {fmt.Sprintf("#!/bin/sh\n%s=1 exec %q \"$@\"\n", helperEnv, self), 0700},
+		"broken":     {"#!/bin/sh\necho nope\n", 0700},
+		"helper.yml": {"not: executable\n", 0600},
+	}
+	for name, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(f.content), f.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := &Manager{PluginDirs: []string{dir, filepath.Join(dir, "missing")}}
+	if err := m.LoadPLugins(); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Plugins) != 1 {
+		t.Fatalf("got plugins %v, want the helper only", m.Plugins)
+	}
+	p, ok := m.Plugin("helper")
+	if !ok {
+		t.Fatal("helper not loaded")
+	}
+	if meta := p.GetMetadata(); meta.Version != "1.0" || len(meta.ActiveTriggers) != 5 || meta.PassiveTriggers[0].Name != "(?i)helper" {
+		t.Errorf("got metadata %+v", meta)
+	}
+
+	e := implementation(p).(*external)
+	output := make(chan *SlackResponse, 10)
+	e.Init(output, &Bot{})
+
+	// handle send a request to the helper with a timeout
+	handle := func(trigger string, timeout time.Duration, args ...string) (*Result, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return e.Handle(ctx, &Request{Trigger: trigger, Active: true, Args: args, Channel: slack.Channel{GroupConversation: slack.GroupConversation{Conversation: slack.Conversation{ID: "C1"}}}})
+	}
+
+	// answering return if the helper is answering, it waits for its restart
+	answering := func() bool {
+		return eventually(10*time.Second, func() bool {
+			r, err := handle("echo", time.Second, "ping")
+			return err == nil && r.Handled
+		})
+	}
+
+	if !answering() {
+		t.Fatal("helper not started")
+	}
+
+	tests := []struct {
+		trigger string
+		args    []string
+		// Expected text of the response and error
+		want string
+		err  string
+	}{
+		{"echo", []string{"hello", "world"}, "hello world", ""},
+		{"tick", nil, "", ""},
+		{"fail", nil, "", "boom"},
+		{"crash", nil, "", "plugin helper exited while processing the request"},
+	}
+
+	for _, test := range tests {
+		r, err := handle(test.trigger, 5*time.Second, test.args...)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != test.err {
+			t.Errorf("%s: got error %q, want %q", test.trigger, got, test.err)
+		}
+		if test.want != "" && (r == nil || len(r.Responses) != 1 || text(r.Responses[0]) != test.want) {
+			t.Errorf("%s: got result %+v, want %q", test.trigger, r, test.want)
+		}
+	}
+
+	// Lines without id are sent on their own
+	select {
+	case r := <-output:
+		if r.Channel != "C1" || text(r) != "tick" {
+			t.Errorf("got response %s in %s", text(r), r.Channel)
+		}
+	case <-time.After(time.Second):
+		t.Error("no response sent on its own")
+	}
+
+	// stderr is logged by the logger of the plugin
+	if !eventually(time.Second, func() bool { return logs.FilterMessage("helper started").Len() > 0 }) {
+		t.Error("stderr not logged")
+	} else if entry := logs.FilterMessage("helper started").All()[0]; entry.LoggerName != "helper" {
+		t.Errorf("stderr logged by %q", entry.LoggerName)
+	}
+
+	// Restarted after its crash
+	if !answering() {
+		t.Fatal("helper not restarted after its crash")
+	}
+
+	// A plugin not reading its requests is killed, then restarted
+	if _, err := handle("stuck", 100*time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("stuck: got error %v", err)
+	}
+	started := time.Now()
+	if _, err := handle("echo", 200*time.Millisecond, strings.Repeat("x", 1024*1024)); err == nil || !strings.Contains(err.Error(), "does not read its requests") {
+		t.Errorf("blocked write: got error %v", err)
+	}
+	if time.Since(started) > time.Second {
+		t.Errorf("blocked write returned after %s", time.Since(started))
+	}
+	if !answering() {
+		t.Fatal("helper not restarted after being stuck")
+	}
+
+	// Stopped while suspended
+	if err := e.Suspend(); err != nil {
+		t.Fatal(err)
+	}
+	stopped := eventually(5*time.Second, func() bool {
+		_, err := handle("echo", time.Second)
+		return err != nil && strings.Contains(err.Error(), "is not running")
+	})
+	if !stopped {
+		t.Error("helper still running while suspended")
+	}
+	if err := e.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if !answering() {
+		t.Fatal("helper not resumed")
+	}
+
+	e.Suspend()
+}
+
+// observeLogs replace the global logger by one recording the entries
+func observeLogs() (*observer.ObservedLogs, func()) {
+	core, logs := observer.New(zap.InfoLevel)
+	return logs, zap.ReplaceGlobals(zap.New(core))
+}
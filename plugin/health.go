@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// HealthStatus is the status of a plugin
+type HealthStatus string
+
+// Health statuses
+const (
+	HealthOK       HealthStatus = "ok"
+	HealthDegraded HealthStatus = "degraded"
+	HealthFailing  HealthStatus = "failing"
+	HealthDisabled HealthStatus = "disabled"
+)
+
+// Health is the health of a plugin
+type Health struct {
+	Status  HealthStatus `json:"status"`
+	Details string       `json:"details,omitempty"`
+}
+
+// HealthChecker is implemented by plugins reporting their health
+type HealthChecker interface {
+	Health() Health
+}
+
+// initErrors keep why the init of plugins failed
+type initErrors struct {
+	errors map[string]error
+	lock   sync.Mutex
+}
+
+// SetInitError record why the init of a plugin failed, nil to clear it
+func (m *Manager) SetInitError(name string, err error) {
+	m.initErrors.lock.Lock()
+	defer m.initErrors.lock.Unlock()
+	if m.initErrors.errors == nil {
+		m.initErrors.errors = map[string]error{}
+	}
+	if err == nil {
+		delete(m.initErrors.errors, name)
+		return
+	}
+	m.initErrors.errors[name] = err
+}
+
+// InitError return why the init of a plugin failed if it did
+func (m *Manager) InitError(name string) error {
+	m.initErrors.lock.Lock()
+	defer m.initErrors.lock.Unlock()
+	return m.initErrors.errors[name]
+}
+
+// Health return the health of a plugin
+func (m *Manager) Health(name string) (h Health) {
+
+	p, ok := m.Plugin(name)
+	if !ok {
+		return Health{Status: HealthFailing, Details: fmt.Sprintf("no such plugin %s", name)}
+	}
+
+	if err := m.InitError(name); err != nil {
+		return Health{Status: HealthFailing, Details: err.Error()}
+	}
+
+	if m.Disabled(name) {
+		if tripped := m.Tripped(name); tripped > 0 {
+			return Health{Status: HealthFailing, Details: fmt.Sprintf("disabled after %d failures in %s", tripped, m.failureWindow())}
+		}
+		return Health{Status: HealthDisabled}
+	}
+
+	if missing := m.Missing(p); len(missing) > 0 {
+		return Health{Status: HealthFailing, Details: "missing services: " + strings.Join(missing, ", ")}
+	}
+
+	h = Health{Status: HealthOK}
+	if checker, ok := implementation(p).(HealthChecker); ok {
+		err := Protect(func() error {
+			h = checker.Health()
+			return nil
+		})
+		if err != nil {
+			return Health{Status: HealthFailing, Details: err.Error()}
+		}
+	}
+
+	// Only the recent failures degrade the health
+	if recent := m.RecentFailures(name); recent > 0 && h.Status == HealthOK {
+		h = Health{Status: HealthDegraded, Details: fmt.Sprintf("%d failures in the last %s", recent, m.failureWindow())}
+	}
+
+	return h
+}
+
+// implementation return the value implementing a plugin
+func implementation(p Plugin) interface{} {
+	if v2, ok := p.(v2Plugin); ok {
+		return v2.PluginV2
+	}
+	return p
+}
@@ -0,0 +1,227 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/asdine/storm"
+	"go.uber.org/zap"
+)
+
+/*
+Localization of the bot replies.
+
+Messages are written in english in the code and used as keys of the catalogs.
+A catalog is a JSON file mapping english messages to their translation, named
+after its language like fr.json, pt-br.json or facts.fr.json for a plugin.
+The catalogs of a plugin are only used for its messages, before the global ones.
+
+The language of a user is its preference if set, else its slack locale.
+Messages without translation are sent in english.
+*/
+
+// DefaultLanguage is the language of the messages in the code
+const DefaultLanguage = "en"
+
+// languagePreference is the language chosen by a user
+type languagePreference struct {
+	User     string `storm:"id"`
+	Language string
+}
+
+// Translator translate messages with catalogs loaded from files
+type Translator struct {
+	// Catalogs per plugin then language, the global ones have no plugin
+	catalogs    map[string]map[string]map[string]string
+	preferences *storm.DB
+	lock        sync.RWMutex
+}
+
+// Init the translator with the catalogs of a directory and the
+// database used to store the preferences of the users.
+func (t *Translator) Init(dir string, dbPath string) (err error) {
+
+	if dbPath != "" {
+		t.preferences, err = storm.Open(dbPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if dir == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		zap.L().Info("No catalogs found, replies will be in english", zap.String("directory", dir))
+		return nil
+	}
+
+	return t.Load(dir)
+}
+
+// Load the catalogs of a directory, existing translations are overridden
+func (t *Translator) Load(dir string) error {
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		messages := map[string]string{}
+		if err := json.Unmarshal(content, &messages); err != nil {
+			return fmt.Errorf("invalid catalog %s: %v", f, err)
+		}
+		// fr.json or facts.fr.json
+		parts := strings.Split(strings.TrimSuffix(filepath.Base(f), ".json"), ".")
+		t.AddFor(strings.Join(parts[:len(parts)-1], "."), parts[len(parts)-1], messages)
+		zap.L().Debug("Loaded catalog", zap.String("file", f), zap.Int("messages", len(messages)))
+	}
+
+	return nil
+}
+
+// Add translations to the global catalog of a language
+func (t *Translator) Add(language string, messages map[string]string) {
+	t.AddFor("", language, messages)
+}
+
+// AddFor add translations to the catalog of a language of a plugin
+func (t *Translator) AddFor(plugin string, language string, messages map[string]string) {
+	plugin = strings.ToLower(plugin)
+	language = normalizeLanguage(language)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.catalogs == nil {
+		t.catalogs = map[string]map[string]map[string]string{}
+	}
+	if t.catalogs[plugin] == nil {
+		t.catalogs[plugin] = map[string]map[string]string{}
+	}
+	if t.catalogs[plugin][language] == nil {
+		t.catalogs[plugin][language] = map[string]string{}
+	}
+	for k, v := range messages {
+		t.catalogs[plugin][language][k] = v
+	}
+}
+
+// Languages return the languages having a catalog
+func (t *Translator) Languages() (languages []string) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	languages = append(languages, DefaultLanguage)
+	seen := map[string]bool{DefaultLanguage: true}
+	for _, catalogs := range t.catalogs {
+		for l := range catalogs {
+			if !seen[l] {
+				seen[l] = true
+				languages = append(languages, l)
+			}
+		}
+	}
+	sort.Strings(languages[1:])
+	return
+}
+
+// Translate a message in a language with the global catalogs, the message is formatted with args if any.
+// The closest catalog is used, like fr for fr-ca, or english if none is found.
+func (t *Translator) Translate(language string, message string, args ...interface{}) string {
+	return t.TranslateFor("", language, message, args...)
+}
+
+// TranslateFor translate a message of a plugin in a language, the catalogs of the plugin are looked up before the global ones.
+func (t *Translator) TranslateFor(plugin string, language string, message string, args ...interface{}) string {
+
+	language = normalizeLanguage(language)
+	translated := message
+
+	scopes := []string{""}
+	if plugin != "" {
+		scopes = []string{strings.ToLower(plugin), ""}
+	}
+
+	t.lock.RLock()
+lookup:
+	for _, scope := range scopes {
+		for _, l := range []string{language, strings.Split(language, "-")[0]} {
+			if m, ok := t.catalogs[scope][l][message]; ok && m != "" {
+				translated = m
+				break lookup
+			}
+		}
+	}
+	t.lock.RUnlock()
+
+	if len(args) == 0 {
+		return translated
+	}
+	return fmt.Sprintf(translated, args...)
+}
+
+// Preference return the language chosen by a user if any
+func (t *Translator) Preference(user string) string {
+	if t.preferences == nil {
+		return ""
+	}
+	p := languagePreference{}
+	if err := t.preferences.One("User", user, &p); err != nil {
+		return ""
+	}
+	return p.Language
+}
+
+// SetPreference set the language chosen by a user, an empty language remove it
+func (t *Translator) SetPreference(user string, language string) error {
+	if t.preferences == nil {
+		return fmt.Errorf("no database set to store preferences")
+	}
+	if language == "" {
+		err := t.preferences.DeleteStruct(&languagePreference{User: user})
+		if err == storm.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	return t.preferences.Save(&languagePreference{User: user, Language: normalizeLanguage(language)})
+}
+
+// normalizeLanguage turn locales like fr_FR or fr-FR to fr-fr
+func normalizeLanguage(language string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(language), "_", "-", -1))
+}
+
+// Language return the language of a user, its preference or its slack locale
+func (s *Bot) Language(user string) string {
+	if user == "" || user == s.ID {
+		return DefaultLanguage
+	}
+	if l := s.Translator.Preference(user); l != "" {
+		return l
+	}
+	if u, err := s.GetCachedUserInfos(user); err == nil && u.Locale != "" {
+		return normalizeLanguage(u.Locale)
+	}
+	return DefaultLanguage
+}
+
+// T translate a message in the language of a user
+func (s *Bot) T(user string, message string, args ...interface{}) string {
+	return s.Translator.Translate(s.Language(user), message, args...)
+}
+
+// PluginT translate a message of a plugin in the language of a user
+func (s *Bot) PluginT(plugin string, user string, message string, args ...interface{}) string {
+	return s.Translator.TranslateFor(plugin, s.Language(user), message, args...)
+}
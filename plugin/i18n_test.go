@@ -0,0 +1,187 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeCatalogs write catalog files in a temporary directory
+func writeCatalogs(t *testing.T, catalogs map[string]string) string {
+	dir, err := ioutil.TempDir("", "catalogs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, content := range catalogs {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestTranslatorLoad(t *testing.T) {
+
+	dir := writeCatalogs(t, map[string]string{
+		"fr.json":       `{"Hello": "Bonjour", "I don't know %s.": "Je ne connais pas %s."}`,
+		"fr-ca.json":    `{"Hello": "Allô"}`,
+		"pt_BR.json":    `{"Hello": "Olá"}`,
+		"facts.fr.json": `{"Hello": "Salut", "Fact saved.": "Fait enregistré."}`,
+		"README.md":     `not a catalog`,
+	})
+
+	tr := &Translator{}
+	if err := tr.Init(dir, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := tr.Languages(), []string{"en", "fr", "fr-ca", "pt-br"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got languages %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name     string
+		plugin   string
+		language string
+		message  string
+		args     []interface{}
+		want     string
+	}{
+		{"global", "", "fr", "Hello", nil, "Bonjour"},
+		{"formatted", "", "fr", "I don't know %s.", []interface{}{"bob"}, "Je ne connais pas bob."},
+		{"untranslated formatted", "", "de", "I don't know %s.", []interface{}{"bob"}, "I don't know bob."},
+		{"region", "", "fr-ca", "Hello", nil, "Allô"},
+		{"region to language", "", "fr-ca", "I don't know %s.", []interface{}{"bob"}, "Je ne connais pas bob."},
+		{"unknown region to language", "", "fr_BE", "Hello", nil, "Bonjour"},
+		{"normalized locale", "", "pt_BR", "Hello", nil, "Olá"},
+		{"language to english", "", "de", "Hello", nil, "Hello"},
+		{"default language", "", "en", "Hello", nil, "Hello"},
+		{"plugin", "facts", "fr", "Hello", nil, "Salut"},
+		{"plugin name case", "Facts", "fr", "Fact saved.", nil, "Fait enregistré."},
+		{"plugin region to language", "facts", "fr-ca", "Fact saved.", nil, "Fait enregistré."},
+		{"plugin to global", "facts", "fr", "I don't know %s.", []interface{}{"bob"}, "Je ne connais pas bob."},
+		{"plugin catalog not global", "", "fr", "Fact saved.", nil, "Fact saved."},
+		{"plugin catalog of other plugin", "reminders", "fr", "Fact saved.", nil, "Fact saved."},
+		{"plugin to english", "facts", "de", "Fact saved.", nil, "Fact saved."},
+	}
+
+	for _, test := range tests {
+		if got := tr.TranslateFor(test.plugin, test.language, test.message, test.args...); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	// Loading again override the existing translations
+	if err := ioutil.WriteFile(filepath.Join(dir, "fr.json"), []byte(`{"Hello": "Coucou"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.Translate("fr", "Hello"); got != "Coucou" {
+		t.Errorf("got %q after reload, want %q", got, "Coucou")
+	}
+}
+
+func TestTranslatorInit(t *testing.T) {
+
+	tests := []struct {
+		name string
+		dir  func(t *testing.T) string
+		err  string
+	}{
+		{"no directory", func(t *testing.T) string { return "" }, ""},
+		{"missing directory", func(t *testing.T) string { return filepath.Join(os.TempDir(), "no-such-catalogs") }, ""},
+		{"invalid catalog", func(t *testing.T) string {
+			return writeCatalogs(t, map[string]string{"fr.json": `{"Hello": `})
+		}, "invalid catalog"},
+		{"not messages", func(t *testing.T) string {
+			return writeCatalogs(t, map[string]string{"fr.json": `["Hello"]`})
+		}, "invalid catalog"},
+	}
+
+	for _, test := range tests {
+		tr := &Translator{}
+		err := tr.Init(test.dir(t), "")
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+		if got := tr.Translate("fr", "Hello"); err == nil && got != "Hello" {
+			t.Errorf("%s: got %q without catalogs", test.name, got)
+		}
+	}
+}
+
+func TestTranslatorPreferences(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "preferences")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "i18n.db")
+
+	tr := &Translator{}
+	if err := tr.SetPreference("U1", "fr"); err == nil {
+		t.Error("expected an error without database")
+	}
+	if got := tr.Preference("U1"); got != "" {
+		t.Errorf("got preference %q without database", got)
+	}
+
+	if err := tr.Init("", db); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.Preference("U1"); got != "" {
+		t.Errorf("got preference %q before it is set", got)
+	}
+	if err := tr.SetPreference("U1", "fr_CA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.SetPreference("U2", "de"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.SetPreference("U2", "pt"); err != nil {
+		t.Fatal(err)
+	}
+	// Removing a missing preference is not an error
+	if err := tr.SetPreference("U3", ""); err != nil {
+		t.Fatal(err)
+	}
+	tr.preferences.Close()
+
+	// Preferences are kept across restarts
+	tr = &Translator{}
+	if err := tr.Init("", db); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.preferences.Close()
+
+	tests := []struct {
+		user string
+		want string
+	}{
+		{"U1", "fr-ca"},
+		{"U2", "pt"},
+		{"U3", ""},
+	}
+	for _, test := range tests {
+		if got := tr.Preference(test.user); got != test.want {
+			t.Errorf("got preference %q for %s, want %q", got, test.user, test.want)
+		}
+	}
+
+	if err := tr.SetPreference("U1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.Preference("U1"); got != "" {
+		t.Errorf("got preference %q after removal", got)
+	}
+}
@@ -0,0 +1,181 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+/*
+Loggers of the plugins.
+
+Each plugin logs through a logger named after it, with the plugin and version
+fields. Its passive triggers log through the <name>.passive logger so their
+noise can be filtered on its own. The level of a logger falls back to the one
+of its parent, then to the level of the bot.
+*/
+
+// PassiveSuffix is appended to the name of a plugin for the logger of its passive triggers
+const PassiveSuffix = ".passive"
+
+// Loggers build the loggers of the plugins and keep their levels
+type Loggers struct {
+	// Logger writing every level, filtered by the plugin loggers
+	base   *zap.Logger
+	global zap.AtomicLevel
+	levels map[string]zapcore.Level
+	cache  map[string]*zap.Logger
+	lock   sync.RWMutex
+}
+
+// levelCore filter the entries of a logger with its level
+type levelCore struct {
+	zapcore.Core
+	name    string
+	loggers *Loggers
+}
+
+// Enabled implementation of zapcore.Core
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	return c.loggers.Level(c.name).Enabled(l)
+}
+
+// With implementation of zapcore.Core
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), name: c.name, loggers: c.loggers}
+}
+
+// Check implementation of zapcore.Core
+func (c *levelCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+// Init the loggers from the configuration of the bot logger with the levels of the plugin loggers
+func (l *Loggers) Init(config zap.Config, levels map[string]string) error {
+
+	l.global = config.Level
+	config.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+	base, err := config.Build()
+	if err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	l.base = base
+	l.levels = map[string]zapcore.Level{}
+	l.cache = map[string]*zap.Logger{}
+	l.lock.Unlock()
+
+	for name, level := range levels {
+		if err := l.SetLevel(name, level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetLevel set the level of a logger, default to fall back to the level of its parent
+func (l *Loggers) SetLevel(name string, level string) error {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.levels == nil {
+		l.levels = map[string]zapcore.Level{}
+	}
+
+	if level == "" || level == "default" {
+		delete(l.levels, name)
+		return nil
+	}
+
+	var lvl zapcore.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid level %s for logger %s", level, name)
+	}
+	l.levels[name] = lvl
+	return nil
+}
+
+// Level return the level of a logger
+func (l *Loggers) Level(name string) zapcore.Level {
+
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	for {
+		if lvl, ok := l.levels[name]; ok {
+			return lvl
+		}
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+
+	if l.base == nil {
+		return zap.InfoLevel
+	}
+	return l.global.Level()
+}
+
+// Levels return the levels set for the loggers
+func (l *Loggers) Levels() map[string]zapcore.Level {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	levels := map[string]zapcore.Level{}
+	for name, lvl := range l.levels {
+		levels[name] = lvl
+	}
+	return levels
+}
+
+// logger return the logger of a plugin, named name
+func (l *Loggers) logger(plugin string, name string) *zap.Logger {
+
+	l.lock.RLock()
+	base := l.base
+	logger, ok := l.cache[name]
+	l.lock.RUnlock()
+
+	if ok {
+		return logger
+	}
+
+	fields := []zap.Field{zap.String("plugin", plugin)}
+	if p, ok := PluginManager.Plugin(plugin); ok {
+		fields = append(fields, zap.String("version", p.GetMetadata().Version))
+	}
+
+	// Not configured yet, use the logger of the bot
+	if base == nil {
+		return zap.L().Named(name).With(fields...)
+	}
+
+	logger = base.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return &levelCore{Core: c, name: name, loggers: l}
+	})).Named(name).With(fields...)
+
+	l.lock.Lock()
+	l.cache[name] = logger
+	l.lock.Unlock()
+
+	return logger
+}
+
+// Logger return the logger of a plugin
+func (s *Bot) Logger(name string) *zap.Logger {
+	return s.Loggers.logger(name, name)
+}
+
+// PassiveLogger return the logger of the passive triggers of a plugin
+func (s *Bot) PassiveLogger(name string) *zap.Logger {
+	return s.Loggers.logger(name, name+PassiveSuffix)
+}
@@ -3,6 +3,7 @@ package plugin
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/slack-go/slack"
 )
@@ -18,9 +19,17 @@ type Metadata struct {
 	PassiveTriggers []Command
 	// Webhook handler
 	HTTPHandler map[Command]http.Handler
+	// Periodic jobs registered to the scheduler
+	Jobs []Job
+	// Services published by the plugin with PluginManager.Provide
+	Provides []string
+	// Services needed by the plugin, it is initialized after the plugins providing them
+	Requires []string
 	// Only trigger this plugin if the bot is mentionned
 	WhenMentioned bool
-	// Disabled state
+	// Also trigger this plugin for messages sent by allowed bots
+	AcceptBots bool
+	// Set by the plugin to disable itself during its init, the state of a loaded plugin is PluginManager.Disabled
 	Disabled bool
 }
 
@@ -42,12 +51,72 @@ func NewMetadata(name string) (m Metadata) {
 	return
 }
 
+// DeliveryMode define how a response is delivered
+type DeliveryMode int
+
+// Delivery modes
+const (
+	// ModePost post the response or update the tracked message
+	ModePost DeliveryMode = iota
+	// ModeEphemeral post the response only visible by User
+	ModeEphemeral
+	// ModeScheduled schedule the response to be posted at PostAt,
+	// a message scheduled earlier with the same TrackerID is cancelled
+	ModeScheduled
+	// ModeCancelScheduled cancel the message scheduled with the same TrackerID
+	ModeCancelScheduled
+	// ModeDelete delete the tracked message or the message at Timestamp
+	ModeDelete
+	// ModeAddReaction add Reaction to the message at Timestamp or to the tracked message
+	ModeAddReaction
+	// ModeRemoveReaction remove Reaction from the message at Timestamp or from the tracked message
+	ModeRemoveReaction
+)
+
 // SlackResponse struct
 type SlackResponse struct {
-	Channel    string
-	TrackerID  int
-	TrackedTTL int
+	Channel string
+	// The key of the tracked message, unique for the plugin and the channel
+	TrackerID string
+	// How long the message is tracked, DefaultTrackedTTL if not set
+	TrackedFor time.Duration
 	Options    []slack.MsgOption
+	// The plugin sending the response, set by the bot
+	Plugin string
+	// How the response is delivered, posted by default
+	Mode DeliveryMode
+	// The user who will see an ephemeral response
+	User string
+	// When a scheduled response is posted
+	PostAt time.Time
+	// The timestamp of the message to delete or to react to
+	Timestamp string
+	// The name of the reaction without colons
+	Reaction string
+	// Upload the text of the response as a snippet if set
+	Snippet *Snippet
+	// Done is called with the result of the delivery if set.
+	// It is called for every message if the response is split or sent to many channels.
+	Done func(DeliveryResult)
+}
+
+// DeliveryResult is the result of the delivery of a response
+type DeliveryResult struct {
+	// The channel and the timestamp of the message
+	Channel   string
+	Timestamp string
+	// The permalink of the posted message or of the uploaded snippet
+	Permalink string
+	// Err is set if the response cannot be delivered
+	Err error
+}
+
+// Snippet define how the text of a response is uploaded as a file
+type Snippet struct {
+	Filename string
+	// The type of the file like text, json or go
+	Filetype string
+	Title    string
 }
 
 // Plugin Interface
@@ -55,5 +124,6 @@ type Plugin interface {
 	Init(output chan<- *SlackResponse, bot *Bot)
 	GetMetadata() *Metadata
 	ProcessMessage(command string, message slack.Msg) bool
+	// Deprecated: publish a service with PluginManager.Provide instead
 	Self() interface{}
 }
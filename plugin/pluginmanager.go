@@ -1,27 +1,85 @@
 package plugin
 
+import (
+	"sync"
+	"time"
+)
+
 // PluginManager instance
 var PluginManager Manager
 
 // Manager contains the loaded plugins
 type Manager struct {
+	// Directories of the external plugins
 	PluginDirs []string
-	Plugins    map[string]Plugin
-}
-
-// LoadPLugins will load external plugins
-func (m *Manager) LoadPLugins() error {
-	var error error
-	// Recurse Parse folder for executable files
-	// launch it with --help
-	// Parse the result and create a new entry for plugins to call
-	return error
+	// The registered plugins, they are registered before the bot is started.
+	// Use All or Plugin to read them once the bot is started.
+	Plugins map[string]Plugin
+	// Number of panics in the failure window after which a plugin is disabled, 0 to never disable
+	MaxFailures int
+	// Time failures degrade the health of a plugin and count toward MaxFailures, DefaultFailureWindow if not set
+	FailureWindow time.Duration
+	failures      failures
+	// State of the plugins changed at runtime
+	states states
+	// Services published by the plugins
+	services services
+	// Why the init of plugins failed
+	initErrors initErrors
+	// Closed when the manager is stopped
+	stop     chan struct{}
+	stopOnce sync.Once
+	lock     sync.RWMutex
 }
 
 // Register a new plugin
 func (m *Manager) Register(plugin Plugin) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	if m.Plugins == nil {
 		m.Plugins = make(map[string]Plugin)
 	}
 	m.Plugins[plugin.GetMetadata().Name] = plugin
 }
+
+// RegisterV2 register a new PluginV2
+func (m *Manager) RegisterV2(plugin PluginV2) {
+	m.Register(v2Plugin{plugin})
+}
+
+// Stop the background work of the manager, like the refresh of remote plugins
+func (m *Manager) Stop() {
+	m.stopped()
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+// stopped return a channel closed when the manager is stopped
+func (m *Manager) stopped() <-chan struct{} {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stop == nil {
+		m.stop = make(chan struct{})
+	}
+	return m.stop
+}
+
+// All return a snapshot of the registered plugins by name
+func (m *Manager) All() map[string]Plugin {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	plugins := make(map[string]Plugin, len(m.Plugins))
+	for name, p := range m.Plugins {
+		plugins[name] = p
+	}
+	return plugins
+}
+
+// Plugin return a registered plugin
+func (m *Manager) Plugin(name string) (Plugin, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	p, ok := m.Plugins[name]
+	return p, ok
+}
@@ -0,0 +1,216 @@
+package plugintest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/slack-go/slack"
+)
+
+// greeter greets the users, on demand or when they say hi
+type greeter struct {
+	plugin.Metadata
+	bot  *plugin.Bot
+	sink chan<- *plugin.SlackResponse
+	// Disable the plugin during its init
+	broken bool
+}
+
+func newGreeter() *greeter {
+	g := &greeter{Metadata: plugin.NewMetadata("greeter")}
+	g.ActiveTriggers = []plugin.Command{{Name: "hello"}, {Name: "later"}}
+	g.PassiveTriggers = []plugin.Command{{Name: `(?i)\bhi (\w+)`}}
+	return g
+}
+
+func (g *greeter) Init(output chan<- *plugin.SlackResponse, bot *plugin.Bot) {
+	g.bot = bot
+	g.sink = output
+	g.Disabled = g.broken
+}
+
+func (g *greeter) GetMetadata() *plugin.Metadata {
+	return &g.Metadata
+}
+
+func (g *greeter) Handle(ctx context.Context, req *plugin.Request) (*plugin.Result, error) {
+	text := ""
+	switch {
+	case req.Trigger == "hello":
+		text = fmt.Sprintf("Hello %s %s", req.User.RealName, strings.Join(req.Args, " "))
+	case req.Trigger == "later":
+		// Sent asynchronously like by a job
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			g.sink <- &plugin.SlackResponse{Channel: req.Channel.ID, Options: []slack.MsgOption{slack.MsgOptionText("Later "+req.User.Name, false)}}
+		}()
+		return &plugin.Result{Handled: true}, nil
+	case !req.Active:
+		text = "Hi " + req.Captures[0] + " from " + req.Channel.Name
+	}
+	return &plugin.Result{Handled: true, Responses: []*plugin.SlackResponse{{Options: []slack.MsgOption{slack.MsgOptionText(strings.TrimSpace(text), false)}}}}, nil
+}
+
+// newTestBot return a bot with a user, a channel, a user group and another bot
+func newTestBot(t *testing.T) *Bot {
+	b := NewBot(t)
+	b.AddUser(slack.User{ID: "U1", Name: "dave", RealName: "Dave Bowman"})
+	b.AddUser(slack.User{ID: "U2", Name: "frank"})
+	b.AddChannel(slack.Channel{GroupConversation: slack.GroupConversation{Name: "discovery", Conversation: slack.Conversation{ID: "C1"}}}, "U1", "U2")
+	b.AddUserGroup(slack.UserGroup{ID: "S1", Handle: "crew", Users: []string{"U1", "U2"}})
+	b.AddBot(slack.Bot{ID: "B1", Name: "mother"})
+	b.LoadV2(newGreeter())
+	return b
+}
+
+func TestDispatch(t *testing.T) {
+
+	b := newTestBot(t)
+
+	tests := []struct {
+		name    string
+		message *slack.MessageEvent
+		// Expected text and channel of the response, no response if text is empty
+		text    string
+		channel string
+	}{
+		{"channel", ChannelMessage("C1", "U1", "!hello world"), "Hello Dave Bowman world", "C1"},
+		{"no prefix", ChannelMessage("C1", "U1", "hello world"), "", ""},
+		{"direct", DirectMessage("U2", "hello"), "Hello frank", "DU2"},
+		{"mention", Mention("C1", "U2", "hello there"), "Hello frank there", "C1"},
+		{"passive", ChannelMessage("C1", "U2", "well hi dave"), "Hi dave from discovery", "C1"},
+		{"thread", ThreadReply(ChannelMessage("C1", "U1", "topic"), "U2", "!hello"), "Hello frank", "C1"},
+		{"edit", Edit(ChannelMessage("C1", "U1", "!hullo"), "!hello again"), "Hello Dave Bowman again", "C1"},
+		{"bot", BotMessage("C1", "B1", "!hello"), "", ""},
+	}
+
+	for _, test := range tests {
+		responses := b.Dispatch(test.message)
+		if test.text == "" {
+			responses.AssertEmpty(t)
+			continue
+		}
+		responses.AssertCount(t, 1)
+		responses.AssertText(t, test.text)
+		responses.AssertChannel(t, test.channel)
+	}
+}
+
+func TestWait(t *testing.T) {
+
+	b := newTestBot(t)
+
+	b.Dispatch(ChannelMessage("C1", "U1", "!later")).AssertEmpty(t)
+	responses, err := b.Sink.Wait(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses.AssertText(t, "Later dave")
+
+	b.Sink.Reset()
+	if responses, err := b.Sink.Wait(1, 20*time.Millisecond); err == nil {
+		t.Errorf("got responses after a reset: %q", responses.Texts())
+	}
+}
+
+func TestFakeSlack(t *testing.T) {
+
+	b := newTestBot(t)
+
+	tests := []struct {
+		destination string
+		want        []string
+	}{
+		// Identifiers are used as is
+		{"C0123456", []string{"C0123456"}},
+		{"U0123456", []string{"DU0123456"}},
+		{"#discovery", []string{"C1"}},
+		{"<@U1>", []string{"DU1"}},
+		{"@frank", []string{"DU2"}},
+		{"@crew", []string{"DU1", "DU2"}},
+		{"<!subteam^S1|crew>", []string{"DU1", "DU2"}},
+	}
+
+	for _, test := range tests {
+		got, err := b.ResolveChannel(test.destination)
+		if err != nil || strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: got %v, %v, want %v", test.destination, got, err, test.want)
+		}
+	}
+
+	if _, err := b.ResolveChannel("#nowhere"); err == nil {
+		t.Error("unknown channel resolved")
+	}
+	if user, err := b.GetCachedUserInfos("U1"); err != nil || user.RealName != "Dave Bowman" {
+		t.Errorf("got user %+v, %v", user, err)
+	}
+	if channels, err := b.GetCachedUserChans("U2"); err != nil || len(channels) != 1 || channels[0].ID != "C1" {
+		t.Errorf("got channels %+v, %v", channels, err)
+	}
+	if bot, err := b.GetCachedBotInfos("B1"); err != nil || bot.Name != "mother" {
+		t.Errorf("got bot %+v, %v", bot, err)
+	}
+
+	// Calls with side effects are recorded
+	if _, _, err := b.API.PostMessage("C1", slack.MsgOptionText("recorded", false)); err != nil {
+		t.Fatal(err)
+	}
+	calls := b.Calls("chat.postMessage")
+	if len(calls) != 1 || calls[0].Values.Get("text") != "recorded" {
+		t.Errorf("got calls %+v", calls)
+	}
+}
+
+func TestDataDir(t *testing.T) {
+
+	b := NewBot(t)
+	b.WriteConfig("greeter", "greeting: bonjour\n")
+
+	if got := b.Config("greeter").GetString("greeting"); got != "bonjour" {
+		t.Errorf("got greeting %q", got)
+	}
+
+	store := b.Store("greeter")
+	if err := store.Put("greeted", "dave"); err != nil {
+		t.Fatal(err)
+	}
+	greeted := ""
+	if err := store.Get("greeted", &greeted); err != nil || greeted != "dave" {
+		t.Errorf("got %q, %v", greeted, err)
+	}
+
+	if err := b.Translator.SetPreference("U1", "fr"); err != nil || b.Language("U1") != "fr" {
+		t.Errorf("got language %s, %v", b.Language("U1"), err)
+	}
+}
+
+// fatalRecorder record the fatal errors of a test instead of stopping it
+type fatalRecorder struct {
+	testing.TB
+	fatal string
+}
+
+func (r *fatalRecorder) Helper() {}
+
+func (r *fatalRecorder) Fatalf(format string, args ...interface{}) {
+	r.fatal = fmt.Sprintf(format, args...)
+}
+
+func TestLoadDisabledPlugin(t *testing.T) {
+
+	b := NewBot(t)
+	recorder := &fatalRecorder{TB: t}
+	b.t = recorder
+
+	broken := newGreeter()
+	broken.broken = true
+	b.LoadV2(broken)
+
+	if !strings.Contains(recorder.fatal, "greeter disabled itself") {
+		t.Errorf("got %q", recorder.fatal)
+	}
+}
@@ -0,0 +1,97 @@
+// Package plugintest helps testing plugins without slack.
+//
+// It builds the messages slack would send, a Bot talking to a fake slack
+// seeded with users and channels, and a sink collecting the responses of the
+// plugins. Messages are run through the same dispatcher as the bot:
+//
+//	b := plugintest.NewBot(t)
+//	b.AddUser(slack.User{ID: "U1", Name: "dave"})
+//	b.AddChannel(slack.Channel{GroupConversation: slack.GroupConversation{Name: "team", Conversation: slack.Conversation{ID: "C1"}}}, "U1")
+//	b.Load(myPlugin)
+//	b.Dispatch(plugintest.ChannelMessage("C1", "U1", "!hello")).AssertText(t, "Hello dave")
+package plugintest
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/slack-go/slack"
+)
+
+// Identity of the fake bot
+const (
+	BotID   = "UHAL9000"
+	BotName = "hal"
+	// Prefix of the active triggers
+	Prefix = "!"
+)
+
+// sequence of the message timestamps
+var sequence int64
+
+// timestamp return a new unique message timestamp
+func timestamp() string {
+	return fmt.Sprintf("1600000000.%06d", atomic.AddInt64(&sequence, 1))
+}
+
+// DirectChannel return the id of the direct message channel between the bot and a user
+func DirectChannel(user string) string {
+	return "D" + user
+}
+
+// ChannelMessage return a message sent by a user in a channel
+func ChannelMessage(channel, user, text string) *slack.MessageEvent {
+	return &slack.MessageEvent{Msg: slack.Msg{
+		Type:      "message",
+		Channel:   channel,
+		User:      user,
+		Text:      text,
+		Timestamp: timestamp(),
+	}}
+}
+
+// DirectMessage return a message sent by a user to the bot in a direct message
+func DirectMessage(user, text string) *slack.MessageEvent {
+	return ChannelMessage(DirectChannel(user), user, text)
+}
+
+// Mention return a message sent by a user in a channel and starting with a mention of the bot
+func Mention(channel, user, text string) *slack.MessageEvent {
+	return ChannelMessage(channel, user, fmt.Sprintf("<@%s> %s", BotID, text))
+}
+
+// ThreadReply return a message sent by a user in the thread of a message
+func ThreadReply(parent *slack.MessageEvent, user, text string) *slack.MessageEvent {
+	msg := ChannelMessage(parent.Channel, user, text)
+	msg.ThreadTimestamp = parent.ThreadTimestamp
+	if msg.ThreadTimestamp == "" {
+		msg.ThreadTimestamp = parent.Timestamp
+	}
+	return msg
+}
+
+// Edit return the event sent when a message is edited
+func Edit(original *slack.MessageEvent, text string) *slack.MessageEvent {
+	edited := original.Msg
+	edited.Text = text
+	edited.Edited = &slack.Edited{User: original.User, Timestamp: timestamp()}
+	return &slack.MessageEvent{
+		Msg: slack.Msg{
+			Type:      "message",
+			SubType:   "message_changed",
+			Channel:   original.Channel,
+			Timestamp: timestamp(),
+			Hidden:    true,
+		},
+		SubMessage:      &edited,
+		PreviousMessage: &original.Msg,
+	}
+}
+
+// BotMessage return a message sent by another bot in a channel
+func BotMessage(channel, botID, text string) *slack.MessageEvent {
+	msg := ChannelMessage(channel, "", text)
+	msg.SubType = "bot_message"
+	msg.BotID = botID
+	return msg
+}
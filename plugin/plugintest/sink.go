@@ -0,0 +1,197 @@
+package plugintest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/slack-go/slack"
+)
+
+// Response is a response sent by a plugin with its decoded content
+type Response struct {
+	*plugin.SlackResponse
+	// The text of the message, the notification fallback of messages with blocks
+	Text   string
+	Blocks []slack.Block
+	// The values of the message as they would be posted to slack
+	Values url.Values
+}
+
+// Responses are the responses sent by plugins
+type Responses []Response
+
+// Sink collect the responses sent by plugins
+type Sink struct {
+	// C is the output channel given to the plugins
+	C         chan *plugin.SlackResponse
+	responses Responses
+	flush     chan chan struct{}
+	lock      sync.Mutex
+}
+
+// NewSink return a sink collecting the responses sent to its channel
+func NewSink() *Sink {
+	s := &Sink{C: make(chan *plugin.SlackResponse), flush: make(chan chan struct{})}
+	go s.collect()
+	return s
+}
+
+// collect the responses until the channel is closed
+func (s *Sink) collect() {
+	for {
+		select {
+		case r, ok := <-s.C:
+			if !ok {
+				return
+			}
+			s.lock.Lock()
+			s.responses = append(s.responses, newResponse(r))
+			s.lock.Unlock()
+		case done := <-s.flush:
+			close(done)
+		}
+	}
+}
+
+// Flush wait for the responses already sent to be collected
+func (s *Sink) Flush() {
+	done := make(chan struct{})
+	s.flush <- done
+	<-done
+}
+
+// Responses return the responses collected so far
+func (s *Sink) Responses() Responses {
+	s.Flush()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append(Responses{}, s.responses...)
+}
+
+// Reset forget the responses collected so far
+func (s *Sink) Reset() {
+	s.Flush()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.responses = nil
+}
+
+// Wait for at least n responses, the ones sent asynchronously like by jobs or callbacks
+func (s *Sink) Wait(n int, timeout time.Duration) (Responses, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		r := s.Responses()
+		if len(r) >= n {
+			return r, nil
+		}
+		if time.Now().After(deadline) {
+			return r, fmt.Errorf("got %d responses after %s, want %d", len(r), timeout, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newResponse decode a response
+func newResponse(r *plugin.SlackResponse) Response {
+	res := Response{SlackResponse: r, Values: url.Values{}}
+	if r == nil {
+		return res
+	}
+	if _, values, err := slack.UnsafeApplyMsgOptions("", r.Channel, "", r.Options...); err == nil {
+		res.Values = values
+	}
+	res.Text = res.Values.Get("text")
+	if raw := res.Values.Get("blocks"); raw != "" {
+		blocks := slack.Blocks{}
+		if err := json.Unmarshal([]byte(raw), &blocks); err == nil {
+			res.Blocks = blocks.BlockSet
+		}
+	}
+	return res
+}
+
+// BlockTexts return the texts of the section and context blocks of the response
+func (r Response) BlockTexts() (texts []string) {
+	for _, b := range r.Blocks {
+		switch b := b.(type) {
+		case *slack.SectionBlock:
+			if b.Text != nil {
+				texts = append(texts, b.Text.Text)
+			}
+			for _, f := range b.Fields {
+				texts = append(texts, f.Text)
+			}
+		case *slack.ContextBlock:
+			for _, e := range b.ContextElements.Elements {
+				if t, ok := e.(*slack.TextBlockObject); ok {
+					texts = append(texts, t.Text)
+				}
+			}
+		}
+	}
+	return texts
+}
+
+// Texts return the texts of the responses
+func (rs Responses) Texts() (texts []string) {
+	for _, r := range rs {
+		texts = append(texts, r.Text)
+	}
+	return texts
+}
+
+// AssertCount fail the test if there is not n responses
+func (rs Responses) AssertCount(t testing.TB, n int) {
+	t.Helper()
+	if len(rs) != n {
+		t.Errorf("got %d responses, want %d: %q", len(rs), n, rs.Texts())
+	}
+}
+
+// AssertEmpty fail the test if there is any response
+func (rs Responses) AssertEmpty(t testing.TB) {
+	t.Helper()
+	rs.AssertCount(t, 0)
+}
+
+// AssertText fail the test if no response text contains want
+func (rs Responses) AssertText(t testing.TB, want string) {
+	t.Helper()
+	for _, r := range rs {
+		if strings.Contains(r.Text, want) {
+			return
+		}
+	}
+	t.Errorf("no response contains %q: %q", want, rs.Texts())
+}
+
+// AssertBlockText fail the test if no section or context block of the responses contains want
+func (rs Responses) AssertBlockText(t testing.TB, want string) {
+	t.Helper()
+	texts := []string{}
+	for _, r := range rs {
+		for _, text := range r.BlockTexts() {
+			if strings.Contains(text, want) {
+				return
+			}
+			texts = append(texts, text)
+		}
+	}
+	t.Errorf("no block contains %q: %q", want, texts)
+}
+
+// AssertChannel fail the test if a response is not sent to channel
+func (rs Responses) AssertChannel(t testing.TB, channel string) {
+	t.Helper()
+	for _, r := range rs {
+		if r.Channel != channel {
+			t.Errorf("response %q is sent to %s, want %s", r.Text, r.Channel, channel)
+		}
+	}
+}
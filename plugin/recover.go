@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// DefaultFailureWindow is the time failures degrade the health of a plugin if not set
+const DefaultFailureWindow = time.Hour
+
+// PanicError is returned when a panic has been recovered
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error interface implementation
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Protect call f and turn a panic into a PanicError
+func Protect(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return f()
+}
+
+// failures count the panics of plugins
+type failures struct {
+	counts map[string]int
+	// Time of the failures in the failure window
+	recent map[string][]time.Time
+	// Failures in the window of the plugins disabled by them
+	tripped map[string]int
+	lock    sync.Mutex
+}
+
+// Failed record a failure of a plugin.
+// The plugin is disabled once MaxFailures is reached in the failure window, it returns true if so.
+func (m *Manager) Failed(name string) bool {
+	m.failures.lock.Lock()
+	defer m.failures.lock.Unlock()
+
+	if m.failures.counts == nil {
+		m.failures.counts = map[string]int{}
+		m.failures.recent = map[string][]time.Time{}
+		m.failures.tripped = map[string]int{}
+	}
+	m.failures.counts[name]++
+	recent := append(m.pruneFailures(name), time.Now())
+	m.failures.recent[name] = recent
+
+	if _, ok := m.Plugin(name); !ok || m.MaxFailures <= 0 || len(recent) < m.MaxFailures {
+		return false
+	}
+
+	m.failures.tripped[name] = len(recent)
+	m.MarkDisabled(name, true)
+	return true
+}
+
+// Tripped return the failures in the failure window which disabled a plugin, 0 if it was not disabled by failures
+func (m *Manager) Tripped(name string) int {
+	m.failures.lock.Lock()
+	defer m.failures.lock.Unlock()
+	return m.failures.tripped[name]
+}
+
+// Failures return the number of failures recorded for a plugin
+func (m *Manager) Failures(name string) int {
+	m.failures.lock.Lock()
+	defer m.failures.lock.Unlock()
+	return m.failures.counts[name]
+}
+
+// RecentFailures return the number of failures of a plugin in the failure window
+func (m *Manager) RecentFailures(name string) int {
+	m.failures.lock.Lock()
+	defer m.failures.lock.Unlock()
+	recent := m.pruneFailures(name)
+	if len(recent) == 0 {
+		delete(m.failures.recent, name)
+	} else {
+		m.failures.recent[name] = recent
+	}
+	return len(recent)
+}
+
+// failureWindow return the time failures degrade the health of a plugin
+func (m *Manager) failureWindow() time.Duration {
+	if m.FailureWindow <= 0 {
+		return DefaultFailureWindow
+	}
+	return m.FailureWindow
+}
+
+// pruneFailures return the failures of a plugin in the failure window, the lock must be held
+func (m *Manager) pruneFailures(name string) []time.Time {
+	since := time.Now().Add(-m.failureWindow())
+	recent := m.failures.recent[name]
+	for len(recent) > 0 && recent[0].Before(since) {
+		recent = recent[1:]
+	}
+	return recent
+}
@@ -0,0 +1,228 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+/*
+Remote plugins are HTTP services declared in the configuration.
+
+Their metadata is fetched with a GET on <url>/metadata at startup and on every
+refresh, the document is the same as the one of external plugins.
+
+Requests are POSTed as JSON on <url>/handle, with the same document as the one
+written to external plugins, and the service answers with:
+
+	{"handled": true, "error": "", "responses": [{"text": "...", ...}]}
+
+where responses have the fields of the responses of external plugins.
+
+Every call is signed with the secret of the plugin: the X-Slackhal-Timestamp header
+is the unix time of the call and X-Slackhal-Signature is v1= followed by the hex
+encoded HMAC-SHA256 of v1:<timestamp>:<body>.
+*/
+
+// DefaultRemoteTimeout is the timeout of the calls to a remote plugin if not set
+const DefaultRemoteTimeout = 10 * time.Second
+
+// Remote is the configuration of a remote plugin
+type Remote struct {
+	// The name of the plugin until its metadata is fetched
+	Name string
+	// The base URL of the plugin
+	URL string
+	// The secret used to sign the calls
+	Secret string
+	// Timeout of the calls, DefaultRemoteTimeout if not set
+	Timeout time.Duration
+}
+
+// remoteResult is the answer of a remote plugin to a request
+type remoteResult struct {
+	Handled   bool               `json:"handled"`
+	Error     string             `json:"error"`
+	Responses []*externalMessage `json:"responses"`
+}
+
+// remote is a plugin served over HTTP
+type remote struct {
+	describedMetadata
+	config Remote
+	client *http.Client
+}
+
+// LoadRemotePlugins register the remote plugins, their metadata is fetched
+// now and every refresh if set until the manager is stopped. Plugins which
+// cannot be reached are registered without trigger until they answer, the
+// ones without secret are not registered.
+func (m *Manager) LoadRemotePlugins(remotes []Remote, refresh time.Duration) {
+
+	for _, config := range remotes {
+
+		if config.Secret == "" {
+			zap.L().Error("Remote plugin has no secret, skipping", zap.String("plugin", config.Name), zap.String("url", config.URL))
+			continue
+		}
+
+		if config.Timeout <= 0 {
+			config.Timeout = DefaultRemoteTimeout
+		}
+		config.URL = strings.TrimSuffix(config.URL, "/")
+
+		r := &remote{config: config, client: &http.Client{Timeout: config.Timeout}}
+		meta := NewMetadata(config.Name)
+		r.metadata = &meta
+
+		if err := r.refresh(); err != nil {
+			zap.L().Error("Cannot fetch remote plugin metadata", zap.String("url", config.URL), zap.Error(err))
+		}
+
+		if r.name() == "" {
+			zap.L().Error("Remote plugin has no name, skipping", zap.String("url", config.URL))
+			continue
+		}
+		if _, exists := m.Plugin(r.name()); exists {
+			zap.L().Error("A plugin with the same name is already registered", zap.String("plugin", r.name()), zap.String("url", config.URL))
+			continue
+		}
+
+		zap.L().Info("Found remote plugin", zap.String("plugin", r.name()), zap.String("url", config.URL))
+		m.RegisterV2(r)
+
+		if refresh > 0 {
+			go r.refreshEvery(refresh, m.stopped())
+		}
+	}
+}
+
+// refreshEvery refresh the metadata of the plugin periodically until stop is closed
+func (r *remote) refreshEvery(every time.Duration, stop <-chan struct{}) {
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := r.refresh(); err != nil {
+				zap.L().Warn("Cannot refresh remote plugin metadata", zap.String("plugin", r.name()), zap.Error(err))
+			}
+		}
+	}
+}
+
+// Init interface implementation
+func (r *remote) Init(output chan<- *SlackResponse, bot *Bot) {
+}
+
+// Handle interface implementation, post the request to the plugin
+func (r *remote) Handle(ctx context.Context, req *Request) (*Result, error) {
+
+	body, err := json.Marshal(newExternalRequest(CorrelationID(ctx), req))
+	if err != nil {
+		return nil, err
+	}
+
+	answer := remoteResult{}
+	if err := r.call(ctx, http.MethodPost, "/handle", body, &answer); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Handled: answer.Handled}
+	for _, m := range answer.Responses {
+		response, err := m.response()
+		if err != nil {
+			zap.L().Warn("Invalid response from remote plugin", zap.String("plugin", r.name()), zap.Error(err))
+			continue
+		}
+		result.Responses = append(result.Responses, response)
+	}
+
+	if answer.Error != "" {
+		return result, errors.New(answer.Error)
+	}
+
+	return result, nil
+}
+
+// Reload interface implementation, fetch the metadata of the plugin
+func (r *remote) Reload() error {
+	return r.refresh()
+}
+
+// refresh fetch the metadata of the plugin, its name is only set once
+func (r *remote) refresh() error {
+
+	d := externalMetadata{}
+	if err := r.call(context.Background(), http.MethodGet, "/metadata", nil, &d); err != nil {
+		return err
+	}
+
+	name := r.name()
+	if name == "" {
+		name = d.Name
+	} else if d.Name != "" && d.Name != name {
+		zap.L().Warn("Remote plugin name changed, keeping the first one", zap.String("plugin", name), zap.String("name", d.Name))
+	}
+
+	r.setMetadata(name, d)
+	return nil
+}
+
+// call the plugin and decode its JSON answer in v
+func (r *remote) call(ctx context.Context, method string, path string, body []byte, v interface{}) error {
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, r.config.URL+path, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Slackhal-Timestamp", timestamp)
+	req.Header.Set("X-Slackhal-Signature", Sign(r.config.Secret, timestamp, body))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, strings.TrimSpace(string(content)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Sign return the signature of a call to a remote plugin
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v1:" + timestamp + ":"))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,49 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadRemotePlugins(t *testing.T) {
+
+	var refreshes int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&refreshes, 1)
+		json.NewEncoder(w).Encode(externalMetadata{Description: "remote"})
+	}))
+	defer server.Close()
+
+	m := &Manager{}
+	m.LoadRemotePlugins([]Remote{
+		{Name: "signed", URL: server.URL, Secret: "secret"},
+		{Name: "unsigned", URL: server.URL},
+	}, 10*time.Millisecond)
+
+	if _, ok := m.Plugin("signed"); !ok {
+		t.Error("signed plugin not registered")
+	}
+	if _, ok := m.Plugin("unsigned"); ok {
+		t.Error("plugin without secret registered")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	m.Stop()
+	// Let a running refresh end
+	time.Sleep(20 * time.Millisecond)
+	stopped := atomic.LoadInt32(&refreshes)
+	if stopped < 2 {
+		t.Errorf("refreshed %d times", stopped)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&refreshes); got != stopped {
+		t.Errorf("refreshed %d times once stopped", got-stopped)
+	}
+
+	// Stopping twice is fine
+	m.Stop()
+}
@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/slack-go/slack"
+)
+
+// Request contains everything known about a message dispatched to a plugin
+type Request struct {
+	// The active trigger name or the text matched by a passive trigger
+	Trigger string
+	// Active is set if Trigger is an active trigger
+	Active bool
+	// Words following an active trigger
+	Args []string
+	// Groups captured by a passive trigger regular expression
+	Captures []string
+	// The user who sent the message
+	User slack.User
+	// The channel where the message was sent
+	Channel slack.Channel
+	// Timestamp of the thread the message belongs to if any
+	Thread string
+	// Mentioned is set if the bot was mentioned or if this is a direct message
+	Mentioned bool
+	// The original message
+	Message slack.Msg
+}
+
+// Result is the result of a request processed by a plugin
+type Result struct {
+	// Handled is set if the plugin processed the request
+	Handled bool
+	// Responses to send, the request channel is used if not set
+	Responses []*SlackResponse
+}
+
+// PluginV2 Interface
+type PluginV2 interface {
+	Init(output chan<- *SlackResponse, bot *Bot)
+	GetMetadata() *Metadata
+	Handle(ctx context.Context, req *Request) (*Result, error)
+}
+
+// contextKey is the type of the keys set in contexts
+type contextKey int
+
+const correlationIDKey contextKey = iota
+
+// NewCorrelationID return a new random correlation id
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithCorrelationID return a context carrying a correlation id
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, id)
+}
+
+// CorrelationID return the correlation id of a context
+func CorrelationID(ctx context.Context) string {
+	if id, ok := ctx.Value(correlationIDKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// v1Adapter adapt a Plugin to the PluginV2 interface
+type v1Adapter struct {
+	Plugin
+}
+
+// Handle interface implementation
+func (a v1Adapter) Handle(ctx context.Context, req *Request) (*Result, error) {
+	return &Result{Handled: a.ProcessMessage(req.Trigger, req.Message)}, nil
+}
+
+// v2Plugin make a PluginV2 usable as a Plugin
+type v2Plugin struct {
+	PluginV2
+}
+
+// ProcessMessage interface implementation, the responses of the result are
+// not sent as there is nowhere to send them, the dispatcher uses Handle.
+func (p v2Plugin) ProcessMessage(command string, message slack.Msg) bool {
+	r, err := p.Handle(context.Background(), &Request{Trigger: command, Message: message})
+	return err == nil && r != nil && r.Handled
+}
+
+// Self interface implementation
+func (p v2Plugin) Self() interface{} {
+	return p.PluginV2
+}
+
+// AsV1 return a PluginV2 as a Plugin
+func AsV1(p PluginV2) Plugin {
+	if v1, ok := p.(Plugin); ok {
+		return v1
+	}
+	return v2Plugin{p}
+}
+
+// AsV2 return a plugin as a PluginV2, v1 plugins are adapted
+func AsV2(p Plugin) PluginV2 {
+	if v2, ok := p.(PluginV2); ok {
+		return v2
+	}
+	return v1Adapter{p}
+}
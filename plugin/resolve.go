@@ -0,0 +1,258 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/karlseguin/ccache"
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+)
+
+/*
+Resolution of the destinations of responses.
+
+A destination can be:
+- a channel ID (C, G or D prefixed), used as is
+- a user ID (U or W prefixed), a direct message is opened
+- a #channel name, public or private if the bot is a member
+- a @username or an email address, a direct message is opened
+- a @usergroup handle or a usergroup ID (S prefixed), a direct message is opened with every member
+- a slack formatted mention like <@U...>, <#C...|name> or <!subteam^S...>
+*/
+
+// directoryTTL is the time the directory of channels, users and groups is cached
+const directoryTTL = time.Hour
+
+// directoryRefresh is the minimum age of a cached directory refreshed when a
+// name is not found, names created since the listing are resolved without
+// listing the directory for every unknown name
+const directoryRefresh = time.Minute
+
+var (
+	channelIDPattern   = regexp.MustCompile(`^[CGD][A-Z0-9]{6,}$`)
+	userIDPattern      = regexp.MustCompile(`^[UW][A-Z0-9]{6,}$`)
+	usergroupIDPattern = regexp.MustCompile(`^S[A-Z0-9]{6,}$`)
+	mentionPattern     = regexp.MustCompile(`^<([#@]|!subteam\^)([A-Z0-9]+)(\|.*)?>$`)
+	emailPattern       = regexp.MustCompile(`^(?:<mailto:)?([^@\s<>|]+@[^@\s<>|]+\.[^@\s<>|]+?)(?:\|.*)?>?$`)
+)
+
+// ResolveChannel return the IDs of the channels a destination refer to
+func (s *Bot) ResolveChannel(destination string) ([]string, error) {
+
+	destination = strings.TrimSpace(destination)
+
+	if m := mentionPattern.FindStringSubmatch(destination); m != nil {
+		switch m[1] {
+		case "#":
+			return []string{m[2]}, nil
+		case "@":
+			return s.directMessages(m[2])
+		default:
+			return s.usergroupDirectMessages(m[2])
+		}
+	}
+
+	switch {
+	case destination == "":
+		return nil, fmt.Errorf("empty destination")
+
+	case channelIDPattern.MatchString(destination):
+		return []string{destination}, nil
+
+	case userIDPattern.MatchString(destination):
+		return s.directMessages(destination)
+
+	case usergroupIDPattern.MatchString(destination):
+		return s.usergroupDirectMessages(destination)
+
+	case strings.HasPrefix(destination, "#"):
+		id, err := s.lookup("channels", strings.ToLower(destination[1:]))
+		if err != nil {
+			return nil, err
+		}
+		return []string{id}, nil
+
+	case strings.HasPrefix(destination, "@"):
+		name := strings.ToLower(destination[1:])
+		if id, err := s.lookup("users", name); err == nil {
+			return s.directMessages(id)
+		}
+		id, err := s.lookup("usergroups", name)
+		if err != nil {
+			return nil, fmt.Errorf("no user or usergroup named %s", destination)
+		}
+		return s.usergroupDirectMessages(id)
+	}
+
+	if m := emailPattern.FindStringSubmatch(destination); m != nil {
+		user, err := s.API.GetUserByEmail(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("no user with email %s: %v", m[1], err)
+		}
+		return s.directMessages(user.ID)
+	}
+
+	return nil, fmt.Errorf("unknown destination %s", destination)
+}
+
+// directMessages return the direct message channel with users
+func (s *Bot) directMessages(users ...string) (channels []string, err error) {
+
+	s.initResolveCaches()
+
+	for _, user := range users {
+
+		if item := s.cachedDMs.Get(user); item != nil {
+			channels = append(channels, item.Value().(string))
+			continue
+		}
+
+		c, _, _, err := s.API.OpenConversation(&slack.OpenConversationParameters{Users: []string{user}})
+		if err != nil {
+			zap.L().Error("Cannot open direct message", zap.String("user", user), zap.Error(err))
+			return nil, err
+		}
+		s.cachedDMs.Set(user, c.ID, 24*time.Hour)
+		channels = append(channels, c.ID)
+	}
+
+	return channels, nil
+}
+
+// usergroupDirectMessages return the direct message channels with the members of a usergroup
+func (s *Bot) usergroupDirectMessages(group string) ([]string, error) {
+	members, err := s.GetCachedGroupInfos(group)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("usergroup %s has no member", group)
+	}
+	return s.directMessages(members...)
+}
+
+// initResolveCaches create the caches used to resolve destinations
+func (s *Bot) initResolveCaches() {
+	s.resolveCaches.Do(func() {
+		s.cachedDirectory = ccache.New(ccache.Configure().MaxSize(10).ItemsToPrune(1))
+		s.cachedDMs = ccache.New(ccache.Configure().MaxSize(1000).ItemsToPrune(100))
+	})
+}
+
+// lookup find the ID of a name in a directory (channels, users or usergroups).
+// The cached directory is refreshed if the name is not found.
+func (s *Bot) lookup(directory string, name string) (string, error) {
+
+	s.initResolveCaches()
+
+	item := s.cachedDirectory.Get(directory)
+	if item != nil && !item.Expired() {
+		if id, ok := item.Value().(map[string]string)[name]; ok {
+			return id, nil
+		}
+		// Listed too recently to know the name yet
+		if directoryTTL-item.TTL() < directoryRefresh {
+			return "", fmt.Errorf("%s not found in %s", name, directory)
+		}
+	}
+
+	names, err := s.listDirectory(directory)
+	if err != nil {
+		zap.L().Error("Error while listing directory", zap.String("directory", directory), zap.Error(err))
+		return "", err
+	}
+	s.cachedDirectory.Set(directory, names, directoryTTL)
+
+	if id, ok := names[name]; ok {
+		return id, nil
+	}
+
+	return "", fmt.Errorf("%s not found in %s", name, directory)
+}
+
+// listDirectory list the names of a directory (channels, users or usergroups)
+func (s *Bot) listDirectory(directory string) (map[string]string, error) {
+	switch directory {
+	case "channels":
+		return s.channelNames()
+	case "users":
+		return s.userNames()
+	case "usergroups":
+		return s.usergroupHandles()
+	}
+	return nil, fmt.Errorf("unknown directory %s", directory)
+}
+
+// channelNames list the channels visible by the bot, including the private ones it belongs to
+func (s *Bot) channelNames() (map[string]string, error) {
+
+	names := map[string]string{}
+
+	p := slack.GetConversationsParameters{
+		Types:           []string{"public_channel", "private_channel"},
+		Limit:           1000,
+		ExcludeArchived: "true",
+	}
+
+	for {
+		channels, next, err := s.API.GetConversations(&p)
+		if err != nil {
+			if rateLimitedError, ok := err.(*slack.RateLimitedError); ok {
+				zap.L().Debug("Reach rate limit on conversations.list, will backoff", zap.Error(err))
+				time.Sleep(rateLimitedError.RetryAfter)
+				continue
+			}
+			return nil, err
+		}
+
+		for _, c := range channels {
+			names[strings.ToLower(c.Name)] = c.ID
+		}
+
+		if next == "" {
+			return names, nil
+		}
+		p.Cursor = next
+	}
+}
+
+// userNames list the users by name and display name
+func (s *Bot) userNames() (map[string]string, error) {
+
+	users, err := s.API.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	for _, u := range users {
+		if u.Deleted {
+			continue
+		}
+		if u.Profile.DisplayName != "" {
+			names[strings.ToLower(u.Profile.DisplayName)] = u.ID
+		}
+		names[strings.ToLower(u.Name)] = u.ID
+	}
+
+	return names, nil
+}
+
+// usergroupHandles list the usergroups by handle
+func (s *Bot) usergroupHandles() (map[string]string, error) {
+
+	groups, err := s.API.GetUserGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	for _, g := range groups {
+		names[strings.ToLower(g.Handle)] = g.ID
+	}
+
+	return names, nil
+}
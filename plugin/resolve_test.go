@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+// fakeSlack is a slack api serving the users in users
+type fakeSlack struct {
+	lock  sync.Mutex
+	users []string
+	// Number of calls by method
+	calls map[string]int
+}
+
+func (f *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	f.calls[method]++
+
+	response := map[string]interface{}{"ok": true}
+	switch method {
+	case "users.list":
+		members := []map[string]interface{}{}
+		for _, name := range f.users {
+			members = append(members, map[string]interface{}{"id": "U" + strings.ToUpper(name), "name": name})
+		}
+		response["members"] = members
+	case "conversations.open":
+		r.ParseForm()
+		response["channel"] = map[string]interface{}{"id": "D" + r.Form.Get("users")}
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// newFakeSlack return a bot using a fake slack api
+func newFakeSlack(t *testing.T, users ...string) (*Bot, *fakeSlack) {
+	f := &fakeSlack{users: users, calls: map[string]int{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return &Bot{API: slack.New("token", slack.OptionAPIURL(server.URL+"/"))}, f
+}
+
+func TestLookupRefreshOnMiss(t *testing.T) {
+
+	bot, api := newFakeSlack(t, "alice")
+
+	if _, err := bot.lookup("users", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Listed too recently, not listed again
+	api.lock.Lock()
+	api.users = append(api.users, "bob")
+	api.lock.Unlock()
+	if _, err := bot.lookup("users", "bob"); err == nil {
+		t.Error("bob found in a fresh listing")
+	}
+
+	// An older listing is refreshed
+	bot.cachedDirectory.Set("users", map[string]string{"alice": "UALICE"}, directoryTTL-2*directoryRefresh)
+	id, err := bot.lookup("users", "bob")
+	if err != nil || id != "UBOB" {
+		t.Errorf("got %q, %v", id, err)
+	}
+	if _, err := bot.lookup("users", "carol"); err == nil {
+		t.Error("carol found")
+	}
+
+	if calls := api.calls["users.list"]; calls != 2 {
+		t.Errorf("users listed %d times", calls)
+	}
+}
+
+func TestResolveChannelConcurrently(t *testing.T) {
+
+	bot, api := newFakeSlack(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			channels, err := bot.ResolveChannel("UALICE1")
+			if err != nil || len(channels) != 1 || channels[0] != "DUALICE1" {
+				t.Errorf("got %v, %v", channels, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Once cached the direct message is not opened again
+	api.lock.Lock()
+	opened := api.calls["conversations.open"]
+	api.lock.Unlock()
+	if _, err := bot.ResolveChannel("UALICE1"); err != nil {
+		t.Fatal(err)
+	}
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	if api.calls["conversations.open"] != opened {
+		t.Error("direct message opened again")
+	}
+}
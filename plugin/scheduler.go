@@ -0,0 +1,375 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"go.uber.org/zap"
+)
+
+/*
+The scheduler runs periodic jobs registered by plugins or set in the configuration.
+
+The next activation of every job is persisted so runs missed while the bot was
+down can be caught up according to the job catch up policy. A job never runs
+twice at the same time, activations happening while it runs are skipped.
+*/
+
+// CatchUpPolicy define what to do with runs missed while the bot was down
+type CatchUpPolicy string
+
+// Catch up policies
+const (
+	// CatchUpSkip will ignore missed runs
+	CatchUpSkip CatchUpPolicy = "skip"
+	// CatchUpOnce will run the job once if at least one run was missed
+	CatchUpOnce CatchUpPolicy = "once"
+	// CatchUpAll will run the job for every missed run
+	CatchUpAll CatchUpPolicy = "all"
+)
+
+// maxCatchUp is the maximum number of runs replayed with CatchUpAll
+const maxCatchUp = 100
+
+// Job define a periodic job
+type Job struct {
+	// The name of the job, unique for its owner
+	Name string
+	// A cron expression or a descriptor like @every 1h
+	Schedule string
+	// What to do with missed runs, the scheduler default is used if not set
+	CatchUp CatchUpPolicy
+	// Run is called at each activation with the activation time
+	Run func(time.Time) `json:"-"`
+	// Command is dispatched as if it was sent in Channel if Run is not set
+	Channel string
+	Command string
+}
+
+// JobStatus is the state of a scheduled job
+type JobStatus struct {
+	ID       string `storm:"id"`
+	Owner    string
+	Name     string
+	Schedule string
+	Channel  string
+	Command  string
+	NextRun  time.Time
+	LastRun  time.Time
+}
+
+// scheduledJob is a job managed by the scheduler
+type scheduledJob struct {
+	job      Job
+	schedule Schedule
+	status   JobStatus
+	// missed runs to catch up
+	missed []time.Time
+	// set while the job runs, activations are skipped meanwhile
+	running bool
+}
+
+// Scheduler run periodic jobs
+type Scheduler struct {
+	// Location used to evaluate cron expressions
+	Location *time.Location
+	// Default catch up policy
+	CatchUp CatchUpPolicy
+	// Dispatch is called for jobs with a command
+	Dispatch func(channel, command string)
+	db       *storm.DB
+	jobs     map[string]*scheduledJob
+	wake     chan struct{}
+	lock     sync.Mutex
+	started  sync.Once
+}
+
+// Init the scheduler with the database used to persist jobs state.
+// Jobs can be added once initialized but they will only run when the scheduler is started.
+func (s *Scheduler) Init(dbPath string) (err error) {
+
+	if s.Location == nil {
+		s.Location = time.Local
+	}
+	if s.CatchUp == "" {
+		s.CatchUp = CatchUpSkip
+	}
+
+	if dbPath != "" && s.db == nil {
+		s.db, err = storm.Open(dbPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.lock.Lock()
+	if s.jobs == nil {
+		s.jobs = map[string]*scheduledJob{}
+	}
+	s.wake = make(chan struct{}, 1)
+	s.lock.Unlock()
+
+	return nil
+}
+
+// Start running the jobs, missed runs are caught up first
+func (s *Scheduler) Start() {
+	s.started.Do(func() {
+		go s.loop()
+	})
+}
+
+// Add a job for the given owner. If a job with the same name already exists it is replaced.
+func (s *Scheduler) Add(owner string, job Job) error {
+
+	schedule, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return err
+	}
+
+	if job.Run == nil && (job.Channel == "" || job.Command == "") {
+		return fmt.Errorf("job %s must have either a Run function or a channel and a command", job.Name)
+	}
+
+	if job.CatchUp == "" {
+		job.CatchUp = s.CatchUp
+	}
+
+	now := time.Now().In(s.Location)
+
+	j := &scheduledJob{
+		job:      job,
+		schedule: schedule,
+		status: JobStatus{
+			ID:       owner + "/" + job.Name,
+			Owner:    owner,
+			Name:     job.Name,
+			Schedule: job.Schedule,
+			Channel:  job.Channel,
+			Command:  job.Command,
+			NextRun:  schedule.Next(now),
+		},
+	}
+
+	// Look for a previous state to catch up missed runs
+	var missed []time.Time
+	if s.db != nil {
+		previous := JobStatus{}
+		if err := s.db.One("ID", j.status.ID, &previous); err == nil && previous.Schedule == job.Schedule {
+			j.status.LastRun = previous.LastRun
+			for t := previous.NextRun.In(s.Location); !t.IsZero() && t.Before(now) && len(missed) < maxCatchUp; t = schedule.Next(t) {
+				missed = append(missed, t)
+			}
+		}
+	}
+
+	if len(missed) > 0 {
+		zap.L().Info("Missed runs for job", zap.String("job", j.status.ID), zap.Int("missed", len(missed)), zap.String("policy", string(job.CatchUp)))
+		switch job.CatchUp {
+		case CatchUpOnce:
+			j.missed = missed[len(missed)-1:]
+		case CatchUpAll:
+			j.missed = missed
+		}
+	}
+
+	s.lock.Lock()
+	if s.jobs == nil {
+		s.jobs = map[string]*scheduledJob{}
+	}
+	s.jobs[j.status.ID] = j
+	status := j.status
+	s.lock.Unlock()
+
+	s.save(status)
+
+	s.notify()
+
+	zap.L().Info("Job scheduled", zap.String("job", status.ID), zap.String("schedule", job.Schedule), zap.Time("next", status.NextRun))
+
+	return nil
+}
+
+// Remove a job
+func (s *Scheduler) Remove(owner, name string) {
+	id := owner + "/" + name
+	s.lock.Lock()
+	delete(s.jobs, id)
+	s.lock.Unlock()
+	if s.db != nil {
+		if err := s.db.DeleteStruct(&JobStatus{ID: id}); err != nil && err != storm.ErrNotFound {
+			zap.L().Error("Failed to remove job state", zap.String("job", id), zap.Error(err))
+		}
+	}
+	s.notify()
+}
+
+// List return the status of the jobs sorted by next run
+func (s *Scheduler) List() (jobs []JobStatus) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, j := range s.jobs {
+		jobs = append(jobs, j.status)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].NextRun.Before(jobs[k].NextRun) })
+	return
+}
+
+// catchUp run the missed runs of the jobs, the runs of a job still running are caught up later
+func (s *Scheduler) catchUp() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, j := range s.jobs {
+		if len(j.missed) > 0 && !j.running {
+			j.running = true
+			go func(j *scheduledJob, missed []time.Time) {
+				defer s.done(j)
+				for _, t := range missed {
+					s.run(j, t)
+				}
+			}(j, j.missed)
+			j.missed = nil
+		}
+	}
+}
+
+// done record that a job is not running anymore
+func (s *Scheduler) done(j *scheduledJob) {
+	s.lock.Lock()
+	j.running = false
+	s.lock.Unlock()
+}
+
+// loop wait for the next job to run
+func (s *Scheduler) loop() {
+
+	for {
+		s.catchUp()
+		timer := time.NewTimer(s.nextWakeUp())
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		}
+		s.runDue(time.Now().In(s.Location))
+	}
+}
+
+// nextWakeUp return the duration until the next job to run
+func (s *Scheduler) nextWakeUp() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Wake up at least every hour to stay accurate with clock changes
+	next := time.Hour
+	for _, j := range s.jobs {
+		if d := time.Until(j.status.NextRun); d < next {
+			next = d
+		}
+	}
+	if next < 0 {
+		next = 0
+	}
+	return next
+}
+
+// runDue run the jobs which are due and compute their next run.
+// Jobs still running are skipped until their next run.
+func (s *Scheduler) runDue(now time.Time) {
+	// The status is copied under the lock as runs update it
+	type dueJob struct {
+		job        *scheduledJob
+		activation time.Time
+		status     JobStatus
+		// The activation is skipped as the job is still running
+		skipped bool
+	}
+	due := []dueJob{}
+
+	s.lock.Lock()
+	for _, j := range s.jobs {
+		if !j.status.NextRun.IsZero() && !j.status.NextRun.After(now) {
+			activation := j.status.NextRun
+			j.status.NextRun = j.schedule.Next(now)
+			if j.running {
+				zap.L().Warn("Job still running, activation skipped", zap.String("job", j.status.ID), zap.Time("activation", activation))
+				due = append(due, dueJob{job: j, status: j.status, skipped: true})
+				continue
+			}
+			j.running = true
+			j.status.LastRun = now
+			due = append(due, dueJob{job: j, activation: activation, status: j.status})
+		}
+	}
+	s.lock.Unlock()
+
+	for _, d := range due {
+		s.saveScheduled(d.job, d.status)
+		if d.skipped {
+			continue
+		}
+		go func(d dueJob) {
+			defer s.done(d.job)
+			s.run(d.job, d.activation)
+		}(d)
+	}
+}
+
+// run a job
+func (s *Scheduler) run(j *scheduledJob, t time.Time) {
+	// Jobs of disabled plugins are not run
+	if PluginManager.Disabled(j.status.Owner) {
+		return
+	}
+	zap.L().Debug("Running job", zap.String("job", j.status.ID), zap.Time("activation", t))
+	switch {
+	case j.job.Run != nil:
+		err := Protect(func() error {
+			j.job.Run(t)
+			return nil
+		})
+		if perr, ok := err.(*PanicError); ok {
+			zap.L().Error("Job panicked", zap.String("plugin", j.status.Owner), zap.String("job", j.status.ID), zap.Reflect("panic", perr.Value), zap.ByteString("stack", perr.Stack))
+			PluginManager.Failed(j.status.Owner)
+		}
+	case s.Dispatch != nil:
+		s.Dispatch(j.job.Channel, j.job.Command)
+	default:
+		zap.L().Warn("No dispatcher set for command job", zap.String("job", j.status.ID))
+	}
+}
+
+// saveScheduled save the state of a job unless it was removed or replaced since the state was copied
+func (s *Scheduler) saveScheduled(j *scheduledJob, status JobStatus) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.jobs[status.ID] != j {
+		zap.L().Debug("Job removed, state not saved", zap.String("job", status.ID))
+		return
+	}
+	s.save(status)
+}
+
+// save the state of a job
+func (s *Scheduler) save(status JobStatus) {
+	if s.db == nil {
+		return
+	}
+	if err := s.db.Save(&status); err != nil {
+		zap.L().Error("Failed to save job state", zap.String("job", status.ID), zap.Error(err))
+	}
+}
+
+// notify the loop that jobs changed
+func (s *Scheduler) notify() {
+	if s.wake == nil {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
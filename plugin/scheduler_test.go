@@ -0,0 +1,191 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm"
+)
+
+func TestSchedulerCatchUp(t *testing.T) {
+
+	tests := []struct {
+		policy CatchUpPolicy
+		missed int
+	}{
+		{CatchUpSkip, 0},
+		{CatchUpOnce, 1},
+		{CatchUpAll, 4},
+		// The scheduler default
+		{"", 0},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "scheduler")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		s := &Scheduler{Location: time.UTC}
+		if err := s.Init(filepath.Join(dir, "scheduler.db")); err != nil {
+			t.Fatal(err)
+		}
+
+		// Down for three hours and a half
+		now := time.Now().In(time.UTC)
+		previous := JobStatus{ID: "test/job", Owner: "test", Name: "job", Schedule: "@every 1h", NextRun: now.Add(-210 * time.Minute)}
+		if err := s.db.Save(&previous); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.Add("test", Job{Name: "job", Schedule: "@every 1h", CatchUp: test.policy, Run: func(time.Time) {}}); err != nil {
+			t.Fatal(err)
+		}
+		missed := s.jobs["test/job"].missed
+		if len(missed) != test.missed {
+			t.Errorf("%q: got %d missed runs, want %d", test.policy, len(missed), test.missed)
+		}
+		// The last missed run is caught up once
+		if test.policy == CatchUpOnce && len(missed) == 1 && !missed[0].Equal(previous.NextRun.Add(3*time.Hour).Truncate(time.Second)) {
+			t.Errorf("%q: caught up %s", test.policy, missed[0])
+		}
+		s.db.Close()
+	}
+}
+
+func TestSchedulerChangedScheduleIsNotCaughtUp(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Scheduler{Location: time.UTC, CatchUp: CatchUpAll}
+	if err := s.Init(filepath.Join(dir, "scheduler.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+
+	previous := JobStatus{ID: "test/job", Schedule: "@every 1h", NextRun: time.Now().Add(-5 * time.Hour)}
+	if err := s.db.Save(&previous); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("test", Job{Name: "job", Schedule: "@every 2h", Run: func(time.Time) {}}); err != nil {
+		t.Fatal(err)
+	}
+	if missed := s.jobs["test/job"].missed; len(missed) != 0 {
+		t.Errorf("got %d missed runs", len(missed))
+	}
+}
+
+func TestSchedulerSkipsRunningJobs(t *testing.T) {
+
+	s := &Scheduler{Location: time.UTC}
+	if err := s.Init(""); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan time.Time, 10)
+	release := make(chan struct{})
+	err := s.Add("test", Job{Name: "slow", Schedule: "@every 1h", Run: func(at time.Time) {
+		started <- at
+		<-release
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// due make the job due and run the due jobs
+	due := func() {
+		now := time.Now().In(time.UTC)
+		s.lock.Lock()
+		s.jobs["test/slow"].status.NextRun = now.Add(-time.Minute)
+		s.lock.Unlock()
+		s.runDue(now)
+	}
+
+	due()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("job not run")
+	}
+
+	due()
+	select {
+	case <-started:
+		t.Fatal("job run while running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if next := s.List()[0].NextRun; !next.After(time.Now()) {
+		t.Errorf("skipped activation not rescheduled: %s", next)
+	}
+
+	close(release)
+	for deadline := time.Now().Add(time.Second); ; {
+		s.lock.Lock()
+		running := s.jobs["test/slow"].running
+		s.lock.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job still running")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	due()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("job not run once done")
+	}
+}
+
+func TestSchedulerRemovedJobStateNotSaved(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Scheduler{Location: time.UTC}
+	if err := s.Init(filepath.Join(dir, "scheduler.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+
+	add := func(schedule string) *scheduledJob {
+		if err := s.Add("test", Job{Name: "job", Schedule: schedule, Run: func(time.Time) {}}); err != nil {
+			t.Fatal(err)
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		return s.jobs["test/job"]
+	}
+
+	// A state copied by runDue before the job is removed
+	removed := add("@every 1h")
+	stale := removed.status
+	stale.LastRun = time.Now()
+	s.Remove("test", "job")
+	s.saveScheduled(removed, stale)
+	if err := s.db.One("ID", "test/job", &JobStatus{}); err != storm.ErrNotFound {
+		t.Errorf("state of a removed job saved: %v", err)
+	}
+
+	// Nor does it override the state of the job replacing it
+	add("@every 2h")
+	s.saveScheduled(removed, stale)
+	status := JobStatus{}
+	if err := s.db.One("ID", "test/job", &status); err != nil || status.Schedule != "@every 2h" {
+		t.Errorf("got state %+v, %v", status, err)
+	}
+}
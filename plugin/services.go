@@ -0,0 +1,168 @@
+package plugin
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+Services shared between plugins.
+
+A plugin declares the services it publishes in Metadata.Provides and publishes
+them with Provide during its Init. A plugin needing services declares them in
+Metadata.Requires and looks them up with Lookup, its Init is called after the
+Init of the plugins providing them.
+*/
+
+// service is a published service
+type service struct {
+	plugin string
+	value  interface{}
+}
+
+// services is the registry of the published services
+type services struct {
+	services map[string]service
+	lock     sync.RWMutex
+}
+
+// Provide publish a service of a plugin
+func (m *Manager) Provide(plugin string, name string, value interface{}) {
+	m.services.lock.Lock()
+	defer m.services.lock.Unlock()
+	if m.services.services == nil {
+		m.services.services = map[string]service{}
+	}
+	m.services.services[name] = service{plugin: plugin, value: value}
+}
+
+// Service return a published service, services of disabled plugins are not available
+func (m *Manager) Service(name string) (interface{}, error) {
+	m.services.lock.RLock()
+	s, ok := m.services.services[name]
+	m.services.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("service %s is not available", name)
+	}
+	if m.Disabled(s.plugin) {
+		return nil, fmt.Errorf("service %s is not available, plugin %s is disabled", name, s.plugin)
+	}
+	return s.value, nil
+}
+
+// Lookup set target, a pointer to an interface, to a published service.
+// An error is returned if the service is not available or does not implement the interface.
+func (m *Manager) Lookup(name string, target interface{}) error {
+
+	value, err := m.Service(name)
+	if err != nil {
+		return err
+	}
+
+	t := reflect.ValueOf(target)
+	if t.Kind() != reflect.Ptr || t.IsNil() {
+		return fmt.Errorf("target of service %s must be a non nil pointer", name)
+	}
+
+	v := reflect.ValueOf(value)
+	if !v.Type().AssignableTo(t.Elem().Type()) {
+		return fmt.Errorf("service %s is a %s, not a %s", name, v.Type(), t.Elem().Type())
+	}
+
+	t.Elem().Set(v)
+	return nil
+}
+
+// Missing return the services required by a plugin which are not available
+func (m *Manager) Missing(p Plugin) (missing []string) {
+	for _, name := range p.GetMetadata().Requires {
+		if _, err := m.Service(name); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	return
+}
+
+// Dependents return the enabled plugins requiring a service provided by a plugin
+func (m *Manager) Dependents(name string) (dependents []string) {
+	p, ok := m.Plugin(name)
+	if !ok {
+		return nil
+	}
+	provided := map[string]bool{}
+	for _, s := range p.GetMetadata().Provides {
+		provided[s] = true
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, d := range m.Plugins {
+		meta := d.GetMetadata()
+		if meta.Name == name || m.Disabled(meta.Name) {
+			continue
+		}
+		for _, s := range meta.Requires {
+			if provided[s] {
+				dependents = append(dependents, meta.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(dependents)
+	return dependents
+}
+
+// InitOrder sort plugins so the ones providing services come before the ones requiring them.
+// Plugins requiring a service which is not provided are kept, they are not loaded as the service is missing.
+// An error is returned if plugins require each other.
+func InitOrder(plugins []Plugin) ([]Plugin, error) {
+
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].GetMetadata().Name < plugins[j].GetMetadata().Name
+	})
+
+	providers := map[string]Plugin{}
+	for _, p := range plugins {
+		for _, name := range p.GetMetadata().Provides {
+			providers[name] = p
+		}
+	}
+
+	ordered := []Plugin{}
+	// 1 while visiting the plugin, 2 once ordered
+	state := map[string]int{}
+
+	var visit func(p Plugin, path []string) error
+	visit = func(p Plugin, path []string) error {
+		meta := p.GetMetadata()
+		switch state[meta.Name] {
+		case 1:
+			return fmt.Errorf("plugins require each other: %s", strings.Join(append(path, meta.Name), " -> "))
+		case 2:
+			return nil
+		}
+		state[meta.Name] = 1
+		for _, name := range meta.Requires {
+			provider, ok := providers[name]
+			if !ok || provider == p {
+				continue
+			}
+			if err := visit(provider, append(path, meta.Name)); err != nil {
+				return err
+			}
+		}
+		state[meta.Name] = 2
+		ordered = append(ordered, p)
+		return nil
+	}
+
+	for _, p := range plugins {
+		if err := visit(p, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
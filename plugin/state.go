@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/asdine/storm"
+	"go.uber.org/zap"
+)
+
+/*
+Runtime state of the plugins.
+
+Plugins can be enabled and disabled while the bot is running, this state is
+persisted so it survives restarts and takes precedence over the configuration.
+
+The state of a loaded plugin is kept by the manager and read with Disabled,
+Metadata.Disabled is only set by plugins disabling themselves during their init.
+*/
+
+// Reloader is implemented by plugins able to reload their configuration or their resources
+type Reloader interface {
+	Reload() error
+}
+
+// Suspender is implemented by plugins running resources, like a process, which are released while they are disabled
+type Suspender interface {
+	Suspend() error
+	Resume() error
+}
+
+// pluginState is the persisted state of a plugin
+type pluginState struct {
+	Name     string `storm:"id"`
+	Disabled bool
+}
+
+// states keep the runtime state of the plugins
+type states struct {
+	db          *storm.DB
+	initialized map[string]bool
+	disabled    map[string]bool
+	lock        sync.Mutex
+}
+
+// InitState open the database where the state of the plugins is persisted
+func (m *Manager) InitState(dbPath string) (err error) {
+	if dbPath == "" {
+		return nil
+	}
+	m.states.db, err = storm.Open(dbPath)
+	return err
+}
+
+// SavedState return if a plugin has been disabled or enabled at runtime
+func (m *Manager) SavedState(name string) (disabled bool, found bool) {
+	if m.states.db == nil {
+		return false, false
+	}
+	s := pluginState{}
+	if err := m.states.db.One("Name", name, &s); err != nil {
+		return false, false
+	}
+	return s.Disabled, true
+}
+
+// SetDisabled enable or disable a plugin and persist its state.
+// Failures are reset when enabling a plugin, and plugins implementing Suspender are suspended or resumed.
+func (m *Manager) SetDisabled(name string, disabled bool) error {
+	p, ok := m.Plugin(name)
+	if !ok {
+		return fmt.Errorf("no such plugin %s", name)
+	}
+
+	if !disabled {
+		m.failures.lock.Lock()
+		delete(m.failures.counts, name)
+		delete(m.failures.recent, name)
+		delete(m.failures.tripped, name)
+		m.failures.lock.Unlock()
+	}
+	m.MarkDisabled(name, disabled)
+
+	if s, ok := implementation(p).(Suspender); ok {
+		suspend := s.Resume
+		if disabled {
+			suspend = s.Suspend
+		}
+		if err := Protect(suspend); err != nil {
+			zap.L().Warn("Cannot suspend or resume plugin", zap.String("plugin", name), zap.Bool("disabled", disabled), zap.Error(err))
+		}
+	}
+
+	if m.states.db != nil {
+		return m.states.db.Save(&pluginState{Name: name, Disabled: disabled})
+	}
+	return nil
+}
+
+// MarkDisabled enable or disable a plugin without persisting its state
+func (m *Manager) MarkDisabled(name string, disabled bool) {
+	m.states.lock.Lock()
+	defer m.states.lock.Unlock()
+	if m.states.disabled == nil {
+		m.states.disabled = map[string]bool{}
+	}
+	m.states.disabled[name] = disabled
+}
+
+// Disabled return if a plugin is disabled
+func (m *Manager) Disabled(name string) bool {
+	m.states.lock.Lock()
+	defer m.states.lock.Unlock()
+	return m.states.disabled[name]
+}
+
+// SetInitialized record that the Init of a plugin succeeded
+func (m *Manager) SetInitialized(name string) {
+	m.states.lock.Lock()
+	defer m.states.lock.Unlock()
+	if m.states.initialized == nil {
+		m.states.initialized = map[string]bool{}
+	}
+	m.states.initialized[name] = true
+}
+
+// Initialized return if the Init of a plugin succeeded
+func (m *Manager) Initialized(name string) bool {
+	m.states.lock.Lock()
+	defer m.states.lock.Unlock()
+	return m.states.initialized[name]
+}
+
+// Reload a plugin implementing Reloader
+func (m *Manager) Reload(name string) error {
+	p, ok := m.Plugin(name)
+	if !ok {
+		return fmt.Errorf("no such plugin %s", name)
+	}
+	if r, ok := implementation(p).(Reloader); ok {
+		return Protect(r.Reload)
+	}
+	return fmt.Errorf("plugin %s cannot be reloaded", name)
+}
@@ -0,0 +1,234 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"go.uber.org/zap"
+)
+
+/*
+Storage shared by the plugins.
+
+The plugins store their state in a single database in the data directory, each
+plugin in its own namespace. Values are encoded as JSON and can expire.
+*/
+
+// Storage errors
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	ErrNoStorage   = errors.New("storage is not initialized")
+)
+
+// storageFile is the name of the database in the data directory
+const storageFile = "storage.db"
+
+// Storage is the database shared by the plugins
+type Storage struct {
+	// Interval between two removals of the expired keys
+	CleanupInterval time.Duration
+	db              *storm.DB
+	// The namespaces opened by the plugins
+	namespaces map[string]storm.Node
+	started    sync.Once
+	lock       sync.Mutex
+}
+
+// storeEntry is a value stored by a plugin
+type storeEntry struct {
+	Key string `storm:"id"`
+	// The key again, storm only queries the prefix of indexed fields
+	Name    string `storm:"index"`
+	Value   []byte
+	Expires time.Time
+}
+
+// expired return if the entry has expired
+func (e *storeEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// Store is the namespace of a plugin in the storage
+type Store struct {
+	node storm.Node
+	// Set when the store is bound to a transaction
+	tx bool
+}
+
+// Init open the storage in the data directory and start removing the expired keys
+func (s *Storage) Init(dir string) (err error) {
+
+	if s.CleanupInterval == 0 {
+		s.CleanupInterval = time.Minute
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	if s.db == nil {
+		s.db, err = storm.Open(filepath.Join(dir, storageFile))
+		if err != nil {
+			return err
+		}
+	}
+
+	s.started.Do(func() {
+		go func() {
+			for range time.Tick(s.CleanupInterval) {
+				s.cleanup()
+			}
+		}()
+	})
+
+	return nil
+}
+
+// cleanup remove the expired keys of the plugins
+func (s *Storage) cleanup() {
+	s.lock.Lock()
+	nodes := []storm.Node{}
+	for _, n := range s.namespaces {
+		nodes = append(nodes, n)
+	}
+	s.lock.Unlock()
+
+	now := time.Now()
+	for _, n := range nodes {
+		entries := []storeEntry{}
+		if err := n.All(&entries); err != nil && err != storm.ErrNotFound {
+			zap.L().Warn("Cannot read plugin storage", zap.Strings("bucket", n.Bucket()), zap.Error(err))
+			continue
+		}
+		for i := range entries {
+			if entries[i].expired(now) {
+				if err := n.DeleteStruct(&entries[i]); err != nil && err != storm.ErrNotFound {
+					zap.L().Warn("Cannot remove expired key", zap.Strings("bucket", n.Bucket()), zap.String("key", entries[i].Key), zap.Error(err))
+				}
+			}
+		}
+	}
+}
+
+// Store return the namespace of a plugin in the shared storage
+func (s *Bot) Store(name string) *Store {
+	return s.Storage.namespace(name)
+}
+
+// namespace return the store of a plugin
+func (s *Storage) namespace(name string) *Store {
+	if s.db == nil {
+		return &Store{}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.namespaces == nil {
+		s.namespaces = map[string]storm.Node{}
+	}
+	n, ok := s.namespaces[name]
+	if !ok {
+		n = s.db.From("plugins", name)
+		s.namespaces[name] = n
+	}
+	return &Store{node: n}
+}
+
+// Get decode the value of a key in v.
+// ErrKeyNotFound is returned if the key does not exist or has expired.
+func (s *Store) Get(key string, v interface{}) error {
+	if s.node == nil {
+		return ErrNoStorage
+	}
+	e := storeEntry{}
+	if err := s.node.One("Key", key, &e); err != nil {
+		if err == storm.ErrNotFound {
+			return ErrKeyNotFound
+		}
+		return err
+	}
+	if e.expired(time.Now()) {
+		return ErrKeyNotFound
+	}
+	return json.Unmarshal(e.Value, v)
+}
+
+// Put set the value of a key
+func (s *Store) Put(key string, v interface{}) error {
+	return s.PutWithTTL(key, v, 0)
+}
+
+// PutWithTTL set the value of a key expiring after ttl, a zero ttl never expires
+func (s *Store) PutWithTTL(key string, v interface{}, ttl time.Duration) error {
+	if s.node == nil {
+		return ErrNoStorage
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e := storeEntry{Key: key, Name: key, Value: raw}
+	if ttl > 0 {
+		e.Expires = time.Now().Add(ttl)
+	}
+	return s.node.Save(&e)
+}
+
+// Delete a key, deleting a missing key is not an error
+func (s *Store) Delete(key string) error {
+	if s.node == nil {
+		return ErrNoStorage
+	}
+	err := s.node.DeleteStruct(&storeEntry{Key: key})
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// List return the keys starting with prefix in lexical order, expired keys excepted
+func (s *Store) List(prefix string) ([]string, error) {
+	if s.node == nil {
+		return nil, ErrNoStorage
+	}
+	entries := []storeEntry{}
+	if err := s.node.Prefix("Name", prefix, &entries); err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	now := time.Now()
+	keys := []string{}
+	for i := range entries {
+		if !entries[i].expired(now) {
+			keys = append(keys, entries[i].Key)
+		}
+	}
+	return keys, nil
+}
+
+// Update run f in a transaction, changes made through tx are discarded if f returns an error
+func (s *Store) Update(f func(tx *Store) error) error {
+	if s.node == nil {
+		return ErrNoStorage
+	}
+	if s.tx {
+		return f(s)
+	}
+
+	node, err := s.node.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	err = Protect(func() error { return f(&Store{node: node, tx: true}) })
+	if err != nil {
+		if rbErr := node.Rollback(); rbErr != nil {
+			zap.L().Warn("Cannot rollback transaction", zap.Error(rbErr))
+		}
+		return err
+	}
+	return node.Commit()
+}
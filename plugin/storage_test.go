@@ -0,0 +1,185 @@
+package plugin
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestStorage return a storage in a temporary directory
+func newTestStorage(t *testing.T) *Storage {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Expired keys are removed by the tests
+	s := &Storage{CleanupInterval: time.Hour}
+	if err := s.Init(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.db.Close()
+		os.RemoveAll(dir)
+	})
+	return s
+}
+
+func TestStore(t *testing.T) {
+
+	s := newTestStorage(t)
+	store := s.namespace("karma")
+	other := s.namespace("other")
+
+	if err := store.Put("user:U1", 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("user:U2", 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutWithTTL("user:U3", 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutWithTTL("user:U4", 1, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("cooldown:U1", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Put("user:U9", 1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	tests := []struct {
+		store *Store
+		key   string
+		want  int
+		err   error
+	}{
+		{store, "user:U1", 42, nil},
+		{store, "user:U3", 1, nil},
+		// Expired
+		{store, "user:U4", 0, ErrKeyNotFound},
+		{store, "user:U5", 0, ErrKeyNotFound},
+		// Namespaces are isolated
+		{store, "user:U9", 0, ErrKeyNotFound},
+		{other, "user:U1", 0, ErrKeyNotFound},
+		{other, "user:U9", 1, nil},
+		{&Store{}, "user:U1", 0, ErrNoStorage},
+	}
+
+	for _, test := range tests {
+		got := 0
+		if err := test.store.Get(test.key, &got); err != test.err || got != test.want {
+			t.Errorf("%s: got %d, %v, want %d, %v", test.key, got, err, test.want, test.err)
+		}
+	}
+
+	keys, err := store.List("user:")
+	if want := []string{"user:U1", "user:U2", "user:U3"}; err != nil || !reflect.DeepEqual(keys, want) {
+		t.Errorf("got keys %v, %v, want %v", keys, err, want)
+	}
+
+	if err := store.Delete("user:U2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("user:U2"); err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+	if keys, _ := store.List(""); len(keys) != 3 {
+		t.Errorf("got keys %v after delete", keys)
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+
+	s := newTestStorage(t)
+	store := s.namespace("karma")
+
+	if err := store.Put("user:U1", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// increment the score of U1 and set U2 in a transaction failing with fail
+	increment := func(fail error) func(tx *Store) error {
+		return func(tx *Store) error {
+			score := 0
+			if err := tx.Get("user:U1", &score); err != nil {
+				return err
+			}
+			if err := tx.Put("user:U1", score+1); err != nil {
+				return err
+			}
+			if err := tx.Put("user:U2", 1); err != nil {
+				return err
+			}
+			if fail != nil {
+				return fail
+			}
+			// Nested updates run in the transaction
+			return tx.Update(func(tx *Store) error { return tx.Delete("user:U2") })
+		}
+	}
+
+	failed := errors.New("failed")
+	tests := []struct {
+		name string
+		f    func(tx *Store) error
+		err  error
+		// Expected score of U1 and keys after the transaction
+		score int
+		keys  []string
+	}{
+		{"commit", increment(nil), nil, 2, []string{"user:U1"}},
+		{"rollback", increment(failed), failed, 2, []string{"user:U1"}},
+		{"panic", func(tx *Store) error { tx.Put("user:U1", 0); panic("boom") }, nil, 2, []string{"user:U1"}},
+	}
+
+	for _, test := range tests {
+		err := store.Update(test.f)
+		if _, panicked := err.(*PanicError); err != test.err && !(test.name == "panic" && panicked) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		}
+		score := 0
+		if err := store.Get("user:U1", &score); err != nil || score != test.score {
+			t.Errorf("%s: got score %d, %v, want %d", test.name, score, err, test.score)
+		}
+		if keys, _ := store.List(""); !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%s: got keys %v, want %v", test.name, keys, test.keys)
+		}
+	}
+}
+
+func TestStorageCleanup(t *testing.T) {
+
+	s := newTestStorage(t)
+	store := s.namespace("karma")
+
+	if err := store.Put("kept", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutWithTTL("expiring", 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutWithTTL("expired", 1, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	s.cleanup()
+
+	entries := []storeEntry{}
+	if err := store.node.All(&entries); err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	if want := []string{"expiring", "kept"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got keys %v after cleanup, want %v", keys, want)
+	}
+}
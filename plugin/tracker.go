@@ -1,100 +1,283 @@
 package plugin
 
 import (
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/slack-go/slack"
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"go.uber.org/zap"
 )
 
 /*
-The main purpose of this tracket is to be able to track sent message and edit them later
+The main purpose of this tracker is to be able to track sent message and edit them later
+
+Trackers are namespaced by plugin and channel so plugins can choose their keys freely.
+They can be persisted so tracked messages can still be edited after a restart.
+
+Messages scheduled with a tracker are kept as well, until they are posted, so
+they can still be rescheduled or cancelled after a restart.
 */
 
+// DefaultTrackedTTL is the TTL of a tracker if not set by the response
+const DefaultTrackedTTL = 5 * time.Hour
+
 // TrackerManager is keeping list of Trackers
 type TrackerManager struct {
 	// Contains the list of current trackers
-	Trackers map[int]*Tracker
-	// Lock to avoid concurent access to array
-	lock sync.Mutex
+	trackers map[TrackerKey]*Tracker
+	// Contains the messages scheduled by tracker
+	scheduled map[TrackerKey]*ScheduledMessage
+	// Lock to avoid concurent access to the trackers
+	lock sync.RWMutex
+	// Optional database to persist trackers
+	db *storm.DB
+}
+
+// TrackerKey identify a tracked message
+type TrackerKey struct {
+	// The plugin which sent the message
+	Plugin string
+	// The channel where the message was sent
+	Channel string
+	// The key chosen by the plugin
+	Key string
 }
 
 // Tracker define a tracker
 type Tracker struct {
-	// The tracker id set by the plugin
-	TrackerID int
+	TrackerKey
+	// The channel of the message as returned by slack
+	TrackedChannel string
 	// The TimeStamp of the message to track
 	TimeStamp string
-	// The TTL of the Tracker before it's gargabe collected (in minutes)
-	TTL int
+	// When the tracker is garbage collected
+	Expire time.Time
+}
+
+// ScheduledMessage is a message scheduled with a tracker
+type ScheduledMessage struct {
+	TrackerKey
+	// The channel of the message as returned by slack
+	ScheduledChannel string
+	// The id of the scheduled message
+	ID string
+	// When the message is posted
+	PostAt time.Time
+}
+
+// trackerRecord is a persisted tracker
+type trackerRecord struct {
+	ID             string `storm:"id"`
+	Plugin         string
+	Channel        string
+	Key            string
+	TrackedChannel string
+	TimeStamp      string
+	Expire         time.Time `storm:"index"`
+}
+
+// scheduledRecord is a persisted scheduled message
+type scheduledRecord struct {
+	ID               string `storm:"id"`
+	Plugin           string
+	Channel          string
+	Key              string
+	ScheduledChannel string
+	MessageID        string
+	PostAt           time.Time `storm:"index"`
 }
 
-// Init the TrackerManager and the garbageCollector
-func (t *TrackerManager) Init() {
-	t.Trackers = map[int]*Tracker{}
+// Init the TrackerManager and the garbageCollector.
+// Trackers are persisted in dbPath if set and the ones not expired are loaded.
+func (t *TrackerManager) Init(dbPath string) (err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.trackers = map[TrackerKey]*Tracker{}
+	t.scheduled = map[TrackerKey]*ScheduledMessage{}
+
+	if dbPath != "" {
+		t.db, err = storm.Open(dbPath)
+		if err != nil {
+			return err
+		}
+		records := []trackerRecord{}
+		if err := t.db.Select(q.Gt("Expire", time.Now())).Find(&records); err != nil && err != storm.ErrNotFound {
+			return err
+		}
+		for _, r := range records {
+			key := TrackerKey{Plugin: r.Plugin, Channel: r.Channel, Key: r.Key}
+			t.trackers[key] = &Tracker{TrackerKey: key, TrackedChannel: r.TrackedChannel, TimeStamp: r.TimeStamp, Expire: r.Expire}
+		}
+		scheduled := []scheduledRecord{}
+		if err := t.db.Select(q.Gt("PostAt", time.Now())).Find(&scheduled); err != nil && err != storm.ErrNotFound {
+			return err
+		}
+		for _, r := range scheduled {
+			key := TrackerKey{Plugin: r.Plugin, Channel: r.Channel, Key: r.Key}
+			t.scheduled[key] = &ScheduledMessage{TrackerKey: key, ScheduledChannel: r.ScheduledChannel, ID: r.MessageID, PostAt: r.PostAt}
+		}
+		zap.L().Info("Loaded persisted trackers", zap.Int("trackers", len(records)), zap.Int("scheduled", len(scheduled)))
+	}
+
 	ticker := time.NewTicker(1 * time.Minute)
 	go func() {
 		for range ticker.C {
 			t.garbageCollector()
 		}
 	}()
+
+	return nil
 }
 
-// GetTimeStampFor will return the timestamp of the tracked conversation
-func (t *TrackerManager) GetTimeStampFor(id int) string {
-	if v, found := t.Trackers[id]; found {
+// Get return the tracker of a key if any
+func (t *TrackerManager) Get(key TrackerKey) (Tracker, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if v, found := t.trackers[key]; found && time.Now().Before(v.Expire) {
+		return *v, true
+	}
+	return Tracker{}, false
+}
+
+// GetTimeStampFor will return the timestamp of the tracked message
+func (t *TrackerManager) GetTimeStampFor(plugin, channel, key string) string {
+	if v, found := t.Get(TrackerKey{Plugin: plugin, Channel: channel, Key: key}); found {
 		return v.TimeStamp
 	}
 	return ""
 }
 
-// Track add a new tracker to the TrackerManager
-func (t *TrackerManager) Track(tracker Tracker) {
+// Track a message for a key, the DefaultTrackedTTL is used if ttl is not set
+func (t *TrackerManager) Track(key TrackerKey, channel string, timestamp string, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultTrackedTTL
+	}
+	if channel == "" {
+		channel = key.Channel
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.trackers == nil {
+		t.trackers = map[TrackerKey]*Tracker{}
+	}
+	tracker := &Tracker{
+		TrackerKey:     key,
+		TrackedChannel: channel,
+		TimeStamp:      timestamp,
+		Expire:         time.Now().Add(ttl),
+	}
+	t.trackers[key] = tracker
+
+	if t.db != nil {
+		err := t.db.Save(&trackerRecord{
+			ID:             recordID(key),
+			Plugin:         key.Plugin,
+			Channel:        key.Channel,
+			Key:            key.Key,
+			TrackedChannel: tracker.TrackedChannel,
+			TimeStamp:      tracker.TimeStamp,
+			Expire:         tracker.Expire,
+		})
+		if err != nil {
+			zap.L().Error("Failed to persist tracker", zap.String("plugin", key.Plugin), zap.String("key", key.Key), zap.Error(err))
+		}
+	}
+}
+
+// Untrack remove a tracker from the TrackerManager
+func (t *TrackerManager) Untrack(key TrackerKey) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
-	t.Trackers[tracker.TrackerID] = &tracker
+	delete(t.trackers, key)
+	if t.db != nil {
+		if err := t.db.DeleteStruct(&trackerRecord{ID: recordID(key)}); err != nil && err != storm.ErrNotFound {
+			zap.L().Error("Failed to remove persisted tracker", zap.String("plugin", key.Plugin), zap.String("key", key.Key), zap.Error(err))
+		}
+	}
 }
 
-// garbageCollector clean Tracker that exceed their TTL
-func (t *TrackerManager) garbageCollector() {
-	// Lock access to the array as we are doing some cleaning
+// GetScheduled return the message scheduled with a key if not posted yet
+func (t *TrackerManager) GetScheduled(key TrackerKey) (ScheduledMessage, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if v, found := t.scheduled[key]; found && time.Now().Before(v.PostAt) {
+		return *v, true
+	}
+	return ScheduledMessage{}, false
+}
+
+// Schedule keep the id of a message scheduled with a key until it is posted
+func (t *TrackerManager) Schedule(key TrackerKey, channel string, id string, postAt time.Time) {
+	if channel == "" {
+		channel = key.Channel
+	}
 	t.lock.Lock()
 	defer t.lock.Unlock()
+	if t.scheduled == nil {
+		t.scheduled = map[TrackerKey]*ScheduledMessage{}
+	}
+	t.scheduled[key] = &ScheduledMessage{TrackerKey: key, ScheduledChannel: channel, ID: id, PostAt: postAt}
 
-	var keys []int
-	for _, t := range t.Trackers {
-		ts, err := msToTime(t.TimeStamp)
+	if t.db != nil {
+		err := t.db.Save(&scheduledRecord{
+			ID:               recordID(key),
+			Plugin:           key.Plugin,
+			Channel:          key.Channel,
+			Key:              key.Key,
+			ScheduledChannel: channel,
+			MessageID:        id,
+			PostAt:           postAt,
+		})
 		if err != nil {
-			keys = append(keys, t.TrackerID)
-			continue
+			zap.L().Error("Failed to persist scheduled message", zap.String("plugin", key.Plugin), zap.String("key", key.Key), zap.Error(err))
 		}
-		durration := time.Since(ts)
-		if int(durration.Minutes()) > t.TTL {
-			keys = append(keys, t.TrackerID)
-		}
-	}
-	// Remove items
-	for _, i := range keys {
-		delete(t.Trackers, i)
 	}
 }
 
-// UpdateTracking will try to match a Pending Tracker with an event msg id
-func (t *TrackerManager) UpdateTracking(ack *slack.AckMessage) {
+// Unschedule forget the message scheduled with a key
+func (t *TrackerManager) Unschedule(key TrackerKey) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
-	if tracker, found := t.Trackers[ack.ReplyTo]; found {
-		tracker.TimeStamp = ack.Timestamp
+	delete(t.scheduled, key)
+	if t.db != nil {
+		if err := t.db.DeleteStruct(&scheduledRecord{ID: recordID(key)}); err != nil && err != storm.ErrNotFound {
+			zap.L().Error("Failed to remove persisted scheduled message", zap.String("plugin", key.Plugin), zap.String("key", key.Key), zap.Error(err))
+		}
 	}
 }
 
-// Convert ns UNIX epoch to time.Unix
-func msToTime(ms string) (time.Time, error) {
-	msInt, err := strconv.ParseInt(ms, 10, 64)
-	if err != nil {
-		return time.Time{}, err
+// garbageCollector clean Tracker that exceed their TTL and posted scheduled messages
+func (t *TrackerManager) garbageCollector() {
+	// Lock access to the map as we are doing some cleaning
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	for k, v := range t.trackers {
+		if now.After(v.Expire) {
+			delete(t.trackers, k)
+		}
 	}
+	for k, v := range t.scheduled {
+		if now.After(v.PostAt) {
+			delete(t.scheduled, k)
+		}
+	}
+
+	if t.db != nil {
+		if err := t.db.Select(q.Lte("Expire", now)).Delete(&trackerRecord{}); err != nil && err != storm.ErrNotFound {
+			zap.L().Error("Failed to remove expired trackers", zap.Error(err))
+		}
+		if err := t.db.Select(q.Lte("PostAt", now)).Delete(&scheduledRecord{}); err != nil && err != storm.ErrNotFound {
+			zap.L().Error("Failed to remove posted scheduled messages", zap.Error(err))
+		}
+	}
+}
 
-	return time.Unix(0, msInt*int64(time.Millisecond)), nil
+// recordID return the id of a persisted tracker
+func recordID(key TrackerKey) string {
+	return strings.Join([]string{key.Plugin, key.Channel, key.Key}, "/")
 }
@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrackerPersistsTrackedMessages(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "tracker.db")
+
+	// The same key in other plugins and channels
+	status := TrackerKey{Plugin: "deploy", Channel: "C1", Key: "status"}
+	otherPlugin := TrackerKey{Plugin: "build", Channel: "C1", Key: "status"}
+	otherChannel := TrackerKey{Plugin: "deploy", Channel: "C2", Key: "status"}
+	expired := TrackerKey{Plugin: "deploy", Channel: "C1", Key: "expired"}
+	untracked := TrackerKey{Plugin: "deploy", Channel: "C1", Key: "untracked"}
+	defaulted := TrackerKey{Plugin: "deploy", Channel: "C1", Key: "defaulted"}
+
+	tracker := &TrackerManager{}
+	if err := tracker.Init(db); err != nil {
+		t.Fatal(err)
+	}
+	tracker.Track(status, "", "1.1", time.Hour)
+	tracker.Track(otherPlugin, "", "2.2", time.Hour)
+	tracker.Track(otherChannel, "D2", "3.3", time.Hour)
+	tracker.Track(expired, "", "4.4", time.Nanosecond)
+	tracker.Track(untracked, "", "5.5", time.Hour)
+	tracker.Untrack(untracked)
+	tracker.Track(defaulted, "", "6.6", 0)
+	time.Sleep(time.Millisecond)
+
+	if _, found := tracker.Get(expired); found {
+		t.Error("expired tracker found before the restart")
+	}
+	tracker.db.Close()
+
+	// As after a restart
+	tracker = &TrackerManager{}
+	if err := tracker.Init(db); err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.db.Close()
+
+	tests := []struct {
+		key       TrackerKey
+		found     bool
+		channel   string
+		timestamp string
+	}{
+		{status, true, "C1", "1.1"},
+		{otherPlugin, true, "C1", "2.2"},
+		{otherChannel, true, "D2", "3.3"},
+		{expired, false, "", ""},
+		{untracked, false, "", ""},
+		{defaulted, true, "C1", "6.6"},
+	}
+
+	for _, test := range tests {
+		got, found := tracker.Get(test.key)
+		if found != test.found || got.TrackedChannel != test.channel || got.TimeStamp != test.timestamp {
+			t.Errorf("%s/%s/%s: got %+v, %v", test.key.Plugin, test.key.Channel, test.key.Key, got, found)
+		}
+		if ts := tracker.GetTimeStampFor(test.key.Plugin, test.key.Channel, test.key.Key); ts != test.timestamp {
+			t.Errorf("%s/%s/%s: got timestamp %q", test.key.Plugin, test.key.Channel, test.key.Key, ts)
+		}
+	}
+
+	if got, _ := tracker.Get(defaulted); got.Expire.Before(time.Now().Add(DefaultTrackedTTL - time.Minute)) {
+		t.Errorf("got expiration %s with the default TTL", got.Expire)
+	}
+}
+
+func TestTrackerGarbageCollector(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tracker := &TrackerManager{}
+	if err := tracker.Init(filepath.Join(dir, "tracker.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.db.Close()
+
+	live := TrackerKey{Plugin: "test", Channel: "C1", Key: "live"}
+	expired := TrackerKey{Plugin: "test", Channel: "C1", Key: "expired"}
+	tracker.Track(live, "", "1.1", time.Hour)
+	tracker.Track(expired, "", "2.2", time.Nanosecond)
+	tracker.Schedule(live, "", "Q1", time.Now().Add(time.Hour))
+	tracker.Schedule(expired, "", "Q2", time.Now().Add(-time.Minute))
+	time.Sleep(time.Millisecond)
+
+	tracker.garbageCollector()
+
+	if len(tracker.trackers) != 1 || tracker.trackers[live] == nil {
+		t.Errorf("got trackers %v", tracker.trackers)
+	}
+	if len(tracker.scheduled) != 1 || tracker.scheduled[live] == nil {
+		t.Errorf("got scheduled messages %v", tracker.scheduled)
+	}
+	records := []trackerRecord{}
+	if err := tracker.db.All(&records); err != nil || len(records) != 1 || records[0].Key != "live" {
+		t.Errorf("got persisted trackers %+v, %v", records, err)
+	}
+	scheduled := []scheduledRecord{}
+	if err := tracker.db.All(&scheduled); err != nil || len(scheduled) != 1 || scheduled[0].Key != "live" {
+		t.Errorf("got persisted scheduled messages %+v, %v", scheduled, err)
+	}
+}
+
+func TestTrackerPersistsScheduledMessages(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "tracker.db")
+
+	pending := TrackerKey{Plugin: "test", Channel: "C1", Key: "pending"}
+	posted := TrackerKey{Plugin: "test", Channel: "C1", Key: "posted"}
+	cancelled := TrackerKey{Plugin: "test", Channel: "C1", Key: "cancelled"}
+
+	tracker := &TrackerManager{}
+	if err := tracker.Init(db); err != nil {
+		t.Fatal(err)
+	}
+	postAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	tracker.Schedule(pending, "", "Q1", postAt)
+	tracker.Schedule(posted, "C1", "Q2", time.Now().Add(-time.Minute))
+	tracker.Schedule(cancelled, "C1", "Q3", postAt)
+	tracker.Unschedule(cancelled)
+	tracker.db.Close()
+
+	// As after a restart
+	tracker = &TrackerManager{}
+	if err := tracker.Init(db); err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.db.Close()
+
+	tests := []struct {
+		key   TrackerKey
+		found bool
+	}{
+		{pending, true},
+		{posted, false},
+		{cancelled, false},
+	}
+
+	for _, test := range tests {
+		_, found := tracker.GetScheduled(test.key)
+		if found != test.found {
+			t.Errorf("%s: found %v, want %v", test.key.Key, found, test.found)
+		}
+	}
+
+	if s, _ := tracker.GetScheduled(pending); s.ID != "Q1" || s.ScheduledChannel != "C1" || !s.PostAt.Equal(postAt) {
+		t.Errorf("got %+v", s)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/CyrilPeponnet/slackhal/plugin/blocks"
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+)
+
+// pluginsPermission is the permission needed to manage the plugins
+const pluginsPermission = "plugins"
+
+// PluginsHandleChat handle the plugins administration commands sent in direct
+// messages and return the options of the response if any
+func PluginsHandleChat(msg *slack.MessageEvent) []slack.MsgOption {
+
+	args := strings.Fields(msg.Msg.Text)
+	if len(args) == 0 {
+		return nil
+	}
+
+	command := strings.ToLower(args[0])
+	switch command {
+	case "plugin-status", "plugin-enable", "plugin-disable", "plugin-reload", "plugin-log-level":
+	default:
+		return nil
+	}
+
+	if !authz.IsGranted(pluginsPermission, msg.User, msg.Channel, "") {
+		user, _ := bot.GetCachedUserInfos(msg.User)
+		return []slack.MsgOption{slack.MsgOptionText(bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", user.RealName), false)}
+	}
+
+	if command == "plugin-status" {
+		return pluginStatus(msg).Options()
+	}
+
+	if len(args) < 2 {
+		return []slack.MsgOption{slack.MsgOptionText(bot.T(msg.User, "Please provide a plugin name."), false)}
+	}
+	name := args[1]
+	pluginName := name
+	// The level of the passive triggers logger can be set on its own
+	if command == "plugin-log-level" {
+		pluginName = strings.TrimSuffix(name, plugin.PassiveSuffix)
+	}
+	if _, ok := plugin.PluginManager.Plugin(pluginName); !ok {
+		return []slack.MsgOption{slack.MsgOptionText(bot.T(msg.User, "There is no plugin named `%s`.", name), false)}
+	}
+
+	var text string
+	switch command {
+	case "plugin-enable":
+		if err := enablePlugin(name); err != nil {
+			text = bot.T(msg.User, "Failed to enable plugin `%s`: %v", name, err)
+			break
+		}
+		zap.L().Info("Plugin enabled", zap.String("plugin", name), zap.String("by", msg.User))
+		text = bot.T(msg.User, "Plugin `%s` has been enabled.", name)
+
+	case "plugin-disable":
+		// The dependents would not be loaded on the next start
+		if dependents := plugin.PluginManager.Dependents(name); len(dependents) > 0 {
+			text = bot.T(msg.User, "Plugin `%s` provides services required by %s, disable them first.", name, strings.Join(dependents, ", "))
+			break
+		}
+		if err := plugin.PluginManager.SetDisabled(name, true); err != nil {
+			text = bot.T(msg.User, "Failed to disable plugin `%s`: %v", name, err)
+			break
+		}
+		zap.L().Info("Plugin disabled", zap.String("plugin", name), zap.String("by", msg.User))
+		text = bot.T(msg.User, "Plugin `%s` has been disabled.", name)
+
+	case "plugin-reload":
+		if err := plugin.PluginManager.Reload(name); err != nil {
+			text = bot.T(msg.User, "Failed to reload plugin `%s`: %v", name, err)
+			break
+		}
+		zap.L().Info("Plugin reloaded", zap.String("plugin", name), zap.String("by", msg.User))
+		text = bot.T(msg.User, "Plugin `%s` has been reloaded.", name)
+
+	case "plugin-log-level":
+		if len(args) < 3 {
+			text = bot.T(msg.User, "Logger `%s` logs at level `%s`.", name, bot.Loggers.Level(name))
+			break
+		}
+		if err := bot.Loggers.SetLevel(name, args[2]); err != nil {
+			text = bot.T(msg.User, "Failed to set the level of logger `%s`: %v", name, err)
+			break
+		}
+		zap.L().Info("Plugin log level changed", zap.String("logger", name), zap.String("level", args[2]), zap.String("by", msg.User))
+		text = bot.T(msg.User, "Logger `%s` now logs at level `%s`.", name, bot.Loggers.Level(name))
+	}
+
+	return []slack.MsgOption{slack.MsgOptionText(text, false)}
+}
+
+// pluginStatus list the plugins with their state
+func pluginStatus(msg *slack.MessageEvent) *blocks.Message {
+
+	plugins := plugin.PluginManager.All()
+	names := []string{}
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rows := [][]string{}
+	for _, name := range names {
+		meta := plugins[name].GetMetadata()
+		state := "enabled"
+		switch {
+		case plugin.PluginManager.Disabled(name) && !plugin.PluginManager.Initialized(name):
+			state = "not loaded"
+		case plugin.PluginManager.Disabled(name):
+			state = "disabled"
+		}
+		rows = append(rows, []string{name, meta.Version, state, fmt.Sprint(plugin.PluginManager.Failures(name))})
+	}
+
+	return blocks.New().
+		Section(bot.T(msg.User, "*Plugins*")).
+		Table([]string{"NAME", "VERSION", "STATE", "FAILURES"}, rows).
+		Context(bot.T(msg.User, "Use `plugin-enable <name>`, `plugin-disable <name>`, `plugin-reload <name>` or `plugin-log-level <name> <level>` to manage them."))
+}
@@ -1,48 +1,263 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/spf13/viper"
 )
 
+func initScheduler(prefix string, output chan *plugin.SlackResponse, bot *plugin.Bot) {
+
+	bot.Scheduler.CatchUp = plugin.CatchUpPolicy(viper.GetString("bot.scheduler.catchUp"))
+
+	if tz := viper.GetString("bot.scheduler.timezone"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			zap.L().Fatal("Invalid scheduler timezone", zap.String("timezone", tz), zap.Error(err))
+		}
+		bot.Scheduler.Location = loc
+	}
+
+	bot.Scheduler.Dispatch = func(channel, command string) {
+		DispatchCommand(prefix, channel, command, output)
+	}
+
+	if err := bot.Scheduler.Init(os.ExpandEnv(viper.GetString("bot.scheduler.database"))); err != nil {
+		zap.L().Fatal("Cannot initialize the scheduler", zap.Error(err))
+	}
+
+	// Jobs set by operators
+	jobs := []plugin.Job{}
+	if err := viper.UnmarshalKey("bot.scheduler.jobs", &jobs); err != nil {
+		zap.L().Error("Invalid scheduler jobs configuration", zap.Error(err))
+	}
+	for _, job := range jobs {
+		if err := bot.Scheduler.Add("config", job); err != nil {
+			zap.L().Error("Failed to schedule job", zap.String("job", job.Name), zap.Error(err))
+		}
+	}
+}
+
+// loader keep what is needed to load plugins once the bot is started
+var loader struct {
+	output   chan<- *plugin.SlackResponse
+	bot      *plugin.Bot
+	httpPort string
+	// HTTP routes already registered
+	routes map[string]bool
+	// Output channels of the plugins
+	outputs map[string]chan<- *plugin.SlackResponse
+	server  sync.Once
+	lock    sync.Mutex
+}
+
 func initPlugins(disabledPlugins []string, httpPort string, output chan<- *plugin.SlackResponse, bot *plugin.Bot) {
 
+	loader.output = output
+	loader.bot = bot
+	loader.httpPort = httpPort
+
 	// Loading our plugin and Init them
-	handlers := false
 	if len(disabledPlugins) != 0 {
 		zap.L().Info("Plugins disabled", zap.String("plugins", strings.Join(disabledPlugins, ", ")))
 	}
 	zap.L().Info("Loading plugins")
 
-Loading:
-	for _, p := range plugin.PluginManager.Plugins {
+	enabled := []plugin.Plugin{}
+	for _, p := range plugin.PluginManager.All() {
 		meta := p.GetMetadata()
-		for _, disabled := range disabledPlugins {
-			if meta.Name == disabled {
-				meta.Disabled = true
-				continue Loading
+		disabled := false
+		for _, name := range disabledPlugins {
+			if meta.Name == name {
+				disabled = true
 			}
 		}
-		zap.L().Info("Loading", zap.String("plugin", meta.Name), zap.String("version", meta.Version))
-		p.Init(output, bot)
-		// Register handlers if any
-		for route, handler := range meta.HTTPHandler {
-			handlers = true
-			zap.L().Info("Registering HTTP handler", zap.String("plugin", route.Name), zap.String("address", httpPort))
-			http.Handle(route.Name, handler)
+		// Plugins enabled or disabled at runtime keep their state
+		if d, found := plugin.PluginManager.SavedState(meta.Name); found {
+			disabled = d
+		}
+		plugin.PluginManager.MarkDisabled(meta.Name, disabled)
+		if disabled {
+			continue
+		}
+		enabled = append(enabled, p)
+	}
+
+	// Plugins providing services are initialized first
+	ordered, err := plugin.InitOrder(enabled)
+	if err != nil {
+		zap.L().Fatal("Cannot start the plugins", zap.Error(err))
+	}
+
+	for _, p := range ordered {
+		if err := loadPlugin(p); err != nil {
+			zap.L().Warn("Plugin not loaded", zap.String("plugin", p.GetMetadata().Name), zap.Error(err))
+		}
+	}
+}
+
+// loadPlugin call the Init of a plugin and register its jobs and its HTTP handlers
+func loadPlugin(p plugin.Plugin) error {
+
+	meta := p.GetMetadata()
+
+	if missing := plugin.PluginManager.Missing(p); len(missing) > 0 {
+		plugin.PluginManager.MarkDisabled(meta.Name, true)
+		err := fmt.Errorf("plugin %s requires unavailable services: %s", meta.Name, strings.Join(missing, ", "))
+		plugin.PluginManager.SetInitError(meta.Name, err)
+		return err
+	}
+	plugin.PluginManager.SetInitError(meta.Name, nil)
+
+	zap.L().Info("Loading", zap.String("plugin", meta.Name), zap.String("version", meta.Version))
+
+	// A plugin which disabled itself during its last init is given another chance
+	meta.Disabled = false
+	err := plugin.Protect(func() error {
+		p.Init(pluginOutput(meta.Name, loader.output), loader.bot)
+		return nil
+	})
+
+	if perr, ok := err.(*plugin.PanicError); ok {
+		zap.L().Error("Plugin panicked during init, disabling it", zap.String("plugin", meta.Name), zap.Reflect("panic", perr.Value), zap.ByteString("stack", perr.Stack))
+		plugin.PluginManager.MarkDisabled(meta.Name, true)
+		err = fmt.Errorf("plugin %s panicked during init: %v", meta.Name, perr.Value)
+		plugin.PluginManager.SetInitError(meta.Name, err)
+		return err
+	}
+	if meta.Disabled {
+		plugin.PluginManager.MarkDisabled(meta.Name, true)
+		// Plugins can tell why
+		if err := plugin.PluginManager.InitError(meta.Name); err != nil {
+			return err
 		}
+		err = fmt.Errorf("plugin %s disabled itself during init", meta.Name)
+		plugin.PluginManager.SetInitError(meta.Name, err)
+		return err
 	}
+	plugin.PluginManager.SetInitialized(meta.Name)
 
-	// Start the http handler if we have some handlers registered.
-	if handlers {
+	// Register jobs if any
+	for _, job := range meta.Jobs {
+		if err := loader.bot.Scheduler.Add(meta.Name, job); err != nil {
+			zap.L().Error("Failed to schedule job", zap.String("plugin", meta.Name), zap.String("job", job.Name), zap.Error(err))
+		}
+	}
+
+	// Register handlers if any
+	if len(meta.HTTPHandler) == 0 {
+		return nil
+	}
+
+	loader.lock.Lock()
+	defer loader.lock.Unlock()
+	if loader.routes == nil {
+		loader.routes = map[string]bool{}
+	}
+	for route := range meta.HTTPHandler {
+		if loader.routes[route.Name] {
+			continue
+		}
+		zap.L().Info("Registering HTTP handler", zap.String("plugin", route.Name), zap.String("address", loader.httpPort))
+		http.Handle(route.Name, recoverHandler(meta.Name, route.Name))
+		loader.routes[route.Name] = true
+	}
+
+	// Start the http handler once we have some handlers registered.
+	loader.server.Do(func() {
 		go func() {
-			if err := http.ListenAndServe(httpPort, nil); err != nil {
-				zap.L().Fatal("Failed to register HTTP handler", zap.String("address", httpPort), zap.Error(err))
+			if err := http.ListenAndServe(loader.httpPort, nil); err != nil {
+				zap.L().Fatal("Failed to register HTTP handler", zap.String("address", loader.httpPort), zap.Error(err))
 			}
 		}()
+	})
+
+	return nil
+}
+
+// enablePlugin enable a plugin, its Init is called if it was disabled at startup or if its last init failed.
+// The plugin stays disabled if it cannot be loaded.
+func enablePlugin(name string) error {
+	p, ok := plugin.PluginManager.Plugin(name)
+	if !ok {
+		return fmt.Errorf("no such plugin %s", name)
+	}
+	if !plugin.PluginManager.Initialized(name) {
+		if err := loadPlugin(p); err != nil {
+			return err
+		}
 	}
+	return plugin.PluginManager.SetDisabled(name, false)
+}
+
+// pluginOutput return the output channel of a plugin, created once and reused when it is loaded again.
+// Responses are stamped with the plugin name to namespace their trackers.
+func pluginOutput(name string, output chan<- *plugin.SlackResponse) chan<- *plugin.SlackResponse {
+	loader.lock.Lock()
+	defer loader.lock.Unlock()
+
+	if out, ok := loader.outputs[name]; ok {
+		return out
+	}
+	if loader.outputs == nil {
+		loader.outputs = map[string]chan<- *plugin.SlackResponse{}
+	}
+
+	out := make(chan *plugin.SlackResponse)
+	loader.outputs[name] = out
+	go func() {
+		for r := range out {
+			r.Plugin = name
+			output <- r
+		}
+	}()
+	return out
+}
+
+// recoverHandler serve a route with the current HTTP handler of a plugin and recover from its panics
+func recoverHandler(name string, route string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		p, ok := plugin.PluginManager.Plugin(name)
+		if !ok || plugin.PluginManager.Disabled(name) {
+			http.Error(w, "plugin disabled", http.StatusServiceUnavailable)
+			return
+		}
+
+		var handler http.Handler
+		for c, h := range p.GetMetadata().HTTPHandler {
+			if c.Name == route {
+				handler = h
+			}
+		}
+		if handler == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		err := plugin.Protect(func() error {
+			handler.ServeHTTP(w, r)
+			return nil
+		})
+
+		if perr, ok := err.(*plugin.PanicError); ok {
+			zap.L().Error("Plugin panicked while handling HTTP request",
+				zap.String("plugin", name),
+				zap.String("path", r.URL.Path),
+				zap.Reflect("panic", perr.Value),
+				zap.ByteString("stack", perr.Stack))
+			if plugin.PluginManager.Failed(name) {
+				zap.L().Warn("Plugin disabled after too many failures", zap.String("plugin", name), zap.Int("failures", plugin.PluginManager.Failures(name)))
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	})
 }
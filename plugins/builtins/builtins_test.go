@@ -0,0 +1,115 @@
+package builtins
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/CyrilPeponnet/slackhal/plugin/plugintest"
+	"github.com/slack-go/slack"
+)
+
+// catalog is the french catalog of the tests
+const catalog = `{
+  "Here is my plugin list:": "Voici la liste de mes plugins :",
+  "There is no scheduled job.": "Il n'y a aucune tâche planifiée.",
+  "never": "jamais"
+}`
+
+func TestBuiltins(t *testing.T) {
+
+	b := plugintest.NewBot(t)
+	b.AddUser(slack.User{ID: "U1", Name: "dave"})
+	b.AddUser(slack.User{ID: "U2", Name: "frank"})
+	b.AddChannel(slack.Channel{GroupConversation: slack.GroupConversation{Name: "team", Conversation: slack.Conversation{ID: "C1"}}}, "U1", "U2")
+	b.LoadV2(newEcho(), newLanguage())
+	b.Load(newHelp(), newSchedules())
+
+	if err := ioutil.WriteFile(filepath.Join(b.DataDir, "fr.json"), []byte(catalog), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Translator.Load(b.DataDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every step runs after the previous ones, U2 answers in french once the language is set
+	tests := []struct {
+		name    string
+		message *slack.MessageEvent
+		// Expected reply, none if empty
+		want string
+		// Expected delivery mode of the reply
+		mode plugin.DeliveryMode
+	}{
+		{"echo", plugintest.ChannelMessage("C1", "U1", "!echo Hello World"), "Hello World", plugin.ModePost},
+		{"echo nothing", plugintest.ChannelMessage("C1", "U1", "!echo"), "", plugin.ModePost},
+		{"help", plugintest.ChannelMessage("C1", "U1", "!help"), "> *list-plugins* - List all enabled plugins and the failing ones.", plugin.ModeEphemeral},
+		{"help in direct message", plugintest.DirectMessage("U1", "help"), "> *list-commands* - List all available commands.", plugin.ModePost},
+		{"help of a command", plugintest.ChannelMessage("C1", "U1", "!help echo echo"), "Will repeat what you put after.", plugin.ModeEphemeral},
+		{"help of an unknown plugin", plugintest.ChannelMessage("C1", "U1", "!help nothing"), "Sorry but I cannot find help for `help nothing`", plugin.ModeEphemeral},
+		{"list commands", plugintest.ChannelMessage("C1", "U1", "!list-commands"), ">_set-language_  - Choose your language", plugin.ModeEphemeral},
+		{"list plugins", plugintest.ChannelMessage("C1", "U1", "!list-plugins"), "Here is my plugin list:", plugin.ModeEphemeral},
+		{"list schedules", plugintest.ChannelMessage("C1", "U1", "!list-schedules"), "There is no scheduled job.", plugin.ModePost},
+		{"language", plugintest.ChannelMessage("C1", "U2", "!set-language"), "Your language is `en`", plugin.ModePost},
+		{"set language", plugintest.ChannelMessage("C1", "U2", "!set-language fr_FR"), "Ok, I will now answer you in `fr-fr`.", plugin.ModePost},
+		{"translated", plugintest.ChannelMessage("C1", "U2", "!list-plugins"), "Voici la liste de mes plugins :", plugin.ModeEphemeral},
+		{"translated schedules", plugintest.ChannelMessage("C1", "U2", "!list-schedules"), "Il n'y a aucune tâche planifiée.", plugin.ModePost},
+		{"not translated for others", plugintest.ChannelMessage("C1", "U1", "!list-plugins"), "Here is my plugin list:", plugin.ModeEphemeral},
+		{"auto language", plugintest.ChannelMessage("C1", "U2", "!set-language auto"), "Ok, I will follow your slack locale.", plugin.ModePost},
+		{"back to english", plugintest.ChannelMessage("C1", "U2", "!list-plugins"), "Here is my plugin list:", plugin.ModeEphemeral},
+	}
+
+	for _, test := range tests {
+		responses := b.Dispatch(test.message)
+		if test.want == "" {
+			responses.AssertEmpty(t)
+			continue
+		}
+		responses.AssertCount(t, 1)
+		if len(responses) == 0 {
+			continue
+		}
+		r := responses[0]
+		if r.Text != test.want && len(r.Blocks) == 0 {
+			responses.AssertText(t, test.want)
+		} else if len(r.Blocks) > 0 {
+			responses.AssertBlockText(t, test.want)
+		}
+		if r.Mode != test.mode || (r.Mode == plugin.ModeEphemeral && r.User != test.message.User) {
+			t.Errorf("%s: got mode %d for %s, want %d", test.name, r.Mode, r.User, test.mode)
+		}
+	}
+}
+
+func TestListSchedules(t *testing.T) {
+
+	b := plugintest.NewBot(t)
+	b.AddUser(slack.User{ID: "U1", Name: "dave", Locale: "fr-FR"})
+	b.Load(newSchedules())
+
+	if err := ioutil.WriteFile(filepath.Join(b.DataDir, "fr.json"), []byte(catalog), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Translator.Load(b.DataDir); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Scheduler.Location = time.UTC
+	if err := b.Scheduler.Init(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Scheduler.Add("test", plugin.Job{Name: "report", Schedule: "0 9 * * *", Channel: "C1", Command: "!report"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Scheduler.Add("test", plugin.Job{Name: "cleanup", Schedule: "@every 1h", Run: func(time.Time) {}}); err != nil {
+		t.Fatal(err)
+	}
+
+	responses := b.Dispatch(plugintest.ChannelMessage("C1", "U1", "!list-schedules"))
+	responses.AssertCount(t, 1)
+	for _, want := range []string{"test/report", "0 9 * * *", "!report in C1", "test/cleanup", "function", "jamais"} {
+		responses.AssertBlockText(t, want)
+	}
+}
@@ -1,20 +1,24 @@
 package builtins
 
 import (
-	"fmt"
 	"net/http"
 
 	"go.uber.org/zap"
 
 	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/CyrilPeponnet/slackhal/plugin/blocks"
 	"github.com/slack-go/slack"
 )
 
+// catAPI redirects to a random cat picture
+var catAPI = "http://thecatapi.com/api/images/get?format=src&type=gif"
+
 // cat struct define your plugin
 type cat struct {
 	plugin.Metadata
 	Logger *zap.Logger
 	sink   chan<- *plugin.SlackResponse
+	bot    *plugin.Bot
 }
 
 // Init interface implementation if you need to init things
@@ -22,6 +26,7 @@ type cat struct {
 func (h *cat) Init(output chan<- *plugin.SlackResponse, bot *plugin.Bot) {
 	// cats are initless
 	h.sink = output
+	h.bot = bot
 }
 
 // GetMetadata interface implementation
@@ -32,16 +37,15 @@ func (h *cat) GetMetadata() *plugin.Metadata {
 // ProcessMessage interface implementation
 func (h *cat) ProcessMessage(command string, message slack.Msg) bool {
 	// Cat summoned !
-	o := new(plugin.SlackResponse)
-	o.Channel = message.Channel
-	response, err := http.Get("http://thecatapi.com/api/images/get?format=src&type=gif")
+	m := blocks.New()
+	response, err := http.Get(catAPI)
 	if err != nil {
-		o.Options = append(o.Options, slack.MsgOptionText("I cannot find a single funny cat picture on Internet... Looks like the ends of the world...", false))
+		m.Section(h.bot.T(message.User, "I cannot find a single funny cat picture on Internet... Looks like the ends of the world..."))
 	} else {
-		o.Options = append(o.Options, slack.MsgOptionText(fmt.Sprintf("Hey <@%v> look what I just found for you: %v", message.User, response.Request.URL), false))
+		defer response.Body.Close() // nolint
+		m.Section(h.bot.T(message.User, "Hey <@%v> look what I just found for you: %v", message.User, response.Request.URL))
 	}
-	h.sink <- o
-	defer response.Body.Close() // nolint
+	h.sink <- m.Response(message.Channel)
 	return true
 }
 
@@ -50,11 +54,16 @@ func (h *cat) Self() (i interface{}) {
 	return h
 }
 
-// init function that will register your plugin to the plugin manager
-func init() {
+// newCat return a new cat plugin
+func newCat() *cat {
 	cater := new(cat)
 	cater.Metadata = plugin.NewMetadata("cat")
 	cater.Description = "Show cats"
 	cater.ActiveTriggers = []plugin.Command{{Name: `cat`, ShortDescription: "Show a cat.", LongDescription: "Because cats are fun."}}
-	plugin.PluginManager.Register(cater)
+	return cater
+}
+
+// init function that will register your plugin to the plugin manager
+func init() {
+	plugin.PluginManager.Register(newCat())
 }
@@ -1,22 +1,21 @@
 package builtins
 
 import (
+	"context"
 	"strings"
 
 	"github.com/CyrilPeponnet/slackhal/plugin"
-	"github.com/slack-go/slack"
+	"github.com/CyrilPeponnet/slackhal/plugin/blocks"
 )
 
 // echo struct define your plugin
 type echo struct {
 	plugin.Metadata
-	sink chan<- *plugin.SlackResponse
 }
 
 // Init interface implementation if you need to init things
 // When the bot is starting.
 func (h *echo) Init(output chan<- *plugin.SlackResponse, bot *plugin.Bot) {
-	h.sink = output
 }
 
 // GetMetadata interface implementation
@@ -24,39 +23,32 @@ func (h *echo) GetMetadata() *plugin.Metadata {
 	return &h.Metadata
 }
 
-// ProcessMessage interface implementation
-func (h *echo) ProcessMessage(command string, message slack.Msg) bool {
+// Handle interface implementation
+func (h *echo) Handle(ctx context.Context, req *plugin.Request) (*plugin.Result, error) {
 
-	if len(strings.Split(message.Text, " ")) == 1 {
-		return false
+	if len(req.Args) == 0 {
+		return &plugin.Result{}, nil
 	}
 
-	start := strings.Index(message.Text, command)
-	size := len(command)
-	if start+size+1 >= len(message.Text) {
-		return false
-	}
-	msg := message.Text[start+size+1 : len(message.Text)]
+	text := req.Message.Text
+	msg := strings.TrimSpace(text[strings.Index(strings.ToLower(text), req.Trigger)+len(req.Trigger):])
 
-	o := new(plugin.SlackResponse)
-	o.Options = append(o.Options, slack.MsgOptionText(msg, false))
-	o.Channel = message.Channel
+	o := blocks.New().Section(msg).Response(req.Channel.ID)
 	// This is a test to implement tracking of message
-	o.TrackerID = 42
-	h.sink <- o
-	return true
-}
-
-// Self interface implementation
-func (h *echo) Self() (i interface{}) {
-	return h
+	o.TrackerID = "echo"
+	return &plugin.Result{Handled: true, Responses: []*plugin.SlackResponse{o}}, nil
 }
 
-// init function that will register your plugin to the plugin manager
-func init() {
+// newEcho return a new echo plugin
+func newEcho() *echo {
 	echoer := new(echo)
 	echoer.Metadata = plugin.NewMetadata("echo")
 	echoer.Description = "Will repeat what you said"
 	echoer.ActiveTriggers = []plugin.Command{{Name: "echo", ShortDescription: "Parrot style", LongDescription: "Will repeat what you put after."}}
-	plugin.PluginManager.Register(echoer)
+	return echoer
+}
+
+// init function that will register your plugin to the plugin manager
+func init() {
+	plugin.PluginManager.RegisterV2(newEcho())
 }
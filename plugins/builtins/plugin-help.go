@@ -3,10 +3,13 @@ package builtins
 import (
 	"fmt"
 	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/spf13/viper"
 
 	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/CyrilPeponnet/slackhal/plugin/blocks"
 	"github.com/slack-go/slack"
 )
 
@@ -14,25 +17,32 @@ import (
 type help struct {
 	plugin.Metadata
 	sink chan<- *plugin.SlackResponse
+	bot  *plugin.Bot
 }
 
-// init function that will register your plugin to the plugin manager
-func init() {
+// newHelp return a new help plugin
+func newHelp() *help {
 	helper := new(help)
 	helper.Metadata = plugin.NewMetadata("help")
 	helper.Metadata.Description = "Helper plugin."
 	helper.ActiveTriggers = []plugin.Command{{Name: "help", ShortDescription: "Will provide some help :)"},
-		{Name: "list-plugins", ShortDescription: "List all enabled plugins."},
+		{Name: "list-plugins", ShortDescription: "List all enabled plugins and the failing ones."},
 		{Name: "list-commands", ShortDescription: "List all available commands."},
 		{Name: "list-handlers", ShortDescription: "List all available HTTP handlers."},
 		{Name: "list-triggers", ShortDescription: "List all passive triggers."}}
-	plugin.PluginManager.Register(helper)
+	return helper
+}
+
+// init function that will register your plugin to the plugin manager
+func init() {
+	plugin.PluginManager.Register(newHelp())
 }
 
 // Init interface implementation if you need to init things
 // When the bot is starting.
 func (h *help) Init(output chan<- *plugin.SlackResponse, bot *plugin.Bot) {
 	h.sink = output
+	h.bot = bot
 }
 
 // GetMetadata interface implementation
@@ -48,145 +58,159 @@ func (h *help) Self() (i interface{}) {
 // ProcessMessage interface implementation
 func (h *help) ProcessMessage(command string, message slack.Msg) bool {
 	helpPluginPattern := regexp.MustCompile(`(help)\s*(\S*)\s*(\S*)`)
-	o := new(plugin.SlackResponse)
+	var m *blocks.Message
 	switch {
 	case helpPluginPattern.MatchString(message.Text):
 		p := helpPluginPattern.FindStringSubmatch(message.Text)
-		o.Options = append(o.Options, slack.MsgOptionText(GetHelpForPlugin(p), false))
+		m = h.GetHelpForPlugin(message.User, p)
 	case command == "list-plugins":
-		o.Options = append(o.Options, slack.MsgOptionText(PluginList(), false))
+		m = h.PluginList(message.User)
 	case command == "list-commands":
-		o.Options = append(o.Options, slack.MsgOptionText(PluginListActions(), false))
+		m = h.PluginListActions(message.User)
 	case command == "list-handlers":
-		o.Options = append(o.Options, slack.MsgOptionText(PluginListHandlers(), false))
+		m = h.PluginListHandlers(message.User)
 	case command == "list-triggers":
-		o.Options = append(o.Options, slack.MsgOptionText(PluginListTriggers(), false))
+		m = h.PluginListTriggers(message.User)
+	default:
+		return false
 	}
-	o.Channel = message.Channel
-	h.sink <- o
+	// Help is only shown to the user, unless in a direct message
+	r := m.Response(message.Channel)
+	if !strings.HasPrefix(message.Channel, "D") && message.User != "" && message.User != h.bot.ID && message.BotID == "" {
+		r.Mode = plugin.ModeEphemeral
+		r.User = message.User
+	}
+	h.sink <- r
 	return true
 }
 
-// PluginListTriggers list plugins triggers
-func PluginListTriggers() (o string) {
-	l := ""
-	for _, p := range plugin.PluginManager.Plugins {
-		info := p.GetMetadata()
-		if info.Disabled {
-			continue
-		}
-		a := ""
-		for _, c := range info.PassiveTriggers {
-			a += fmt.Sprintf(">_%v_  - %v\n", c.Name, c.ShortDescription)
-		}
-		if a != "" {
-			l += fmt.Sprintf("\n*%v* (%v) - %v\n", info.Name, info.Version, info.Description)
-			l += a
+// enabledPlugins return the enabled plugins sorted by name
+func enabledPlugins() (plugins []*plugin.Metadata) {
+	for _, info := range allPlugins() {
+		if !plugin.PluginManager.Disabled(info.Name) {
+			plugins = append(plugins, info)
 		}
 	}
-	if l != "" {
-		o = "Here are all the passive triggers enabled:\n"
-		o += l
-	} else {
-		o = "Cannot find any passive triggers."
+	return
+}
+
+// allPlugins return the plugins sorted by name
+func allPlugins() (plugins []*plugin.Metadata) {
+	for _, p := range plugin.PluginManager.All() {
+		plugins = append(plugins, p.GetMetadata())
 	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
 	return
 }
 
-// PluginListHandlers list plugins handlers
-func PluginListHandlers() (o string) {
-	l := ""
-	for _, p := range plugin.PluginManager.Plugins {
-		info := p.GetMetadata()
-		if info.Disabled {
-			continue
+// pluginHeader return the header line of a plugin
+func pluginHeader(info *plugin.Metadata) string {
+	return fmt.Sprintf("*%v* (%v) - %v", info.Name, info.Version, info.Description)
+}
+
+// commandList return the list of commands as quoted lines
+func commandList(commands []plugin.Command) (l []string) {
+	for _, c := range commands {
+		l = append(l, fmt.Sprintf(">_%v_  - %v", c.Name, c.ShortDescription))
+	}
+	sort.Strings(l)
+	return
+}
+
+// PluginListTriggers list plugins triggers
+func (h *help) PluginListTriggers(user string) *blocks.Message {
+	m := blocks.New().Section(h.bot.T(user, "Here are all the passive triggers enabled:"))
+	found := false
+	for _, info := range enabledPlugins() {
+		if l := commandList(info.PassiveTriggers); len(l) > 0 {
+			found = true
+			m.Section(pluginHeader(info) + "\n" + strings.Join(l, "\n"))
 		}
-		a := ""
+	}
+	if !found {
+		return blocks.New().Section(h.bot.T(user, "Cannot find any passive triggers."))
+	}
+	return m
+}
+
+// PluginListHandlers list plugins handlers
+func (h *help) PluginListHandlers(user string) *blocks.Message {
+	m := blocks.New().Section(h.bot.T(user, "Here are all the HTTP Handlers enabled:"))
+	found := false
+	for _, info := range enabledPlugins() {
+		handlers := []plugin.Command{}
 		for c := range info.HTTPHandler {
-			a += fmt.Sprintf(">_%v_  - %v\n", c.Name, c.ShortDescription)
+			handlers = append(handlers, c)
 		}
-		if a != "" {
-			l += fmt.Sprintf("\n*%v* (%v) - %v\n", info.Name, info.Version, info.Description)
-			l += a
+		if l := commandList(handlers); len(l) > 0 {
+			found = true
+			m.Section(pluginHeader(info) + "\n" + strings.Join(l, "\n"))
 		}
 	}
-	if l != "" {
-		o = "Here are all the HTTP Handlers enabled:\n"
-		o += l
-	} else {
-		o = "Cannot find any HTTP handlers."
+	if !found {
+		return blocks.New().Section(h.bot.T(user, "Cannot find any HTTP handlers."))
 	}
-	return
+	return m
 }
 
 // PluginListActions list plugins actions
-func PluginListActions() (o string) {
-	l := ""
-	for _, p := range plugin.PluginManager.Plugins {
-		info := p.GetMetadata()
-		if info.Disabled {
-			continue
-		}
-		a := ""
-		for _, c := range info.ActiveTriggers {
-			a += fmt.Sprintf(">_%v_  - %v\n", c.Name, c.ShortDescription)
-		}
-		if a != "" {
-			l += fmt.Sprintf("\n*%v* (%v) - %v\n", info.Name, info.Version, info.Description)
-			l += a
+func (h *help) PluginListActions(user string) *blocks.Message {
+	m := blocks.New().Section(h.bot.T(user, "Here are all the commands availables:")).
+		Context(h.bot.T(user, "Can be invoqued either through direct message, mention, or with the trigger prefix `%s`", viper.GetString("bot.trigger")))
+	found := false
+	for _, info := range enabledPlugins() {
+		if l := commandList(info.ActiveTriggers); len(l) > 0 {
+			found = true
+			m.Section(pluginHeader(info) + "\n" + strings.Join(l, "\n"))
 		}
 	}
-	if l != "" {
-		o = fmt.Sprintf("Here are all the commands availables:\n (Can be invoqued either through direct message, mention, or with the trigger prefix %s", viper.GetString("bot.trigger"))
-		o += l
-	} else {
-		o = "Cannot find any plugin actions."
+	if !found {
+		return blocks.New().Section(h.bot.T(user, "Cannot find any plugin actions."))
 	}
-	return
+	return m
 }
 
 // PluginList list plugins
-func PluginList() (o string) {
-	o = "Here is my plugin list:\n"
-	for _, p := range plugin.PluginManager.Plugins {
-		info := p.GetMetadata()
-		if info.Disabled {
+func (h *help) PluginList(user string) *blocks.Message {
+	l := []string{}
+	for _, info := range allPlugins() {
+		health := plugin.PluginManager.Health(info.Name)
+		if health.Status == plugin.HealthDisabled {
 			continue
 		}
-		o += fmt.Sprintf(">*%v* (%v) - %v\n", info.Name, info.Version, info.Description)
+		line := ">" + pluginHeader(info)
+		// Plugins with problems are flagged, including the ones disabled by a failure
+		if health.Status != plugin.HealthOK {
+			line += fmt.Sprintf(" %s _%s_", healthIcon(health.Status), h.bot.T(user, string(health.Status)))
+			if health.Details != "" {
+				line += fmt.Sprintf(": %s", health.Details)
+			}
+		}
+		l = append(l, line)
 	}
-	return
+	return blocks.New().Section(h.bot.T(user, "Here is my plugin list:")).Section(strings.Join(l, "\n"))
 }
 
 // GetHelpForPlugin get help for a give plugin and commands
-func GetHelpForPlugin(matches []string) (o string) {
-	if matches[3] != "" || matches[2] != "" {
-	loop:
-		for _, p := range plugin.PluginManager.Plugins {
-			info := p.GetMetadata()
-			if info.Disabled {
-				continue
-			}
-			if info.Name == matches[2] {
-				o = fmt.Sprintf("*%v* (%v) - %v\n", info.Name, info.Version, info.Description)
-				for _, c := range info.ActiveTriggers {
-					if c.Name == matches[3] {
-						o += fmt.Sprintf("> *%v*:\n```%v```\n", c.Name, c.LongDescription)
-						break loop
-					} else {
-
-						o += fmt.Sprintf("> *%v* - %v\n", c.Name, c.ShortDescription)
-					}
-				}
+func (h *help) GetHelpForPlugin(user string, matches []string) *blocks.Message {
+	if matches[3] == "" && matches[2] == "" {
+		return h.GetHelpForPlugin(user, []string{"", "help", "help", ""})
+	}
+
+	for _, info := range enabledPlugins() {
+		if info.Name != matches[2] {
+			continue
+		}
+		m := blocks.New().Section(pluginHeader(info))
+		l := []string{}
+		for _, c := range info.ActiveTriggers {
+			if c.Name == matches[3] {
+				return m.Section(fmt.Sprintf("> *%v*:", c.Name)).Code(c.LongDescription)
 			}
+			l = append(l, fmt.Sprintf("> *%v* - %v", c.Name, c.ShortDescription))
 		}
-
-	} else {
-		o = GetHelpForPlugin([]string{"", "help", "help", ""})
+		return m.Section(strings.Join(l, "\n"))
 	}
 
-	if o == "" {
-		o = fmt.Sprintf("Sorry but I cannot find help for `%v`", matches[0])
-	}
-	return o
+	return blocks.New().Section(h.bot.T(user, "Sorry but I cannot find help for `%v`", matches[0]))
 }
@@ -0,0 +1,70 @@
+package builtins
+
+import (
+	"context"
+	"strings"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/slack-go/slack"
+)
+
+// language struct define your plugin
+type language struct {
+	plugin.Metadata
+	bot *plugin.Bot
+}
+
+// Init interface implementation if you need to init things
+// When the bot is starting.
+func (h *language) Init(output chan<- *plugin.SlackResponse, bot *plugin.Bot) {
+	h.bot = bot
+}
+
+// GetMetadata interface implementation
+func (h *language) GetMetadata() *plugin.Metadata {
+	return &h.Metadata
+}
+
+// Handle interface implementation
+func (h *language) Handle(ctx context.Context, req *plugin.Request) (*plugin.Result, error) {
+
+	user := req.Message.User
+	languages := strings.Join(h.bot.Translator.Languages(), ", ")
+
+	var text string
+	switch {
+	case len(req.Args) == 0:
+		text = h.bot.T(user, "Your language is `%s`. Use `set-language <code>` to choose one of %s, or `set-language auto` to follow your slack locale.", h.bot.Language(user), languages)
+
+	case strings.ToLower(req.Args[0]) == "auto":
+		if err := h.bot.Translator.SetPreference(user, ""); err != nil {
+			return nil, err
+		}
+		text = h.bot.T(user, "Ok, I will follow your slack locale.")
+
+	default:
+		if err := h.bot.Translator.SetPreference(user, req.Args[0]); err != nil {
+			return nil, err
+		}
+		text = h.bot.T(user, "Ok, I will now answer you in `%s`.", h.bot.Language(user))
+	}
+
+	o := new(plugin.SlackResponse)
+	o.Channel = req.Channel.ID
+	o.Options = []slack.MsgOption{slack.MsgOptionText(text, false)}
+	return &plugin.Result{Handled: true, Responses: []*plugin.SlackResponse{o}}, nil
+}
+
+// newLanguage return a new language plugin
+func newLanguage() *language {
+	languager := new(language)
+	languager.Metadata = plugin.NewMetadata("language")
+	languager.Description = "Choose the language of the replies"
+	languager.ActiveTriggers = []plugin.Command{{Name: "set-language", ShortDescription: "Choose your language", LongDescription: "Use `set-language <code>` like `set-language fr`, or `set-language auto` to follow your slack locale."}}
+	return languager
+}
+
+// init function that will register your plugin to the plugin manager
+func init() {
+	plugin.PluginManager.RegisterV2(newLanguage())
+}
@@ -0,0 +1,79 @@
+package builtins
+
+import (
+	"time"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/CyrilPeponnet/slackhal/plugin/blocks"
+	"github.com/slack-go/slack"
+)
+
+// schedules struct define your plugin
+type schedules struct {
+	plugin.Metadata
+	sink chan<- *plugin.SlackResponse
+	bot  *plugin.Bot
+}
+
+// newSchedules return a new schedules plugin
+func newSchedules() *schedules {
+	scheduler := new(schedules)
+	scheduler.Metadata = plugin.NewMetadata("schedules")
+	scheduler.Description = "Scheduled jobs."
+	scheduler.ActiveTriggers = []plugin.Command{{Name: "list-schedules", ShortDescription: "List all scheduled jobs.", LongDescription: "Will list the scheduled jobs with their next and last runs."}}
+	return scheduler
+}
+
+// init function that will register your plugin to the plugin manager
+func init() {
+	plugin.PluginManager.Register(newSchedules())
+}
+
+// Init interface implementation if you need to init things
+// When the bot is starting.
+func (h *schedules) Init(output chan<- *plugin.SlackResponse, bot *plugin.Bot) {
+	h.sink = output
+	h.bot = bot
+}
+
+// GetMetadata interface implementation
+func (h *schedules) GetMetadata() *plugin.Metadata {
+	return &h.Metadata
+}
+
+// Self interface implementation
+func (h *schedules) Self() (i interface{}) {
+	return h
+}
+
+// ProcessMessage interface implementation
+func (h *schedules) ProcessMessage(command string, message slack.Msg) bool {
+
+	jobs := h.bot.Scheduler.List()
+	if len(jobs) == 0 {
+		h.sink <- blocks.New().Section(h.bot.T(message.User, "There is no scheduled job.")).Response(message.Channel)
+		return true
+	}
+
+	rows := [][]string{}
+	for _, j := range jobs {
+		what := h.bot.T(message.User, "function")
+		if j.Command != "" {
+			what = h.bot.T(message.User, "%s in %s", j.Command, j.Channel)
+		}
+		rows = append(rows, []string{j.ID, j.Schedule, what, h.formatRun(message.User, j.NextRun), h.formatRun(message.User, j.LastRun)})
+	}
+
+	h.sink <- blocks.New().Section(h.bot.T(message.User, "Here are the scheduled jobs:")).
+		Table([]string{h.bot.T(message.User, "JOB"), h.bot.T(message.User, "SCHEDULE"), h.bot.T(message.User, "RUN"), h.bot.T(message.User, "NEXT"), h.bot.T(message.User, "LAST")}, rows).
+		Response(message.Channel)
+	return true
+}
+
+// formatRun format a run time
+func (h *schedules) formatRun(user string, t time.Time) string {
+	if t.IsZero() {
+		return h.bot.T(user, "never")
+	}
+	return t.Format("2006-01-02 15:04 MST")
+}
@@ -0,0 +1,118 @@
+package builtins
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/CyrilPeponnet/slackhal/plugin/blocks"
+	"go.uber.org/zap"
+)
+
+// status struct define your plugin
+type status struct {
+	plugin.Metadata
+	log *zap.Logger
+}
+
+// pluginHealth is the health of a plugin in the /healthz document
+type pluginHealth struct {
+	plugin.Health
+	Version string `json:"version"`
+}
+
+// healthReport is the /healthz document
+type healthReport struct {
+	Status  plugin.HealthStatus     `json:"status"`
+	Plugins map[string]pluginHealth `json:"plugins"`
+}
+
+// Init interface implementation if you need to init things
+// When the bot is starting.
+func (h *status) Init(output chan<- *plugin.SlackResponse, bot *plugin.Bot) {
+	h.log = bot.Logger("status")
+}
+
+// GetMetadata interface implementation
+func (h *status) GetMetadata() *plugin.Metadata {
+	return &h.Metadata
+}
+
+// Handle interface implementation
+func (h *status) Handle(ctx context.Context, req *plugin.Request) (*plugin.Result, error) {
+
+	report := newHealthReport()
+
+	names := []string{}
+	for name := range report.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rows := [][]string{}
+	for _, name := range names {
+		p := report.Plugins[name]
+		rows = append(rows, []string{name, p.Version, string(p.Status), p.Details})
+	}
+
+	m := blocks.New().Section(healthIcon(report.Status)+" Plugins are *"+string(report.Status)+"*").
+		Table([]string{"NAME", "VERSION", "STATUS", "DETAILS"}, rows)
+
+	return &plugin.Result{Handled: true, Responses: []*plugin.SlackResponse{m.Response(req.Channel.ID)}}, nil
+}
+
+// ServeHTTP serve the health of the plugins as JSON, with a 503 status if they are not ok
+func (h *status) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := newHealthReport()
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != plugin.HealthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		h.log.Error("Failed to write health report", zap.Error(err))
+	}
+}
+
+// newHealthReport return the health of every plugin.
+// The status is degraded if a plugin is not ok, disabled plugins excepted.
+func newHealthReport() healthReport {
+	report := healthReport{Status: plugin.HealthOK, Plugins: map[string]pluginHealth{}}
+	for name, p := range plugin.PluginManager.All() {
+		health := plugin.PluginManager.Health(name)
+		report.Plugins[name] = pluginHealth{Health: health, Version: p.GetMetadata().Version}
+		if health.Status == plugin.HealthDegraded || health.Status == plugin.HealthFailing {
+			report.Status = plugin.HealthDegraded
+		}
+	}
+	return report
+}
+
+// healthIcon return the emoji of a status
+func healthIcon(status plugin.HealthStatus) string {
+	switch status {
+	case plugin.HealthOK:
+		return ":large_green_circle:"
+	case plugin.HealthDegraded:
+		return ":warning:"
+	case plugin.HealthFailing:
+		return ":red_circle:"
+	}
+	return ":white_circle:"
+}
+
+// newStatus return a new status plugin
+func newStatus() *status {
+	s := new(status)
+	s.Metadata = plugin.NewMetadata("status")
+	s.Description = "Tell the health of the plugins."
+	s.ActiveTriggers = []plugin.Command{{Name: "status", ShortDescription: "Show the health of the plugins.", LongDescription: "Will list the plugins with their status and the details of their problems."}}
+	s.HTTPHandler[plugin.Command{Name: "/healthz", ShortDescription: "Health of the plugins as JSON."}] = s
+	return s
+}
+
+// init function that will register your plugin to the plugin manager
+func init() {
+	plugin.PluginManager.RegisterV2(newStatus())
+}
@@ -0,0 +1,146 @@
+package builtins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/CyrilPeponnet/slackhal/plugin/plugintest"
+	"github.com/slack-go/slack"
+)
+
+// probe is a plugin reporting a given health
+type probe struct {
+	plugin.Metadata
+	health plugin.Health
+}
+
+func newProbe(name string, health plugin.Health) *probe {
+	return &probe{Metadata: plugin.NewMetadata(name), health: health}
+}
+
+func (p *probe) Init(output chan<- *plugin.SlackResponse, bot *plugin.Bot) {}
+
+func (p *probe) GetMetadata() *plugin.Metadata {
+	return &p.Metadata
+}
+
+func (p *probe) Handle(ctx context.Context, req *plugin.Request) (*plugin.Result, error) {
+	return nil, nil
+}
+
+func (p *probe) Health() plugin.Health {
+	return p.health
+}
+
+// row return the fields of the row of a plugin in the status table
+func row(responses plugintest.Responses, name string) string {
+	for _, r := range responses {
+		for _, text := range r.BlockTexts() {
+			for _, line := range strings.Split(text, "\n") {
+				if fields := strings.Fields(line); len(fields) > 0 && fields[0] == name {
+					return strings.Join(fields, " ")
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func TestStatus(t *testing.T) {
+
+	b := plugintest.NewBot(t)
+	b.AddUser(slack.User{ID: "U1", Name: "dave"})
+	b.AddChannel(slack.Channel{GroupConversation: slack.GroupConversation{Name: "team", Conversation: slack.Conversation{ID: "C1"}}}, "U1")
+	s := newStatus()
+	b.LoadV2(s)
+
+	// The plugins of the steps are registered to the plugin manager, they are left healthy
+	t.Cleanup(func() {
+		plugin.PluginManager.SetDisabled("flaky", false)
+		plugin.PluginManager.SetInitError("broken", nil)
+	})
+
+	// Every step adds a plugin to the ones of the previous steps
+	tests := []struct {
+		name  string
+		setup func()
+		// Expected overall status, HTTP status and row of the plugin
+		status string
+		code   int
+		row    string
+	}{
+		{"healthy", func() {
+			plugin.PluginManager.RegisterV2(newProbe("healthy", plugin.Health{Status: plugin.HealthOK, Details: "3 facts"}))
+		}, "ok", http.StatusOK, "healthy 1.0 ok 3 facts"},
+		{"degraded", func() {
+			plugin.PluginManager.RegisterV2(newProbe("flaky", plugin.Health{Status: plugin.HealthOK}))
+			plugin.PluginManager.Failed("flaky")
+		}, "degraded", http.StatusServiceUnavailable, "flaky 1.0 degraded 1 failures in the last 1h0m0s"},
+		{"failed init", func() {
+			plugin.PluginManager.RegisterV2(newProbe("broken", plugin.Health{Status: plugin.HealthOK}))
+			plugin.PluginManager.SetInitError("broken", errors.New("cannot open the database"))
+		}, "degraded", http.StatusServiceUnavailable, "broken 1.0 failing cannot open the database"},
+	}
+
+	for _, test := range tests {
+		test.setup()
+
+		responses := b.Dispatch(plugintest.ChannelMessage("C1", "U1", "!status"))
+		responses.AssertCount(t, 1)
+		responses.AssertBlockText(t, "Plugins are *"+test.status+"*")
+		if got := row(responses, test.row[:strings.Index(test.row, " ")]); got != test.row {
+			t.Errorf("%s: got row %q, want %q", test.name, got, test.row)
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+		report := healthReport{}
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != test.code || string(report.Status) != test.status {
+			t.Errorf("%s: got /healthz %d %s, want %d %s", test.name, w.Code, report.Status, test.code, test.status)
+		}
+	}
+}
+
+func TestCat(t *testing.T) {
+
+	b := plugintest.NewBot(t)
+	b.AddUser(slack.User{ID: "U1", Name: "dave"})
+	b.AddChannel(slack.Channel{GroupConversation: slack.GroupConversation{Name: "team", Conversation: slack.Conversation{ID: "C1"}}}, "U1")
+	b.Load(newCat())
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api" {
+			http.Redirect(w, r, "/cat.gif", http.StatusFound)
+		}
+	}))
+	defer api.Close()
+	defer func(url string) { catAPI = url }(catAPI)
+
+	tests := []struct {
+		name string
+		api  string
+		want string
+	}{
+		{"found", api.URL + "/api", "Hey <@U1> look what I just found for you: " + api.URL + "/cat.gif"},
+		{"offline", "http://127.0.0.1:0/api", "I cannot find a single funny cat picture on Internet"},
+	}
+
+	for _, test := range tests {
+		catAPI = test.api
+		b.Dispatch(plugintest.ChannelMessage("C1", "U1", "!cat"))
+		b.Sink.Flush()
+		responses := b.Sink.Responses()
+		responses.AssertCount(t, 1)
+		responses.AssertBlockText(t, test.want)
+		b.Sink.Reset()
+	}
+}
@@ -3,49 +3,107 @@ package pluginfacts
 import (
 	"bytes"
 	"fmt"
+	"path/filepath"
 	"strings"
+	"sync"
 	"text/template"
 
 	"github.com/CyrilPeponnet/slackhal/plugin"
 	"github.com/slack-go/slack"
-	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
 // logger struct define your plugin
 type facts struct {
 	plugin.Metadata
-	sink          chan<- *plugin.SlackResponse
-	factDB        factStorer
-	bot           *plugin.Bot
-	configuration *viper.Viper
+	sink   chan<- *plugin.SlackResponse
+	factDB factStorer
+	bot    *plugin.Bot
+	log    *zap.Logger
+	// Logger of the passive triggers
+	passiveLog *zap.Logger
+	config     configuration
+	lock       sync.RWMutex
 }
 
+// configuration of the plugin
+type configuration struct {
+	// Mention the author of the message when replaying a fact
+	Mention bool
+}
+
+// configure apply the configuration of the plugin
+func (h *facts) configure(c *plugin.Config) error {
+	conf := configuration{}
+	if err := c.Unmarshal(&conf); err != nil {
+		return err
+	}
+	h.lock.Lock()
+	h.config = conf
+	h.lock.Unlock()
+	return nil
+}
+
+// legacyDatabase is the database of the facts before they moved to the plugin store
+const legacyDatabase = "facts.db"
+
 // Init interface implementation if you need to init things
 // When the bot is starting.
 func (h *facts) Init(output chan<- *plugin.SlackResponse, bot *plugin.Bot) {
 	h.sink = output
 	h.bot = bot
-	h.configuration = viper.New()
-	h.configuration.AddConfigPath("/etc/slackhal/")
-	h.configuration.AddConfigPath("$HOME/.slackhal")
-	h.configuration.AddConfigPath(".")
-	h.configuration.SetConfigName("plugin-facts")
-	h.configuration.SetConfigType("yaml")
-	err := h.configuration.ReadInConfig()
-	if err != nil {
-		zap.L().Error("Not able to read configuration for facts plugin.", zap.Error(err))
+	h.log = bot.Logger("facts")
+	h.passiveLog = bot.PassiveLogger("facts")
+	config := bot.Config(h.Name)
+	config.SetDefault("mention", true)
+	if err := h.configure(config); err != nil {
+		h.log.Error("Not able to read configuration for facts plugin.", zap.Error(err))
+		plugin.PluginManager.SetInitError(h.Name, fmt.Errorf("invalid configuration: %v", err))
 		h.Disabled = true
 		return
 	}
-	dbPath := h.configuration.GetString("database.path")
-	h.factDB = new(stormDB)
-	err = h.factDB.Connect(dbPath)
+	config.OnChange(h.configure)
+	db := &storeDB{store: bot.Store(h.Name)}
+	if _, err := db.ListFacts(); err != nil {
+		h.log.Error("Error while reading the facts!", zap.Error(err))
+		plugin.PluginManager.SetInitError(h.Name, fmt.Errorf("cannot read the facts: %v", err))
+		h.Disabled = true
+		return
+	}
+	n, err := db.importLegacy(filepath.Join(bot.DataDir, legacyDatabase))
 	if err != nil {
-		zap.L().Error("Error while opening the facts database!", zap.Error(err))
+		h.log.Error("Error while importing the facts database!", zap.Error(err))
+		plugin.PluginManager.SetInitError(h.Name, fmt.Errorf("cannot import the database: %v", err))
 		h.Disabled = true
 		return
 	}
+	if n > 0 {
+		h.log.Info("Imported the facts database in the plugin store", zap.Int("facts", n))
+	}
+	h.factDB = db
+	plugin.PluginManager.Provide(h.Name, FactLookupService, FactLookup(h))
+}
+
+// Health interface implementation
+func (h *facts) Health() plugin.Health {
+	return plugin.Health{Status: plugin.HealthOK, Details: fmt.Sprintf("%d facts", h.factDB.NumberOfFacts())}
+}
+
+// FactLookupService is the name of the FactLookup service published by the plugin
+const FactLookupService = "facts"
+
+// FactLookup is a service giving access to the facts
+type FactLookup interface {
+	// Fact return the content of a fact by name
+	Fact(name string) (string, bool)
+}
+
+// Fact interface implementation
+func (h *facts) Fact(name string) (string, bool) {
+	if f := h.factDB.FindFactByName(name); f != nil {
+		return f.Content, true
+	}
+	return "", false
 }
 
 // GetMetadata interface implementation
@@ -81,7 +139,7 @@ func (h *facts) ProcessMessage(command string, message slack.Msg) bool {
 		// Split our command in to 4 parts we are looking for tokens AS WHEN and IN
 		parts := strings.Split(text, "/as")
 		if len(parts) != 2 {
-			h.simpleResponse(message, "A fact must have the from `my fact /as my content /when this /or that [/in #chan1 #chan2]")
+			h.simpleResponse(message, h.bot.PluginT(h.Name, message.User, "A fact must have the from `my fact /as my content /when this /or that [/in #chan1 #chan2]"))
 			return false
 		}
 
@@ -89,7 +147,7 @@ func (h *facts) ProcessMessage(command string, message slack.Msg) bool {
 
 		parts = strings.Split(parts[1], "/when")
 		if len(parts) != 2 {
-			h.simpleResponse(message, "A fact must have the from `my fact /as my content /when this /or that [/in #chan1 #chan2]")
+			h.simpleResponse(message, h.bot.PluginT(h.Name, message.User, "A fact must have the from `my fact /as my content /when this /or that [/in #chan1 #chan2]"))
 			return false
 		}
 
@@ -110,16 +168,16 @@ func (h *facts) ProcessMessage(command string, message slack.Msg) bool {
 		}
 
 		if h.factDB.FindFactByName(f.Name) != nil && command == cmdNew {
-			h.simpleResponse(message, "I'm afraid I cannot do that. There is already a fact registered with that name.")
+			h.simpleResponse(message, h.bot.PluginT(h.Name, message.User, "I'm afraid I cannot do that. There is already a fact registered with that name."))
 			return false
 		}
 
 		if err := h.factDB.AddFact(&f); err != nil {
-			zap.L().Error("Failed to save fact", zap.Error(err))
-			h.simpleResponse(message, "I'm afraid I cannot do that. Something went wrong.")
+			h.log.Error("Failed to save fact", zap.Error(err))
+			h.simpleResponse(message, h.bot.PluginT(h.Name, message.User, "I'm afraid I cannot do that. Something went wrong."))
 		}
 
-		h.simpleResponse(message, "Thanks, I will remember that.")
+		h.simpleResponse(message, h.bot.PluginT(h.Name, message.User, "Thanks, I will remember that."))
 
 	case cmddel:
 		name := strings.TrimSpace(message.Text[strings.Index(message.Text, cmddel)+len(cmddel) : len(message.Text)])
@@ -127,23 +185,23 @@ func (h *facts) ProcessMessage(command string, message slack.Msg) bool {
 		if foundFact != nil {
 			err := h.factDB.DelFact(name)
 			if err != nil {
-				h.simpleResponse(message, fmt.Sprintf("Error while deleting this fact (%v)", err))
+				h.simpleResponse(message, h.bot.PluginT(h.Name, message.User, "Error while deleting this fact (%v)", err))
 			} else {
-				h.simpleResponse(message, "Ok, I will forget this fact.")
+				h.simpleResponse(message, h.bot.PluginT(h.Name, message.User, "Ok, I will forget this fact."))
 			}
 		} else {
-			h.simpleResponse(message, fmt.Sprintf("Sorry cannot find a fact with name _%v_", name))
+			h.simpleResponse(message, h.bot.PluginT(h.Name, message.User, "Sorry cannot find a fact with name _%v_", name))
 		}
 
 	case cmdlist:
 
 		factsList, err := h.factDB.ListFacts()
 		if err != nil {
-			zap.L().Error("Error while getting facts", zap.Error(err))
+			h.log.Error("Error while getting facts", zap.Error(err))
 			return false
 		}
 
-		content := "Here is the facts I know:\n"
+		content := h.bot.PluginT(h.Name, message.User, "Here is the facts I know:") + "\n"
 
 		tpl := `
 {{- range .}}
@@ -152,14 +210,14 @@ func (h *facts) ProcessMessage(command string, message slack.Msg) bool {
 `
 		t, err := template.New("output").Funcs(template.FuncMap{"Join": strings.Join}).Parse(tpl)
 		if err != nil {
-			zap.L().Error("Error while parsing template", zap.Error(err))
+			h.log.Error("Error while parsing template", zap.Error(err))
 			return false
 		}
 
 		buf := new(bytes.Buffer)
 		err = t.Execute(buf, factsList)
 		if err != nil {
-			zap.L().Error("Error while rendering template", zap.Error(err))
+			h.log.Error("Error while rendering template", zap.Error(err))
 			return false
 		}
 
@@ -170,7 +228,7 @@ func (h *facts) ProcessMessage(command string, message slack.Msg) bool {
 		foundFact := h.factDB.FindFact(message.Text)
 		if foundFact != nil {
 			if !allowedChan(foundFact, message) {
-				h.simpleResponse(message, fmt.Sprintf("Sorry <@%v>, this fact is not allowed in that channel.", message.User))
+				h.simpleResponse(message, h.bot.PluginT(h.Name, message.User, "Sorry <@%v>, this fact is not allowed in that channel.", message.User))
 
 			} else {
 				if foundFact.Content != "" {
@@ -181,9 +239,17 @@ func (h *facts) ProcessMessage(command string, message slack.Msg) bool {
 	default:
 		foundFact := h.factDB.FindFact(message.Text)
 		if foundFact != nil {
+			h.passiveLog.Debug("Message matches a fact", zap.String("fact", foundFact.Name), zap.String("channel", message.Channel))
 			if allowedChan(foundFact, message) {
 				if foundFact.Content != "" {
-					h.simpleResponse(message, fmt.Sprintf("<@%v>: %v", message.User, foundFact.Content))
+					h.lock.RLock()
+					mention := h.config.Mention
+					h.lock.RUnlock()
+					if mention {
+						h.simpleResponse(message, fmt.Sprintf("<@%v>: %v", message.User, foundFact.Content))
+					} else {
+						h.simpleResponse(message, foundFact.Content)
+					}
 					return true
 				}
 			}
@@ -208,7 +274,7 @@ func allowedChan(f *fact, m slack.Msg) bool {
 	return false
 }
 
-// Self interface implementation
+// Self interface implementation, other plugins should use the FactLookup service
 func (h *facts) Self() (i interface{}) {
 	return h
 }
@@ -222,8 +288,8 @@ const (
 	cmdremind = "tell-fact"
 )
 
-// init function that will register your plugin to the plugin manager
-func init() {
+// newFacts return a new facts plugin
+func newFacts() *facts {
 	learner := new(facts)
 	learner.Metadata = plugin.NewMetadata("facts")
 	learner.Description = "Tell facts given patterns."
@@ -233,6 +299,12 @@ func init() {
 		{Name: cmdlist, ShortDescription: "List all learned facts.", LongDescription: "Will list all the registered facts."},
 		{Name: cmdremind, ShortDescription: "Tell someone about a fact.", LongDescription: "Will metion a person with the content of a fact."},
 		{Name: cmddel, ShortDescription: "Remove a given fact.", LongDescription: "Allow you to remove a registered fact."}}
+	learner.Provides = []string{FactLookupService}
 	learner.PassiveTriggers = []plugin.Command{{Name: `(?s:.*)`, ShortDescription: "Look for facts", LongDescription: "Will look for registered facts to replay."}}
-	plugin.PluginManager.Register(learner)
+	return learner
+}
+
+// init function that will register your plugin to the plugin manager
+func init() {
+	plugin.PluginManager.Register(newFacts())
 }
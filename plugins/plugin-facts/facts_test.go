@@ -0,0 +1,101 @@
+package pluginfacts
+
+import (
+	"testing"
+
+	"github.com/CyrilPeponnet/slackhal/plugin/plugintest"
+	"github.com/slack-go/slack"
+)
+
+func TestFacts(t *testing.T) {
+
+	b := plugintest.NewBot(t)
+	b.AddUser(slack.User{ID: "U1", Name: "dave"})
+	b.AddChannel(slack.Channel{GroupConversation: slack.GroupConversation{Name: "team", Conversation: slack.Conversation{ID: "C1"}}}, "U1")
+	b.AddChannel(slack.Channel{GroupConversation: slack.GroupConversation{Name: "ops", Conversation: slack.Conversation{ID: "C2"}}}, "U1")
+	f := newFacts()
+	b.Load(f)
+
+	// Every step runs on the facts learned by the previous ones
+	tests := []struct {
+		name    string
+		channel string
+		text    string
+		// Expected response, no response if empty
+		want string
+	}{
+		{"invalid", "C1", "!new-fact wiki", "A fact must have the from"},
+		{"no pattern", "C1", "!new-fact wiki /as https://wiki", "A fact must have the from"},
+		{"new", "C1", "!new-fact wiki /as https://wiki /when where is the wiki /or wiki link", "Thanks, I will remember that."},
+		{"duplicate", "C1", "!new-fact wiki /as https://other /when wiki", "There is already a fact registered with that name."},
+		{"restricted", "C1", "!new-fact oncall /as call bob /when who is on call /in <#C2|ops>", "Thanks, I will remember that."},
+		{"list", "C1", "!list-facts", "• wiki */as* https://wiki */when* where is the wiki */or* wiki link"},
+		{"pattern", "C1", "Where is the WIKI?", "<@U1>: https://wiki"},
+		{"other pattern", "C1", "a wiki link please", "<@U1>: https://wiki"},
+		{"no match", "C1", "hello", ""},
+		{"other channel", "C1", "who is on call", ""},
+		{"allowed channel", "C2", "who is on call", "<@U1>: call bob"},
+		{"tell", "C1", "!tell-fact <@U1> where is the wiki", "<@U1> where is the wiki\nhttps://wiki"},
+		{"tell not allowed", "C1", "!tell-fact <@U1> who is on call", "this fact is not allowed in that channel"},
+		{"update", "C1", "!update-fact wiki /as https://new-wiki /when wiki", "Thanks, I will remember that."},
+		{"updated", "C1", "wiki", "<@U1>: https://new-wiki"},
+		{"remove", "C1", "!remove-fact wiki", "Ok, I will forget this fact."},
+		{"removed", "C1", "wiki", ""},
+		{"remove unknown", "C1", "!remove-fact wiki", "Sorry cannot find a fact with name _wiki_"},
+	}
+
+	for _, test := range tests {
+		responses := b.Dispatch(plugintest.ChannelMessage(test.channel, "U1", test.text))
+		if test.want == "" {
+			responses.AssertEmpty(t)
+			continue
+		}
+		responses.AssertCount(t, 1)
+		responses.AssertText(t, test.want)
+		responses.AssertChannel(t, test.channel)
+	}
+
+	// The service published to the other plugins
+	if content, ok := f.Fact("oncall"); !ok || content != "call bob" {
+		t.Errorf("got fact %q, %v", content, ok)
+	}
+	if _, ok := f.Fact("wiki"); ok {
+		t.Error("removed fact found")
+	}
+}
+
+func TestFactsConfiguration(t *testing.T) {
+
+	b := plugintest.NewBot(t)
+	b.AddUser(slack.User{ID: "U1", Name: "dave"})
+	b.AddChannel(slack.Channel{GroupConversation: slack.GroupConversation{Name: "team", Conversation: slack.Conversation{ID: "C1"}}}, "U1")
+	b.WriteConfig("facts", "mention: false\n")
+	b.Load(newFacts())
+
+	b.Dispatch(plugintest.ChannelMessage("C1", "U1", "!new-fact wiki /as https://wiki /when wiki")).AssertText(t, "Thanks, I will remember that.")
+
+	// Every step reloads the configuration
+	tests := []struct {
+		name   string
+		config string
+		err    bool
+		want   string
+	}{
+		{"file", "mention: false\n", false, "https://wiki"},
+		{"reloaded", "mention: true\n", false, "<@U1>: https://wiki"},
+		{"invalid", "mention: [yes]\n", true, "<@U1>: https://wiki"},
+		{"default", "", false, "<@U1>: https://wiki"},
+	}
+
+	for _, test := range tests {
+		b.WriteConfig("facts", test.config)
+		if err := b.Config("facts").Reload(); (err != nil) != test.err {
+			t.Errorf("%s: got reload error %v", test.name, err)
+		}
+		responses := b.Dispatch(plugintest.ChannelMessage("C1", "U1", "wiki"))
+		responses.AssertCount(t, 1)
+		if texts := responses.Texts(); len(texts) != 1 || texts[0] != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, texts, test.want)
+		}
+	}
+}
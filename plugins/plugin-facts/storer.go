@@ -1,8 +1,10 @@
 package pluginfacts
 
 import (
+	"os"
 	"strings"
 
+	"github.com/CyrilPeponnet/slackhal/plugin"
 	"github.com/asdine/storm"
 )
 
@@ -16,7 +18,6 @@ type fact struct {
 
 // factStorer interface
 type factStorer interface {
-	Connect(string) error
 	AddFact(*fact) error
 	DelFact(name string) error
 	ListFacts() ([]fact, error)
@@ -25,46 +26,48 @@ type factStorer interface {
 	FindFactByName(name string) *fact
 }
 
-//Storm orm db
-type stormDB struct {
-	db *storm.DB
-}
+// factPrefix is the prefix of the keys of the facts in the store
+const factPrefix = "fact:"
 
-func (s *stormDB) Connect(dbPath string) (err error) {
-	if s.db == nil {
-		s.db, err = storm.Open(dbPath)
-	}
-	return err
+// storeDB keep the facts in the plugin store
+type storeDB struct {
+	store *plugin.Store
 }
 
-func (s *stormDB) AddFact(f *fact) (err error) {
-	return s.db.Save(f)
+func (s *storeDB) AddFact(f *fact) error {
+	return s.store.Put(factPrefix+f.Name, f)
 }
 
-func (s *stormDB) NumberOfFacts() int {
-	n, _ := s.db.Count(&fact{})
-	return n
+func (s *storeDB) NumberOfFacts() int {
+	keys, _ := s.store.List(factPrefix)
+	return len(keys)
 }
 
-func (s *stormDB) ListFacts() (factlist []fact, err error) {
-	if err := s.db.All(&factlist); err != nil {
+func (s *storeDB) ListFacts() (factlist []fact, err error) {
+	keys, err := s.store.List(factPrefix)
+	if err != nil {
 		return nil, err
 	}
+	for _, k := range keys {
+		var f fact
+		if err := s.store.Get(k, &f); err != nil {
+			// Removed meanwhile
+			if err == plugin.ErrKeyNotFound {
+				continue
+			}
+			return nil, err
+		}
+		factlist = append(factlist, f)
+	}
 	return factlist, nil
 }
 
-func (s *stormDB) DelFact(name string) (err error) {
-	var f fact
-	err = s.db.One("Name", name, &f)
-	if err == nil {
-		err = s.db.DeleteStruct(&f)
-	}
-	return err
+func (s *storeDB) DelFact(name string) error {
+	return s.store.Delete(factPrefix + name)
 }
 
-func (s *stormDB) FindFact(message string) *fact {
-	var factList []fact
-	err := s.db.All(&factList)
+func (s *storeDB) FindFact(message string) *fact {
+	factList, err := s.ListFacts()
 	if err == nil {
 		for _, f := range factList {
 			for _, p := range f.Patterns {
@@ -77,11 +80,45 @@ func (s *stormDB) FindFact(message string) *fact {
 	return nil
 }
 
-func (s *stormDB) FindFactByName(name string) *fact {
+func (s *storeDB) FindFactByName(name string) *fact {
 	var f fact
-	err := s.db.One("Name", name, &f)
-	if err != nil {
+	if err := s.store.Get(factPrefix+name, &f); err != nil {
 		return nil
 	}
 	return &f
 }
+
+// importLegacy move the facts of the database used by the previous versions to the store.
+// The database is renamed once imported, it returns the number of imported facts.
+func (s *storeDB) importLegacy(dbPath string) (int, error) {
+
+	if _, err := os.Stat(dbPath); err != nil {
+		return 0, nil
+	}
+
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return 0, err
+	}
+
+	var facts []fact
+	err = db.All(&facts)
+	db.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.store.Update(func(tx *plugin.Store) error {
+		for i := range facts {
+			if err := tx.Put(factPrefix+facts[i].Name, &facts[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(facts), os.Rename(dbPath, dbPath+".imported")
+}
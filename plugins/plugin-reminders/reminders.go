@@ -0,0 +1,349 @@
+package pluginreminders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/CyrilPeponnet/slackhal/plugin/blocks"
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+)
+
+// reminders struct define your plugin
+type reminders struct {
+	plugin.Metadata
+	sink       chan<- *plugin.SlackResponse
+	reminderDB reminderStorer
+	bot        *plugin.Bot
+	log        *zap.Logger
+	// Serialize the deliveries
+	delivering sync.Mutex
+	config     configuration
+	lock       sync.RWMutex
+}
+
+// configuration of the plugin
+type configuration struct {
+	// Delay after which a delivered reminder tells when it was due
+	Late time.Duration
+}
+
+// Validate interface implementation
+func (c *configuration) Validate() error {
+	if c.Late < 0 {
+		return fmt.Errorf("late must not be negative")
+	}
+	return nil
+}
+
+// configure apply the configuration of the plugin
+func (h *reminders) configure(c *plugin.Config) error {
+	conf := configuration{}
+	if err := c.Unmarshal(&conf); err != nil {
+		return err
+	}
+	h.lock.Lock()
+	h.config = conf
+	h.lock.Unlock()
+	return nil
+}
+
+// Cmds are const for the package.
+const (
+	cmdRemind = "remind"
+	cmdList   = "list-reminders"
+	cmdCancel = "cancel-reminder"
+)
+
+// lateDelivery is the default delay after which a reminder is flagged as late
+const lateDelivery = 5 * time.Minute
+
+// legacyDatabase is the database of the reminders before they moved to the plugin store
+const legacyDatabase = "reminders.db"
+
+// Init interface implementation if you need to init things
+// When the bot is starting.
+func (h *reminders) Init(output chan<- *plugin.SlackResponse, bot *plugin.Bot) {
+	h.sink = output
+	h.bot = bot
+	h.log = bot.Logger("reminders")
+	config := bot.Config(h.Name)
+	config.SetDefault("late", lateDelivery)
+	if err := h.configure(config); err != nil {
+		h.log.Error("Not able to read configuration for reminders plugin.", zap.Error(err))
+		plugin.PluginManager.SetInitError(h.Name, fmt.Errorf("invalid configuration: %v", err))
+		h.Disabled = true
+		return
+	}
+	config.OnChange(h.configure)
+	db := &storeDB{store: bot.Store(h.Name)}
+	if _, err := db.ListDueReminders(time.Now()); err != nil {
+		h.log.Error("Error while reading the reminders!", zap.Error(err))
+		plugin.PluginManager.SetInitError(h.Name, fmt.Errorf("cannot read the reminders: %v", err))
+		h.Disabled = true
+		return
+	}
+	n, err := db.importLegacy(filepath.Join(bot.DataDir, legacyDatabase))
+	if err != nil {
+		h.log.Error("Error while importing the reminders database!", zap.Error(err))
+		plugin.PluginManager.SetInitError(h.Name, fmt.Errorf("cannot import the database: %v", err))
+		h.Disabled = true
+		return
+	}
+	if n > 0 {
+		h.log.Info("Imported the reminders database in the plugin store", zap.Int("reminders", n))
+	}
+	h.reminderDB = db
+}
+
+// GetMetadata interface implementation
+func (h *reminders) GetMetadata() *plugin.Metadata {
+	return &h.Metadata
+}
+
+// reply return a result answering text in the channel of the request
+func reply(text string) (*plugin.Result, error) {
+	return result(blocks.New().Section(text))
+}
+
+// result return a result answering a message in the channel of the request
+func result(m *blocks.Message) (*plugin.Result, error) {
+	return &plugin.Result{Handled: true, Responses: []*plugin.SlackResponse{m.Response("")}}, nil
+}
+
+// location return the timezone of a user
+func (h *reminders) location(user slack.User) *time.Location {
+	if user.TZ != "" {
+		if loc, err := time.LoadLocation(user.TZ); err == nil {
+			return loc
+		}
+		h.log.Warn("Cannot load user timezone", zap.String("user", user.ID), zap.String("tz", user.TZ))
+	}
+	return time.Local
+}
+
+// Handle interface implementation
+func (h *reminders) Handle(ctx context.Context, req *plugin.Request) (*plugin.Result, error) {
+
+	user := req.User
+	message := req.Message
+	loc := h.location(user)
+	args := req.Args
+
+	switch req.Trigger {
+
+	case cmdRemind:
+		return h.remind(message, user, loc, args)
+
+	case cmdList:
+		list, err := h.reminderDB.ListRemindersFor(user.ID)
+		if err != nil {
+			h.log.Error("Error while listing reminders", zap.Error(err))
+			return reply(h.bot.PluginT(h.Name, message.User, "I'm afraid I cannot do that. Something went wrong."))
+		}
+		if len(list) == 0 {
+			return reply(h.bot.PluginT(h.Name, message.User, "You have no pending reminder."))
+		}
+		fields := []string{}
+		for _, r := range list {
+			fields = append(fields, h.bot.PluginT(h.Name, message.User, "*#%d* %s for %s\n%s", r.ID, r.At.In(loc).Format("Mon Jan 2 15:04 MST"), r.Target, r.Text))
+		}
+		return result(blocks.New().Section(h.bot.PluginT(h.Name, message.User, "Here are your pending reminders:")).Fields(fields...).
+			Context(h.bot.PluginT(h.Name, message.User, "Use `%s <id>` to cancel one.", cmdCancel)))
+
+	case cmdCancel:
+		if len(args) == 0 {
+			return reply(h.bot.PluginT(h.Name, message.User, "Please tell me which reminder to cancel with `%s <id>`.", cmdCancel))
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+		if err != nil {
+			return reply(h.bot.PluginT(h.Name, message.User, "Sorry, `%s` is not a valid reminder id.", args[0]))
+		}
+		r := h.reminderDB.FindReminder(id)
+		if r == nil || r.User != user.ID {
+			return reply(h.bot.PluginT(h.Name, message.User, "Sorry, I cannot find your reminder #%d.", id))
+		}
+		if err := h.reminderDB.DelReminder(id); err != nil {
+			h.log.Error("Error while deleting reminder", zap.Int("id", id), zap.Error(err))
+			return reply(h.bot.PluginT(h.Name, message.User, "I'm afraid I cannot do that. Something went wrong."))
+		}
+		return reply(h.bot.PluginT(h.Name, message.User, "Ok, reminder #%d has been cancelled.", id))
+	}
+
+	return nil, nil
+}
+
+// remind parse and store a new reminder
+func (h *reminders) remind(message slack.Msg, user slack.User, loc *time.Location, args []string) (*plugin.Result, error) {
+
+	usage := h.bot.PluginT(h.Name, message.User, "A reminder must have the form `%s me|here|#channel in 2h|at 5pm|tomorrow 9am|monday 10:00 to do something`.", cmdRemind)
+
+	if len(args) < 3 {
+		return reply(usage)
+	}
+
+	channel, err := h.resolveTarget(args[0], message)
+	if err != nil {
+		return reply(h.bot.PluginT(h.Name, message.User, "Sorry, I cannot deliver a reminder to %s (%v).", args[0], err))
+	}
+
+	at, rest, err := parseWhen(args[1:], time.Now().In(loc))
+	if err != nil {
+		return reply(h.bot.PluginT(h.Name, message.User, "Sorry, I don't understand when (%v). %s", err, usage))
+	}
+
+	if len(rest) > 0 && (strings.ToLower(rest[0]) == "to" || strings.ToLower(rest[0]) == "that") {
+		rest = rest[1:]
+	}
+
+	// Keep the lines and the formatting of the message
+	text := textAfter(message.Text, cmdRemind, len(args)-len(rest))
+	if text == "" {
+		return reply(h.bot.PluginT(h.Name, message.User, "Sorry, what should I remind?"))
+	}
+
+	target := args[0]
+	if strings.ToLower(target) == "me" {
+		target = "you"
+	}
+
+	r := &reminder{
+		User:    user.ID,
+		Channel: channel,
+		Target:  target,
+		Text:    text,
+		At:      at,
+		Created: time.Now(),
+	}
+
+	if err := h.reminderDB.AddReminder(r); err != nil {
+		h.log.Error("Failed to save reminder", zap.Error(err))
+		return reply(h.bot.PluginT(h.Name, message.User, "I'm afraid I cannot do that. Something went wrong."))
+	}
+
+	return reply(h.bot.PluginT(h.Name, message.User, "Ok, I will remind %s on %s (reminder #%d).", target, at.Format("Mon Jan 2 at 15:04 MST"), r.ID))
+}
+
+// errTargetNotAllowed is returned for a target the user cannot post to
+var errTargetNotAllowed = errors.New("you can only remind yourself, here or a channel you are a member of")
+
+// resolveTarget return the channel where to deliver a reminder.
+// Users can only remind themselves or the channels they are a member of.
+func (h *reminders) resolveTarget(target string, message slack.Msg) (string, error) {
+
+	self := "<@" + message.User + ">"
+
+	switch strings.ToLower(target) {
+	case "me":
+		target = self
+	case "here":
+		return message.Channel, nil
+	}
+
+	channels, err := h.bot.ResolveChannel(target)
+	if err != nil {
+		return "", err
+	}
+	if len(channels) != 1 {
+		return "", errTargetNotAllowed
+	}
+
+	own, err := h.bot.ResolveChannel(self)
+	if err != nil {
+		return "", err
+	}
+	if channels[0] == own[0] {
+		return channels[0], nil
+	}
+
+	member, err := h.bot.GetCachedUserChans(message.User)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range member {
+		if c.ID == channels[0] {
+			return channels[0], nil
+		}
+	}
+
+	return "", errTargetNotAllowed
+}
+
+// textAfter return the text of a command following its n first arguments, as written
+func textAfter(text string, command string, n int) string {
+	i := strings.Index(strings.ToLower(text), command)
+	if i == -1 {
+		return ""
+	}
+	text = text[i+len(command):]
+	for ; n > 0; n-- {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		if j := strings.IndexFunc(text, unicode.IsSpace); j != -1 {
+			text = text[j:]
+		} else {
+			text = ""
+		}
+	}
+	return strings.TrimSpace(text)
+}
+
+// deliver the due reminders.
+// A reminder is deleted before being sent so it is never delivered twice.
+func (h *reminders) deliver(now time.Time) {
+
+	if plugin.PluginManager.Disabled(h.Name) || h.reminderDB == nil {
+		return
+	}
+
+	h.delivering.Lock()
+	defer h.delivering.Unlock()
+
+	due, err := h.reminderDB.ListDueReminders(now)
+	if err != nil {
+		h.log.Error("Error while listing due reminders", zap.Error(err))
+		return
+	}
+
+	h.lock.RLock()
+	late := h.config.Late
+	h.lock.RUnlock()
+
+	for _, r := range due {
+		if err := h.reminderDB.DelReminder(r.ID); err != nil {
+			h.log.Error("Error while deleting due reminder, not delivered", zap.Int("id", r.ID), zap.Error(err))
+			continue
+		}
+
+		note := h.bot.PluginT(h.Name, r.User, "Reminder #%d set by <@%s>", r.ID, r.User)
+		if now.Sub(r.At) > late {
+			note += h.bot.PluginT(h.Name, r.User, ", it was due <!date^%d^{date_short_pretty} at {time}|%s>", r.At.Unix(), r.At.Format(time.RFC1123))
+		}
+		h.sink <- blocks.New().Section(":alarm_clock: " + r.Text).Context(note).Response(r.Channel)
+	}
+}
+
+// newReminders return a new reminders plugin
+func newReminders() *reminders {
+	reminder := new(reminders)
+	reminder.Metadata = plugin.NewMetadata("reminders")
+	reminder.Description = "Remind people and channels."
+	reminder.ActiveTriggers = []plugin.Command{
+		{Name: cmdRemind, ShortDescription: "Set a reminder.", LongDescription: "Will set a reminder with the form `remind me|here|#channel in 2h|at 5pm|tomorrow 9am|monday 10:00|2020-12-24 8pm to do something`. Times are in your timezone."},
+		{Name: cmdList, ShortDescription: "List your reminders.", LongDescription: "Will list the pending reminders you have set."},
+		{Name: cmdCancel, ShortDescription: "Cancel a reminder.", LongDescription: "Will cancel one of your reminders given its id `cancel-reminder <id>`."}}
+	reminder.Jobs = []plugin.Job{{Name: "deliver", Schedule: "@every 30s", Run: reminder.deliver}}
+	return reminder
+}
+
+// init function that will register your plugin to the plugin manager
+func init() {
+	plugin.PluginManager.RegisterV2(newReminders())
+}
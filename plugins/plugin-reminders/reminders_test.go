@@ -0,0 +1,139 @@
+package pluginreminders
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CyrilPeponnet/slackhal/plugin/plugintest"
+	"github.com/asdine/storm"
+	"github.com/slack-go/slack"
+)
+
+func TestReminders(t *testing.T) {
+
+	b := plugintest.NewBot(t)
+	b.AddUser(slack.User{ID: "U1", Name: "dave", TZ: "UTC"})
+	b.AddUser(slack.User{ID: "U2", Name: "frank"})
+	b.AddChannel(slack.Channel{GroupConversation: slack.GroupConversation{Name: "team", Conversation: slack.Conversation{ID: "C1"}}}, "U1", "U2")
+	b.AddChannel(slack.Channel{GroupConversation: slack.GroupConversation{Name: "ops", Conversation: slack.Conversation{ID: "C2", IsPrivate: true}}}, "U2")
+	h := newReminders()
+	b.LoadV2(h)
+
+	// Every step runs on the reminders set by the previous ones
+	tests := []struct {
+		user string
+		text string
+		want string
+	}{
+		{"U1", "!remind me", "A reminder must have the form"},
+		{"U1", "!remind nowhere in 2h to call", "Sorry, I cannot deliver a reminder to nowhere"},
+		{"U1", "!remind me whenever to call", "Sorry, I don't understand when"},
+		{"U1", "!remind me in 2h", "Sorry, what should I remind?"},
+		{"U1", "!remind me in 2h to feed the cat", "Ok, I will remind you on"},
+		{"U1", "!remind <#C1|team> in 1h that the build is done", "(reminder #2)"},
+		{"U1", "!remind <@U2> in 1h to call", "you can only remind yourself, here or a channel you are a member of"},
+		{"U1", "!remind <#C2|ops> in 1h to call", "you can only remind yourself, here or a channel you are a member of"},
+		{"U2", "!remind <@U2> in 1h to *page*\n```oncall --ack```", "(reminder #3)"},
+		{"U1", "!list-reminders", "Here are your pending reminders:"},
+		{"U2", "!list-reminders", "*page*\n```oncall --ack```"},
+		{"U1", "!cancel-reminder", "Please tell me which reminder to cancel"},
+		{"U1", "!cancel-reminder soon", "Sorry, `soon` is not a valid reminder id."},
+		{"U2", "!cancel-reminder 1", "Sorry, I cannot find your reminder #1."},
+		{"U1", "!cancel-reminder #2", "Ok, reminder #2 has been cancelled."},
+		{"U2", "!cancel-reminder 3", "Ok, reminder #3 has been cancelled."},
+		{"U2", "!list-reminders", "You have no pending reminder."},
+	}
+
+	for _, test := range tests {
+		responses := b.Dispatch(plugintest.ChannelMessage("C1", test.user, test.text))
+		responses.AssertCount(t, 1)
+		responses.AssertBlockText(t, test.want)
+		responses.AssertChannel(t, "C1")
+	}
+
+	// Only the first reminder is left, delivered once even if late
+	b.Sink.Reset()
+	h.deliver(time.Now().Add(3 * time.Hour))
+	h.deliver(time.Now().Add(4 * time.Hour))
+	responses := b.Sink.Responses()
+	responses.AssertCount(t, 1)
+	responses.AssertChannel(t, plugintest.DirectChannel("U1"))
+	responses.AssertBlockText(t, ":alarm_clock: feed the cat")
+	responses.AssertBlockText(t, "Reminder #1 set by <@U1>, it was due")
+
+	b.Dispatch(plugintest.ChannelMessage("C1", "U1", "!list-reminders")).AssertBlockText(t, "You have no pending reminder.")
+}
+
+func TestRemindersConfiguration(t *testing.T) {
+
+	b := plugintest.NewBot(t)
+	b.AddUser(slack.User{ID: "U1", Name: "dave", TZ: "UTC"})
+	b.AddChannel(slack.Channel{GroupConversation: slack.GroupConversation{Name: "team", Conversation: slack.Conversation{ID: "C1"}}}, "U1")
+	b.WriteConfig("reminders", "late: 1h\n")
+	h := newReminders()
+	b.LoadV2(h)
+
+	// Every step reloads the configuration then delivers a reminder 30 minutes late
+	tests := []struct {
+		name   string
+		config string
+		err    bool
+		late   bool
+	}{
+		{"file", "late: 1h\n", false, false},
+		{"reloaded", "late: 10m\n", false, true},
+		{"rejected", "late: -10m\n", true, true},
+		{"invalid", "late: soon\n", true, true},
+		{"default", "", false, true},
+		{"longer", "late: 45m\n", false, false},
+	}
+
+	for _, test := range tests {
+		b.WriteConfig("reminders", test.config)
+		if err := b.Config("reminders").Reload(); (err != nil) != test.err {
+			t.Errorf("%s: got reload error %v", test.name, err)
+		}
+
+		b.Dispatch(plugintest.ChannelMessage("C1", "U1", "!remind here in 1h to check")).AssertBlockText(t, "Ok, I will remind here")
+		b.Sink.Reset()
+		h.deliver(time.Now().Add(90 * time.Minute))
+		responses := b.Sink.Responses()
+		responses.AssertCount(t, 1)
+		if late := strings.Contains(strings.Join(responses[0].BlockTexts(), "\n"), "it was due"); late != test.late {
+			t.Errorf("%s: got late %v, want %v", test.name, late, test.late)
+		}
+	}
+}
+
+func TestImportLegacy(t *testing.T) {
+
+	b := plugintest.NewBot(t)
+	b.AddUser(slack.User{ID: "U1", Name: "dave", TZ: "UTC"})
+	b.AddChannel(slack.Channel{GroupConversation: slack.GroupConversation{Name: "team", Conversation: slack.Conversation{ID: "C1"}}}, "U1")
+
+	// The database of the previous versions
+	legacy := filepath.Join(b.DataDir, legacyDatabase)
+	db, err := storm.Open(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []reminder{{ID: 3, User: "U1", Channel: "C1", Target: "here", Text: "deploy", At: time.Now().Add(time.Hour)}, {ID: 7, User: "U1", Channel: "C1", Target: "here", Text: "rollback", At: time.Now().Add(2 * time.Hour)}} {
+		if err := db.Save(&r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	b.LoadV2(newReminders())
+
+	b.Dispatch(plugintest.ChannelMessage("C1", "U1", "!list-reminders")).AssertBlockText(t, "*#7*")
+	// Ids follow the imported ones
+	b.Dispatch(plugintest.ChannelMessage("C1", "U1", "!remind here in 3h to check")).AssertBlockText(t, "(reminder #8)")
+
+	if _, err := os.Stat(legacy + ".imported"); err != nil {
+		t.Errorf("legacy database not renamed: %v", err)
+	}
+}
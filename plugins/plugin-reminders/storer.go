@@ -0,0 +1,152 @@
+package pluginreminders
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/asdine/storm"
+)
+
+// reminder struct
+type reminder struct {
+	ID int `storm:"id,increment"`
+	// The user who set the reminder
+	User string `storm:"index"`
+	// Where to deliver the reminder
+	Channel string
+	// How the target was written
+	Target  string
+	Text    string
+	At      time.Time `storm:"index"`
+	Created time.Time
+}
+
+// reminderStorer interface
+type reminderStorer interface {
+	AddReminder(*reminder) error
+	DelReminder(id int) error
+	FindReminder(id int) *reminder
+	ListRemindersFor(user string) ([]reminder, error)
+	ListDueReminders(now time.Time) ([]reminder, error)
+}
+
+// Keys of the reminders and of the last reminder id in the store
+const (
+	reminderPrefix = "reminder:"
+	lastIDKey      = "last-id"
+)
+
+// storeDB keep the reminders in the plugin store
+type storeDB struct {
+	store *plugin.Store
+}
+
+// reminderKey return the key of a reminder, ids are padded to be listed in order
+func reminderKey(id int) string {
+	return fmt.Sprintf("%s%010d", reminderPrefix, id)
+}
+
+// AddReminder save a reminder, a new id is given to reminders without one
+func (s *storeDB) AddReminder(r *reminder) error {
+	return s.store.Update(func(tx *plugin.Store) error {
+		if r.ID == 0 {
+			last := 0
+			if err := tx.Get(lastIDKey, &last); err != nil && err != plugin.ErrKeyNotFound {
+				return err
+			}
+			r.ID = last + 1
+			if err := tx.Put(lastIDKey, r.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Put(reminderKey(r.ID), r)
+	})
+}
+
+func (s *storeDB) DelReminder(id int) error {
+	return s.store.Delete(reminderKey(id))
+}
+
+func (s *storeDB) FindReminder(id int) *reminder {
+	var r reminder
+	if err := s.store.Get(reminderKey(id), &r); err != nil {
+		return nil
+	}
+	return &r
+}
+
+// list return the reminders matching keep ordered by date
+func (s *storeDB) list(keep func(r *reminder) bool) (reminders []reminder, err error) {
+	keys, err := s.store.List(reminderPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		var r reminder
+		if err := s.store.Get(k, &r); err != nil {
+			// Removed meanwhile
+			if err == plugin.ErrKeyNotFound {
+				continue
+			}
+			return nil, err
+		}
+		if keep(&r) {
+			reminders = append(reminders, r)
+		}
+	}
+	sort.SliceStable(reminders, func(i, j int) bool { return reminders[i].At.Before(reminders[j].At) })
+	return reminders, nil
+}
+
+func (s *storeDB) ListRemindersFor(user string) ([]reminder, error) {
+	return s.list(func(r *reminder) bool { return r.User == user })
+}
+
+func (s *storeDB) ListDueReminders(now time.Time) ([]reminder, error) {
+	return s.list(func(r *reminder) bool { return !r.At.After(now) })
+}
+
+// importLegacy move the reminders of the database used by the previous versions to the store.
+// The database is renamed once imported, it returns the number of imported reminders.
+func (s *storeDB) importLegacy(dbPath string) (int, error) {
+
+	if _, err := os.Stat(dbPath); err != nil {
+		return 0, nil
+	}
+
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return 0, err
+	}
+
+	var reminders []reminder
+	err = db.All(&reminders)
+	db.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.store.Update(func(tx *plugin.Store) error {
+		last := 0
+		if err := tx.Get(lastIDKey, &last); err != nil && err != plugin.ErrKeyNotFound {
+			return err
+		}
+		for i := range reminders {
+			if reminders[i].ID > last {
+				last = reminders[i].ID
+			}
+			if err := tx.Put(reminderKey(reminders[i].ID), &reminders[i]); err != nil {
+				return err
+			}
+		}
+		return tx.Put(lastIDKey, last)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(reminders), os.Rename(dbPath, dbPath+".imported")
+}
@@ -0,0 +1,250 @@
+package pluginreminders
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Natural time expressions parser.
+
+Supported expressions are:
+- in 2h, in 1h30m, in 2 days, in 10 minutes
+- at 9am, at 14:30 (today or tomorrow if already passed)
+- today 5pm, tomorrow 9am, tomorrow (9am by default)
+- monday 10:00, on friday at 4pm
+- 2020-12-24 8pm
+*/
+
+// defaultHour is used when only a day is given
+const defaultHour = 9
+
+var (
+	compactDurationPattern = regexp.MustCompile(`^(\d+(w|d|h|m|s))+$`)
+	compactPartPattern     = regexp.MustCompile(`(\d+)(w|d|h|m|s)`)
+	clockPattern           = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+	datePattern            = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+var units = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+// parseWhen parse a time expression at the beginning of words relative to now.
+// It returns the time and the remaining words.
+func parseWhen(words []string, now time.Time) (time.Time, []string, error) {
+
+	if len(words) == 0 {
+		return time.Time{}, nil, fmt.Errorf("missing time expression")
+	}
+
+	if strings.ToLower(words[0]) == "in" {
+		return parseRelative(words[1:], now)
+	}
+
+	return parseAbsolute(words, now)
+}
+
+// parseRelative parse durations like 2h, 1h30m or 2 hours 10 minutes
+func parseRelative(words []string, now time.Time) (time.Time, []string, error) {
+
+	var total time.Duration
+	i := 0
+
+	for i < len(words) {
+		w := strings.ToLower(words[i])
+
+		// Compact form
+		if compactDurationPattern.MatchString(w) {
+			for _, p := range compactPartPattern.FindAllStringSubmatch(w, -1) {
+				n, _ := strconv.Atoi(p[1])
+				total += time.Duration(n) * units[p[2]]
+			}
+			i++
+			continue
+		}
+
+		// Number followed by a unit
+		n, err := strconv.Atoi(w)
+		if err != nil || i+1 >= len(words) {
+			break
+		}
+		unit, ok := units[strings.TrimSuffix(strings.ToLower(words[i+1]), ",")]
+		if !ok {
+			break
+		}
+		total += time.Duration(n) * unit
+		i += 2
+
+		// allow "2 hours and 10 minutes"
+		if i < len(words) && strings.ToLower(words[i]) == "and" && i+1 < len(words) {
+			if _, err := strconv.Atoi(words[i+1]); err == nil {
+				i++
+			}
+		}
+	}
+
+	if total <= 0 {
+		return time.Time{}, nil, fmt.Errorf("cannot understand the duration")
+	}
+
+	return now.Add(total), words[i:], nil
+}
+
+// parseAbsolute parse a day and/or a time of the day
+func parseAbsolute(words []string, now time.Time) (time.Time, []string, error) {
+
+	loc := now.Location()
+	i := 0
+
+	var day time.Time
+	dayGiven, weekday := false, false
+
+	if i < len(words) && strings.ToLower(words[i]) == "on" {
+		i++
+	}
+
+	if i < len(words) {
+		w := strings.ToLower(words[i])
+		switch {
+		case w == "today":
+			day, dayGiven = now, true
+			i++
+		case w == "tomorrow":
+			day, dayGiven = now.AddDate(0, 0, 1), true
+			i++
+		case datePattern.MatchString(w):
+			d, err := time.ParseInLocation("2006-01-02", w, loc)
+			if err != nil {
+				return time.Time{}, nil, fmt.Errorf("invalid date %s", w)
+			}
+			day, dayGiven = d, true
+			i++
+		default:
+			if wd, ok := weekdays[w]; ok {
+				delta := (int(wd) - int(now.Weekday()) + 7) % 7
+				day, dayGiven, weekday = now.AddDate(0, 0, delta), true, true
+				i++
+			}
+		}
+	}
+
+	at := i < len(words) && strings.ToLower(words[i]) == "at"
+	if at {
+		i++
+	}
+
+	hour, minute, n, timeGiven := parseClock(words[i:], dayGiven || at)
+	i += n
+
+	if !dayGiven && !timeGiven {
+		return time.Time{}, nil, fmt.Errorf("cannot understand when")
+	}
+
+	if !timeGiven {
+		hour, minute = defaultHour, 0
+	}
+
+	if !dayGiven {
+		day = now
+	}
+
+	t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+
+	if !t.After(now) {
+		switch {
+		case !dayGiven:
+			// Next occurence of that time
+			t = t.AddDate(0, 0, 1)
+		case weekday:
+			// Same weekday already passed means next week
+			t = t.AddDate(0, 0, 7)
+		default:
+			return time.Time{}, nil, fmt.Errorf("%s is in the past", t.Format("Mon Jan 2 15:04"))
+		}
+	}
+
+	return t, words[i:], nil
+}
+
+// parseClock parse a time of the day like 9am, 9:30 pm, 14:30, noon or midnight.
+// Bare numbers are only accepted if allowBare is set.
+// It returns the number of words used.
+func parseClock(words []string, allowBare bool) (hour int, minute int, used int, ok bool) {
+
+	if len(words) == 0 {
+		return 0, 0, 0, false
+	}
+
+	w := strings.ToLower(words[0])
+
+	switch w {
+	case "noon":
+		return 12, 0, 1, true
+	case "midnight":
+		return 0, 0, 1, true
+	}
+
+	m := clockPattern.FindStringSubmatch(w)
+	if m == nil {
+		return 0, 0, 0, false
+	}
+
+	used = 1
+	suffix := m[3]
+
+	// 9 am
+	if suffix == "" && len(words) > 1 {
+		if s := strings.ToLower(words[1]); s == "am" || s == "pm" {
+			suffix = s
+			used = 2
+		}
+	}
+
+	if suffix == "" && m[2] == "" && !allowBare {
+		return 0, 0, 0, false
+	}
+
+	hour, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+
+	if suffix != "" && (hour == 0 || hour > 12) {
+		return 0, 0, 0, false
+	}
+
+	switch suffix {
+	case "am":
+		if hour == 12 {
+			hour = 0
+		}
+	case "pm":
+		if hour < 12 {
+			hour += 12
+		}
+	}
+
+	if hour > 23 || minute > 59 {
+		return 0, 0, 0, false
+	}
+
+	return hour, minute, used, true
+}
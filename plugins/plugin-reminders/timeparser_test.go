@@ -0,0 +1,133 @@
+package pluginreminders
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+)
+
+// locations return the locations used by the tests
+func locations(t *testing.T) (newYork, tokyo *time.Location) {
+	var err error
+	if newYork, err = time.LoadLocation("America/New_York"); err != nil {
+		t.Skip("no timezone database:", err)
+	}
+	if tokyo, err = time.LoadLocation("Asia/Tokyo"); err != nil {
+		t.Skip("no timezone database:", err)
+	}
+	return
+}
+
+func TestParseWhen(t *testing.T) {
+
+	newYork, tokyo := locations(t)
+
+	// A wednesday morning
+	now := time.Date(2020, 5, 13, 10, 30, 0, 0, time.UTC)
+	at := func(loc *time.Location, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2020, month, day, hour, minute, 0, 0, loc)
+	}
+
+	tests := []struct {
+		words string
+		now   time.Time
+		want  time.Time
+		rest  string
+	}{
+		{"in 2h to go", now, now.Add(2 * time.Hour), "to go"},
+		{"in 1h30m", now, now.Add(90 * time.Minute), ""},
+		{"in 2 days that it works", now, now.Add(48 * time.Hour), "that it works"},
+		{"in 10 minutes", now, now.Add(10 * time.Minute), ""},
+		{"in 2 hours and 10 minutes", now, now.Add(130 * time.Minute), ""},
+		{"in 1w", now, now.Add(7 * 24 * time.Hour), ""},
+		{"at 5pm", now, at(time.UTC, 5, 13, 17, 0), ""},
+		{"at 14:30 deploy", now, at(time.UTC, 5, 13, 14, 30), "deploy"},
+		{"at 9 am", now, at(time.UTC, 5, 14, 9, 0), ""},
+		{"at 10", now, at(time.UTC, 5, 14, 10, 0), ""},
+		{"at noon", now, at(time.UTC, 5, 13, 12, 0), ""},
+		{"at midnight", now, at(time.UTC, 5, 14, 0, 0), ""},
+		{"12am", now, at(time.UTC, 5, 14, 0, 0), ""},
+		{"today 5pm", now, at(time.UTC, 5, 13, 17, 0), ""},
+		{"tomorrow 9am to call", now, at(time.UTC, 5, 14, 9, 0), "to call"},
+		{"tomorrow", now, at(time.UTC, 5, 14, 9, 0), ""},
+		{"tomorrow at 8", now, at(time.UTC, 5, 14, 8, 0), ""},
+		{"monday 10:00", now, at(time.UTC, 5, 18, 10, 0), ""},
+		{"on friday at 4pm", now, at(time.UTC, 5, 15, 16, 0), ""},
+		{"Wed 9am", now, at(time.UTC, 5, 20, 9, 0), ""},
+		{"wednesday 11am", now, at(time.UTC, 5, 13, 11, 0), ""},
+		{"2020-12-24 8pm gifts", now, at(time.UTC, 12, 24, 20, 0), "gifts"},
+		{"2020-12-24", now, at(time.UTC, 12, 24, 9, 0), ""},
+		// Evaluated in the timezone of the user
+		{"at 5pm", now.In(newYork), at(newYork, 5, 13, 17, 0), ""},
+		{"at 9am", now.In(newYork), at(newYork, 5, 13, 9, 0), ""},
+		{"at 9am", now.In(tokyo), at(tokyo, 5, 14, 9, 0), ""},
+		{"today 11pm", now.In(tokyo), at(tokyo, 5, 13, 23, 0), ""},
+		{"tomorrow 9am", now.In(tokyo), at(tokyo, 5, 14, 9, 0), ""},
+		{"in 2h", now.In(tokyo), now.Add(2 * time.Hour), ""},
+	}
+
+	for _, test := range tests {
+		got, rest, err := parseWhen(strings.Fields(test.words), test.now)
+		if err != nil {
+			t.Errorf("%q at %s: %v", test.words, test.now, err)
+			continue
+		}
+		if !got.Equal(test.want) || strings.Join(rest, " ") != test.rest {
+			t.Errorf("%q at %s: got %s and %q, want %s and %q", test.words, test.now, got, rest, test.want, test.rest)
+		}
+		if got.Location() != test.now.Location() {
+			t.Errorf("%q at %s: got location %s", test.words, test.now, got.Location())
+		}
+	}
+}
+
+func TestParseWhenErrors(t *testing.T) {
+
+	now := time.Date(2020, 5, 13, 10, 30, 0, 0, time.UTC)
+
+	tests := []string{
+		"",
+		"whenever",
+		"in",
+		"in soon",
+		"in 0h",
+		"in 2 lightyears",
+		"at 13pm",
+		"at 25:00",
+		"at 10:75",
+		"today 9am",
+		"2020-01-01 9am",
+		"2020-13-01",
+		"10",
+	}
+
+	for _, words := range tests {
+		if got, _, err := parseWhen(strings.Fields(words), now); err == nil {
+			t.Errorf("%q: parsed as %s", words, got)
+		}
+	}
+}
+
+func TestLocation(t *testing.T) {
+
+	newYork, _ := locations(t)
+	h := &reminders{log: zap.NewNop()}
+
+	tests := []struct {
+		tz   string
+		want *time.Location
+	}{
+		{"America/New_York", newYork},
+		{"", time.Local},
+		{"Nowhere/Atlantis", time.Local},
+	}
+
+	for _, test := range tests {
+		if got := h.location(slack.User{TZ: test.tz}); got.String() != test.want.String() {
+			t.Errorf("%q: got %s, want %s", test.tz, got, test.want)
+		}
+	}
+}
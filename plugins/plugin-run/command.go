@@ -9,9 +9,7 @@ import (
 	"time"
 
 	"github.com/CyrilPeponnet/slackhal/plugin"
-	"github.com/fsnotify/fsnotify"
 	"github.com/slack-go/slack"
-	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
@@ -21,7 +19,8 @@ type run struct {
 	bot           *plugin.Bot
 	sink          chan<- *plugin.SlackResponse
 	commands      []command
-	configuration *viper.Viper
+	configuration *plugin.Config
+	log           *zap.Logger
 }
 
 // Repository struct
@@ -33,13 +32,8 @@ type command struct {
 
 func (h *run) ReloadConfiguration() {
 
-	err := h.configuration.ReadInConfig()
-	if err != nil {
-		zap.L().Error("Not able to read configuration for run plugin.", zap.Error(err))
-	} else {
-		if err := h.configuration.UnmarshalKey("Commands", &h.commands); err != nil {
-			zap.L().Error("Error while reading configuration", zap.Error(err))
-		}
+	if err := h.configuration.UnmarshalKey("Commands", &h.commands); err != nil {
+		h.log.Error("Error while reading configuration", zap.Error(err))
 	}
 
 	// Repopulate our triggers
@@ -54,27 +48,26 @@ func (h *run) ReloadConfiguration() {
 	}
 }
 
+// Reload interface implementation
+func (h *run) Reload() error {
+	return h.configuration.Reload()
+}
+
 // Init interface implementation if you need to init things
 // When the bot is starting.
 func (h *run) Init(output chan<- *plugin.SlackResponse, bot *plugin.Bot) {
 
 	h.bot = bot
 	h.sink = output
-	h.configuration = viper.New()
-
-	// Read the configuration
-	h.configuration.AddConfigPath("/etc/slackhal/")
-	h.configuration.AddConfigPath("$HOME/.slackhal")
-	h.configuration.AddConfigPath(".")
-	h.configuration.SetConfigName("plugin-run")
-	h.configuration.SetConfigType("yaml")
+	h.log = bot.Logger("run")
+	h.configuration = bot.Config("run")
 	h.ReloadConfiguration()
 
 	// Handle live reload
-	h.configuration.WatchConfig()
-	h.configuration.OnConfigChange(func(e fsnotify.Event) {
-		zap.L().Info("Reloading commands configuration file.")
+	h.configuration.OnChange(func(c *plugin.Config) error {
+		h.log.Info("Reloading commands configuration file.")
 		h.ReloadConfiguration()
+		return nil
 	})
 }
 
@@ -102,20 +95,16 @@ func (h *run) processCommand(message slack.Msg, cmd command, args []string, user
 		command = cmd.Name
 	}
 
-	r := new(plugin.SlackResponse)
-	r.Channel = message.Channel
-	r.Options = append(r.Options, slack.MsgOptionText("thinking...", true), slack.MsgOptionMeMessage())
 	// ACK the order while processing
-	h.sink <- r
+	h.react(message, plugin.ModeAddReaction, "hourglass")
 
-	// Reset the message
-	r = new(plugin.SlackResponse)
+	r := new(plugin.SlackResponse)
 	r.Channel = message.Channel
 
 	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
 	defer cancel()
 
-	zap.L().Debug("Run command", zap.String("command", command), zap.Strings("args", args))
+	h.log.Debug("Run command", zap.String("command", command), zap.Strings("args", args))
 	c := exec.CommandContext(ctx, command, args...)
 
 	// Set some en var for scripts
@@ -125,47 +114,45 @@ func (h *run) processCommand(message slack.Msg, cmd command, args []string, user
 	}...)
 
 	t, err := c.CombinedOutput()
+
+	// Mark the order with its status
+	h.react(message, plugin.ModeRemoveReaction, "hourglass")
+	if err != nil {
+		h.react(message, plugin.ModeAddReaction, "x")
+	} else {
+		h.react(message, plugin.ModeAddReaction, "white_check_mark")
+	}
+
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
-			msg = fmt.Sprintf("Command `%s %s` timed out and got killed.", cmd.Name, strings.Join(cargs, " "))
+			msg = h.bot.PluginT(h.Name, message.User, "Command `%s %s` timed out and got killed.", cmd.Name, strings.Join(cargs, " "))
 		} else {
-			msg = fmt.Sprintf("Command `%s %s` failed with error: `%s`.\n Output: ```%s```", cmd.Name, strings.Join(cargs, " "), err, t)
+			msg = h.bot.PluginT(h.Name, message.User, "Command `%s %s` failed with error: `%s`.\n Output: ```%s```", cmd.Name, strings.Join(cargs, " "), err, t)
 		}
 	} else {
 		if len(t) == 0 {
-			msg = "This is done."
+			msg = h.bot.PluginT(h.Name, message.User, "This is done.")
 		} else {
-			msg = "Here you go: \n```" + string(t) + "```"
+			msg = h.bot.PluginT(h.Name, message.User, "Here you go: \n```%s```", t)
 		}
 	}
 
-	// Upload as file if too big
-	if len(msg) > 10000 {
-
-		f := slack.FileUploadParameters{
-			Filename: "output.txt",
-			Content:  msg,
-			Title:    message.Text,
-			Channels: []string{message.Channel},
-		}
-
-		_, err := h.bot.RTM.UploadFile(f)
-		if err != nil {
-
-			zap.L().Error("Failed to upload file", zap.Error(err))
-			r.Options = []slack.MsgOption{slack.MsgOptionText("Uhoh, something went wrong while uploading the file.", false)}
-
-		} else {
-
-			r.Options = []slack.MsgOption{slack.MsgOptionText("Alright, you can see the output above.", false)}
-
-		}
-
-	} else {
+	// Long outputs are split or uploaded as a file by the bot
+	r.Options = []slack.MsgOption{slack.MsgOptionText(msg, false)}
+	h.sink <- r
+}
 
-		r.Options = []slack.MsgOption{slack.MsgOptionText(msg, false)}
+// react add or remove a reaction on the message of a command
+func (h *run) react(message slack.Msg, mode plugin.DeliveryMode, reaction string) {
+	// Commands dispatched by the bot have no message to react to
+	if message.Timestamp == "" {
+		return
 	}
-	// Update the previous message
+	r := new(plugin.SlackResponse)
+	r.Channel = message.Channel
+	r.Mode = mode
+	r.Timestamp = message.Timestamp
+	r.Reaction = reaction
 	h.sink <- r
 }
 
@@ -204,12 +191,16 @@ func (h *run) ProcessMessage(cmd string, message slack.Msg) bool {
 	return false
 }
 
-// init function that will register your plugin to the plugin manager
-func init() {
-
+// newRun return a new run plugin
+func newRun() *run {
 	runner := new(run)
 	runner.Metadata = plugin.NewMetadata("Runner")
 	runner.Description = "Run commands."
 	runner.ActiveTriggers = []plugin.Command{{Name: `run`, ShortDescription: "Run a command.", LongDescription: "Run commands."}}
-	plugin.PluginManager.Register(runner)
+	return runner
+}
+
+// init function that will register your plugin to the plugin manager
+func init() {
+	plugin.PluginManager.Register(newRun())
 }
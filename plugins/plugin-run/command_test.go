@@ -0,0 +1,74 @@
+package runplugin
+
+import (
+	"testing"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/CyrilPeponnet/slackhal/plugin/plugintest"
+	"github.com/slack-go/slack"
+)
+
+const commands = `
+Commands:
+- Name: hello
+  Description: Say hello
+  Command: [echo, hello]
+- Name: whoami
+  Command: [sh, -c, 'echo I am $USER']
+- Name: "true"
+- Name: fail
+  Command: [sh, -c, 'echo oops; exit 3']
+`
+
+func TestRun(t *testing.T) {
+
+	b := plugintest.NewBot(t)
+	b.AddUser(slack.User{ID: "U1", Name: "dave"})
+	b.WriteConfig("run", commands)
+	h := newRun()
+	b.Load(h)
+
+	if len(h.ActiveTriggers) != 4 || h.ActiveTriggers[0].Name != "hello" || h.ActiveTriggers[0].ShortDescription != "Say hello" {
+		t.Errorf("got triggers %+v", h.ActiveTriggers)
+	}
+
+	tests := []struct {
+		text string
+		// Expected reply, none if empty, and the reaction marking the command
+		want     string
+		reaction string
+	}{
+		{"!hello world", "Here you go: \n```hello world\n```", "white_check_mark"},
+		{"!whoami", "I am dave", "white_check_mark"},
+		{"!true", "This is done.", "white_check_mark"},
+		{"!fail now", "Command `fail now` failed with error: `exit status 3`.\n Output: ```oops\n```", "x"},
+		{"!run ls", "", ""},
+	}
+
+	for _, test := range tests {
+		message := plugintest.ChannelMessage("C1", "U1", test.text)
+		responses := b.Dispatch(message)
+		if test.want == "" {
+			responses.AssertEmpty(t)
+			continue
+		}
+
+		// Marked while running, then with the status, then the reply
+		modes := []plugin.DeliveryMode{plugin.ModeAddReaction, plugin.ModeRemoveReaction, plugin.ModeAddReaction, plugin.ModePost}
+		reactions := []string{"hourglass", "hourglass", test.reaction, ""}
+		responses.AssertCount(t, len(modes))
+		for i, r := range responses {
+			if i >= len(modes) {
+				break
+			}
+			if r.Mode != modes[i] || r.Reaction != reactions[i] {
+				t.Errorf("%s: response %d is %d %q, want %d %q", test.text, i, r.Mode, r.Reaction, modes[i], reactions[i])
+			}
+			if r.Mode != plugin.ModePost && r.Timestamp != message.Timestamp {
+				t.Errorf("%s: reaction on %s, want %s", test.text, r.Timestamp, message.Timestamp)
+			}
+		}
+		responses.AssertText(t, test.want)
+		responses.AssertChannel(t, "C1")
+	}
+}
@@ -1,14 +1,14 @@
 package main
 
 import (
-	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
-	"text/template"
 
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/CyrilPeponnet/slackhal/plugin/blocks"
 	"github.com/slack-go/slack"
 	"go.uber.org/zap"
 )
@@ -38,13 +38,83 @@ A permission is a trigger (for instance *cat*).
 When binding identity to role:
 - <kind> can be either user, channel, memberOf or a slack Custom Field Name
 - <value> is the value of the user, channel or channel appartenance or slack Custom Field value
+- the user *all* match everyone and the user *scheduler* match the scheduled commands
 
 Special permission:
 - *: mean everything
 `
 
-// AuthzHandleChat handle the chat messages
-func AuthzHandleChat(msg *slack.MessageEvent) (response string) {
+// AuthzHandleChat handle the chat messages and return the options of the response if any
+func AuthzHandleChat(msg *slack.MessageEvent) []slack.MsgOption {
+
+	// The listing is rendered with blocks
+	if strings.HasPrefix(strings.ToLower(msg.Msg.Text), "rbac-list") {
+		return rbacList(msg).Options()
+	}
+
+	if response := authzHandleChatText(msg); response != "" {
+		return []slack.MsgOption{slack.MsgOptionText(response, false)}
+	}
+
+	return nil
+}
+
+// rbacList list the roles permission and bindings
+func rbacList(msg *slack.MessageEvent) *blocks.Message {
+
+	if !authz.IsGranted("rbac", msg.User, msg.Channel, "") {
+		user, _ := bot.GetCachedUserInfos(msg.User)
+		return blocks.New().Section(bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", user.RealName))
+	}
+
+	data := authz.Dump()
+	if data == nil {
+		return blocks.New().Section(bot.T(msg.User, "No RBAC set yet, start with behave keyword."))
+	}
+
+	m := blocks.New()
+
+	if len(data.Bindings) > 0 {
+		// Mentions are not rendered in code blocks so bindings are fields
+		fields := []string{}
+		for _, b := range data.Bindings {
+			identity := b.Name
+			switch b.Kind {
+			case "user":
+				identity = fmt.Sprintf("<@%s>", b.Name)
+			case "channel", "memberOf":
+				identity = fmt.Sprintf("<#%s>", b.Name)
+			}
+			fields = append(fields, fmt.Sprintf("_%s=%s_: %s", b.Kind, identity, strings.Join(b.Roles, ", ")))
+		}
+		m.Section(bot.T(msg.User, "*Role bindings*")).Fields(fields...)
+	}
+
+	if len(data.Roles) > 0 {
+		rows := [][]string{}
+		for _, r := range data.Roles {
+			perms := []string{}
+			for _, p := range r.Permissions {
+				perms = append(perms, p.Name)
+			}
+			rows = append(rows, []string{r.Name, r.Description, strings.Join(r.Parents, ","), strings.Join(perms, ",")})
+		}
+		m.Divider().Section(bot.T(msg.User, "*Roles*")).Table([]string{bot.T(msg.User, "NAME"), bot.T(msg.User, "DESCRIPTION"), bot.T(msg.User, "PARENTS"), bot.T(msg.User, "PERMISSIONS")}, rows)
+	}
+
+	if len(data.Permissions) > 0 {
+		rows := [][]string{}
+		for _, p := range data.Permissions {
+			rows = append(rows, []string{p.Name, p.Description})
+		}
+		m.Divider().Section(bot.T(msg.User, "*Permissions*")).Table([]string{bot.T(msg.User, "NAME"), bot.T(msg.User, "DESCRIPTION")}, rows)
+	}
+
+	return m
+}
+
+// authzHandleChatText handle the chat messages answered with a simple text
+func authzHandleChatText(msg *slack.MessageEvent) (response string) {
 
 	txt := strings.ToLower(msg.Msg.Text)
 
@@ -56,7 +126,7 @@ func AuthzHandleChat(msg *slack.MessageEvent) (response string) {
 
 	switch {
 	case strings.HasPrefix(txt, "rbac-help"):
-		return help
+		return bot.T(msg.User, help)
 
 	case strings.HasPrefix(txt, "rbac-add-role"):
 		if authz.IsGranted("rbac", msg.User, msg.Channel, "") {
@@ -72,30 +142,30 @@ func AuthzHandleChat(msg *slack.MessageEvent) (response string) {
 			aRole := strings.SplitN(content[0], " ", 2)
 
 			if len(aRole) != 2 {
-				return "Invalid syntax" + help
+				return bot.T(msg.User, "Invalid syntax") + " " + bot.T(msg.User, help)
 			}
 
 			err := authz.AddRole(aRole[0], aRole[1], parents...)
 			if err != nil {
-				return err.Error()
+				return bot.T(msg.User, "Failed to create the role %s: %v", aRole[0], err)
 			}
-			return "Role " + aRole[0] + " created."
+			return bot.T(msg.User, "Role %s created.", aRole[0])
 		}
-		return fmt.Sprintf("I'm sorry, %s I'm afraid I can't do that.", user.RealName)
+		return bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", user.RealName)
 
 	case strings.HasPrefix(txt, "rbac-del-role"):
 		if authz.IsGranted("rbac", msg.User, msg.Channel, "") {
 			line := strings.TrimSpace(strings.Replace(msg.Text, "rbac-del-role ", "", 1))
 			if line == "" {
-				return "Please provide a role name."
+				return bot.T(msg.User, "Please provide a role name.")
 			}
 			err := authz.RemoveRole(line)
 			if err != nil {
-				return line + " " + err.Error()
+				return bot.T(msg.User, "Failed to remove the role %s: %v", line, err)
 			}
-			return "Role " + line + " has been removed."
+			return bot.T(msg.User, "Role %s has been removed.", line)
 		}
-		return fmt.Sprintf("I'm sorry, %s I'm afraid I can't do that.", user.RealName)
+		return bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", user.RealName)
 
 	case strings.HasPrefix(txt, "rbac-add-permission"):
 		if authz.IsGranted("rbac", msg.User, msg.Channel, "") {
@@ -104,89 +174,89 @@ func AuthzHandleChat(msg *slack.MessageEvent) (response string) {
 			aPerm := strings.SplitN(line, " ", 2)
 
 			if len(aPerm) != 2 {
-				return "Invalid syntax" + help
+				return bot.T(msg.User, "Invalid syntax") + " " + bot.T(msg.User, help)
 			}
 
 			err := authz.AddPermission(aPerm[0], aPerm[1])
 			if err != nil {
-				return err.Error()
+				return bot.T(msg.User, "Failed to create the permission %s: %v", aPerm[0], err)
 			}
-			return "Permission " + aPerm[0] + " created."
+			return bot.T(msg.User, "Permission %s created.", aPerm[0])
 		}
-		return fmt.Sprintf("I'm sorry, %s I'm afraid I can't do that.", user.RealName)
+		return bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", user.RealName)
 
 	case strings.HasPrefix(txt, "rbac-del-permission"):
 		if authz.IsGranted("rbac", msg.User, msg.Channel, "") {
 
 			line := strings.TrimSpace(strings.Replace(msg.Text, "rbac-del-permission ", "", 1))
 			if line == "" {
-				return "Please provide a permission name."
+				return bot.T(msg.User, "Please provide a permission name.")
 			}
 			err := authz.RemovePermission(line)
 			if err != nil {
-				return line + " " + err.Error()
+				return bot.T(msg.User, "Failed to remove the permission %s: %v", line, err)
 			}
-			return "Permission " + line + " has been removed."
+			return bot.T(msg.User, "Permission %s has been removed.", line)
 		}
-		return fmt.Sprintf("I'm sorry, %s I'm afraid I can't do that.", user.RealName)
+		return bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", user.RealName)
 
 	case strings.HasPrefix(txt, "rbac-attach-permission"):
 		if authz.IsGranted("rbac", msg.User, msg.Channel, "") {
 			line := strings.TrimSpace(strings.Replace(msg.Text, "rbac-attach-permission ", "", 1))
 			parts := strings.Split(line, " to ")
 			if len(parts) != 2 {
-				return "Invalid syntax " + help
+				return bot.T(msg.User, "Invalid syntax") + " " + bot.T(msg.User, help)
 			}
 
 			perms := strings.Split(parts[0], ",")
 			roles := strings.Split(parts[1], ",")
 
 			if len(perms) == 0 || len(roles) == 0 {
-				return "Invalid syntax " + help
+				return bot.T(msg.User, "Invalid syntax") + " " + bot.T(msg.User, help)
 			}
 
 			for _, r := range roles {
 				for _, p := range perms {
 					err := authz.AttachPermission(strings.TrimSpace(p), strings.TrimSpace(r))
 					if err != nil {
-						return "Failed to attach " + p + " to role " + r + " error: " + err.Error()
+						return bot.T(msg.User, "Failed to attach %s to role %s: %v", p, r, err)
 					}
 				}
 			}
 
-			return "Permissions " + strings.Join(perms, ",") + " attached to " + strings.Join(roles, ",") + "."
+			return bot.T(msg.User, "Permissions %s attached to %s.", strings.Join(perms, ","), strings.Join(roles, ","))
 
 		}
-		return fmt.Sprintf("I'm sorry, %s I'm afraid I can't do that.", user.RealName)
+		return bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", user.RealName)
 
 	case strings.HasPrefix(txt, "rbac-dettach-permission"):
 		if authz.IsGranted("rbac", msg.User, msg.Channel, "") {
 			line := strings.TrimSpace(strings.Replace(msg.Text, "rbac-dettach-permission ", "", 1))
 			parts := strings.Split(line, " from ")
 			if len(parts) != 2 {
-				return "Invalid syntax " + help
+				return bot.T(msg.User, "Invalid syntax") + " " + bot.T(msg.User, help)
 			}
 
 			perms := strings.Split(parts[0], ",")
 			roles := strings.Split(parts[1], ",")
 
 			if len(perms) == 0 || len(roles) == 0 {
-				return "Invalid syntax " + help
+				return bot.T(msg.User, "Invalid syntax") + " " + bot.T(msg.User, help)
 			}
 
 			for _, r := range roles {
 				for _, p := range perms {
 					err := authz.DettachPermission(strings.TrimSpace(p), strings.TrimSpace(r))
 					if err != nil {
-						return "Failed to dettach " + p + " from role " + r
+						return bot.T(msg.User, "Failed to dettach %s from role %s: %v", p, r, err)
 					}
 				}
 			}
 
-			return "Permissions " + strings.Join(perms, ",") + " dettached from " + strings.Join(roles, ",") + "."
+			return bot.T(msg.User, "Permissions %s dettached from %s.", strings.Join(perms, ","), strings.Join(roles, ","))
 
 		}
-		return fmt.Sprintf("I'm sorry, %s I'm afraid I can't do that.", user.RealName)
+		return bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", user.RealName)
 
 	case strings.HasPrefix(txt, "rbac-bind"):
 		if authz.IsGranted("rbac", msg.User, msg.Channel, "") {
@@ -194,38 +264,38 @@ func AuthzHandleChat(msg *slack.MessageEvent) (response string) {
 
 			parts := strings.Split(line, " to ")
 			if len(parts) != 2 {
-				return "Invalid syntax " + help
+				return bot.T(msg.User, "Invalid syntax") + " " + bot.T(msg.User, help)
 			}
 
 			features := strings.SplitN(parts[0], " ", 2)
 			roles := strings.Split(parts[1], ",")
 
 			if len(features) != 2 || len(roles) == 0 {
-				return "Invalid feature " + help
+				return bot.T(msg.User, "Invalid feature") + " " + bot.T(msg.User, help)
 			}
 
 			kind := strings.TrimSpace(features[0])
 			value := strings.TrimSpace(features[1])
 
 			ID := value
-			if value != "all" {
+			if value != "all" && value != plugin.SchedulerIdentity {
 				// Extract feature from value
 				f := bot.ExtractFeaturesFromMessage(value)
 				if len(f) == 0 || len(f) > 1 {
-					return "Failed to determine the value for kind " + kind
+					return bot.T(msg.User, "Failed to determine the value for kind %s", kind)
 				}
 				ID = f[0].ID
 			}
 
 			err := authz.BindToRole(kind, ID, roles...)
 			if err != nil {
-				return "Failed to bind " + kind + ": " + value + " to " + strings.Join(roles, ",")
+				return bot.T(msg.User, "Failed to bind %s: %s to %s: %v", kind, value, strings.Join(roles, ","), err)
 			}
 
-			return "Feature " + kind + ": " + value + " binded to " + strings.Join(roles, ",") + "."
+			return bot.T(msg.User, "Feature %s: %s binded to %s.", kind, value, strings.Join(roles, ","))
 
 		}
-		return fmt.Sprintf("I'm sorry, %s I'm afraid I can't do that.", user.RealName)
+		return bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", user.RealName)
 
 	case strings.HasPrefix(txt, "rbac-unbind"):
 		if authz.IsGranted("rbac", msg.User, msg.Channel, "") {
@@ -233,14 +303,14 @@ func AuthzHandleChat(msg *slack.MessageEvent) (response string) {
 
 			parts := strings.Split(line, " from ")
 			if len(parts) != 2 {
-				return "Invalid syntax " + help
+				return bot.T(msg.User, "Invalid syntax") + " " + bot.T(msg.User, help)
 			}
 
 			value := strings.TrimSpace(parts[0])
 			roles := strings.Split(parts[1], ",")
 
 			if value == "" || len(roles) == 0 {
-				return "Invalid syntax " + help
+				return bot.T(msg.User, "Invalid syntax") + " " + bot.T(msg.User, help)
 			}
 
 			// Extract feature from value
@@ -248,7 +318,7 @@ func AuthzHandleChat(msg *slack.MessageEvent) (response string) {
 			if value != "all" {
 				f := bot.ExtractFeaturesFromMessage(value)
 				if len(f) == 0 || len(f) > 1 {
-					return "Failed to determine the feature of the value"
+					return bot.T(msg.User, "Failed to determine the feature of the value")
 				}
 				ID = f[0].ID
 			}
@@ -256,14 +326,14 @@ func AuthzHandleChat(msg *slack.MessageEvent) (response string) {
 			for _, r := range roles {
 				err := authz.UnBindFromRole(ID, r)
 				if err != nil {
-					return "Failed to unbind " + ": " + value + " from " + r
+					return bot.T(msg.User, "Failed to unbind %s from %s: %v", value, r, err)
 				}
 			}
 
-			return "Feature with value " + value + " unbinded from " + strings.Join(roles, ",") + "."
+			return bot.T(msg.User, "Feature with value %s unbinded from %s.", value, strings.Join(roles, ","))
 
 		}
-		return fmt.Sprintf("I'm sorry, %s I'm afraid I can't do that.", user.RealName)
+		return bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", user.RealName)
 
 	case strings.HasPrefix(txt, "rbac-inspect-indenity"):
 		if authz.IsGranted("rbac", msg.User, msg.Channel, "") {
@@ -271,18 +341,18 @@ func AuthzHandleChat(msg *slack.MessageEvent) (response string) {
 
 			name := strings.TrimSpace(line)
 			if name == "" {
-				return "Please provide a user name."
+				return bot.T(msg.User, "Please provide a user name.")
 			}
 			data := ""
 			re := regexp.MustCompile(`<@(\S+)>`)
 			for _, m := range re.FindAllStringSubmatch(line, -1) {
 				user, err := bot.GetCachedUserInfos(m[1])
 				if err != nil {
-					return "Unable to get user information " + err.Error()
+					return bot.T(msg.User, "Unable to get user information: %v", err)
 				}
 				pjson, err := json.MarshalIndent(user.Profile, "", "    ")
 				if err != nil {
-					return "Unable to decode profile structure " + err.Error()
+					return bot.T(msg.User, "Unable to decode profile structure: %v", err)
 				}
 				data += string(pjson)
 			}
@@ -290,7 +360,7 @@ func AuthzHandleChat(msg *slack.MessageEvent) (response string) {
 			return data
 
 		}
-		return fmt.Sprintf("I'm sorry, %s I'm afraid I can't do that.", user.RealName)
+		return bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", user.RealName)
 
 	case strings.HasPrefix(txt, "rbac-dump"):
 		if authz.IsGranted("rbac", msg.User, msg.Channel, "") {
@@ -298,12 +368,12 @@ func AuthzHandleChat(msg *slack.MessageEvent) (response string) {
 			data := authz.Dump()
 			pjson, err := json.MarshalIndent(data, "", "    ")
 			if err != nil {
-				return "Failed to marshal current data " + err.Error()
+				return bot.T(msg.User, "Failed to marshal current data: %v", err)
 			}
 			return string(pjson)
 
 		}
-		return fmt.Sprintf("I'm sorry, %s I'm afraid I can't do that.", user.RealName)
+		return bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", user.RealName)
 
 	case strings.HasPrefix(txt, "rbac-load"):
 		if authz.IsGranted("rbac", msg.User, msg.Channel, "") {
@@ -313,103 +383,49 @@ func AuthzHandleChat(msg *slack.MessageEvent) (response string) {
 			// We should use a snipet or a file
 			data, err := base64.StdEncoding.DecodeString(line)
 			if err != nil {
-				return
+				return bot.T(msg.User, "Invalid data: %v", err)
 			}
 			err = authz.Load(data)
 			if err != nil {
-				return "Failed to load data " + err.Error()
+				return bot.T(msg.User, "Failed to load data: %v", err)
 			}
 
-			return "Data successfully loaded."
-
-		}
-		return fmt.Sprintf("I'm sorry, %s I'm afraid I can't do that.", user.RealName)
-
-	// List the roles permission and bindings
-	case strings.HasPrefix(txt, "rbac-list"):
-		if authz.IsGranted("rbac", msg.User, msg.Channel, "") {
-
-			tpl := `
-  {{ if .Bindings }}
-  *Role bindings*:
-  {{- range .Bindings}}
-  {{- if eq .Kind "user" }}
-  - _{{.Kind}}=<@{{.Name}}>_: {{.Roles}}
-  {{- end }}
-  {{- if eq .Kind "channel" }}
-  - _{{.Kind}}=<#{{.Name}}>_: {{.Roles}}
-  {{- end }}
-  {{- if eq .Kind "memberOf" }}
-  - _{{.Kind}}=<#{{.Name}}>_: {{.Roles}}
-  {{- end }}
-  {{- end}}
-  {{- end}}
-
-  {{ if .Roles }}
-  *Roles*:
-  {{- range .Roles}}
-  - _{{.Name}}_: {{.Description}}{{if .Parents}}, Parents:{{.Parents}}{{end}}{{if .Permissions}}, Permissions: {{range .Permissions}}{{.Name}} {{end}}{{end}}
-  {{- end}}
-  {{- end}}
-
-  {{ if .Permissions }}
-  *Permissions*:
-  {{- range .Permissions}}
-  - {{.Name}}: {{.Description}}
-  {{- end}}
-  {{- end}}
-  `
-
-			data := authz.Dump()
-			if data != nil {
-
-				t, err := template.New("output").Parse(tpl)
-				if err != nil {
-					return ""
-				}
-				buf := new(bytes.Buffer)
-				err = t.Execute(buf, data)
-				if err != nil {
-					return ""
-				}
+			return bot.T(msg.User, "Data successfully loaded.")
 
-				return buf.String()
-			}
-			return "No RBAC set yet, start with behave keyword."
 		}
-		return fmt.Sprintf("I'm sorry, %s I'm afraid I can't do that.", user.RealName)
+		return bot.T(msg.User, "I'm sorry, %s I'm afraid I can't do that.", user.RealName)
 
 	case strings.HasPrefix(txt, "behave"):
 		if authz.IsGranted("rbac", msg.User, msg.Channel, "") {
 			err := authz.AddPermission("*", "Can do everything")
 			if err != nil {
-				return fmt.Sprintf("Error while creating the permission: %s", err.Error())
+				return bot.T(msg.User, "Error while creating the permission: %v", err)
 			}
 			err = authz.AddPermission("rbac", "Can manage rbac")
 			if err != nil {
-				return fmt.Sprintf("Error while creating the permission: %s", err.Error())
+				return bot.T(msg.User, "Error while creating the permission: %v", err)
 			}
 			err = authz.AddRole("rbac", "RBAC management role")
 			if err != nil {
-				return fmt.Sprintf("Error while creating the rbac role: %s", err.Error())
+				return bot.T(msg.User, "Error while creating the rbac role: %v", err)
 			}
 			err = authz.AddRole("owner", "Owner role")
 			if err != nil {
-				return fmt.Sprintf("Error while creating the owner role: %s", err.Error())
+				return bot.T(msg.User, "Error while creating the owner role: %v", err)
 			}
 			err = authz.AttachPermission("*", "owner")
 			if err != nil {
-				return fmt.Sprintf("Error while attaching the permission rbac to the rbac role: %s", err.Error())
+				return bot.T(msg.User, "Error while attaching the permission rbac to the rbac role: %v", err)
 			}
 			err = authz.AttachPermission("rbac", "rbac")
 			if err != nil {
-				return fmt.Sprintf("Error while attaching the permission rbac to the rbac role: %s", err.Error())
+				return bot.T(msg.User, "Error while attaching the permission rbac to the rbac role: %v", err)
 			}
 			err = authz.BindToRole("user", user.ID, "owner")
 			if err != nil {
-				return fmt.Sprintf("Error while creating the binding to owner role: %s", err.Error())
+				return bot.T(msg.User, "Error while creating the binding to owner role: %v", err)
 			}
-			return "You are the boss now."
+			return bot.T(msg.User, "You are the boss now.")
 		}
 	}
 
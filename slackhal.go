@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
 
 	"go.uber.org/zap"
 
@@ -19,6 +21,7 @@ import (
 	_ "github.com/CyrilPeponnet/slackhal/plugins/plugin-facts"
 	// _ "github.com/CyrilPeponnet/slackhal/plugins/plugin-github"
 	// _ "github.com/CyrilPeponnet/slackhal/plugins/plugin-jira"
+	_ "github.com/CyrilPeponnet/slackhal/plugins/plugin-reminders"
 	_ "github.com/CyrilPeponnet/slackhal/plugins/plugin-run"
 )
 
@@ -28,6 +31,11 @@ var authz authorizer.Authorizer
 
 var defaultAnswers = []string{"Sorry, I'm not sure what you mean by that."}
 
+var bots *botFilter
+
+// httpClient is used for the slack API calls
+var httpClient = http.DefaultClient
+
 func main() {
 
 	headline := "Slack HAL bot."
@@ -45,6 +53,7 @@ Options:
 	--http-handler-port port The Port of the http handler [default: :8080].
 	--log-level level        Set the log level [default: error].
 	--log-format format      Set the log format [default: console].
+	--dry-run                Record the responses instead of sending them.
 `
 	color.Blue(` __ _            _                _
 / _\ | ____  ___| | __ /\  /\____| |
@@ -58,6 +67,20 @@ _\ \ | (_| | (__|   </ __  / (_| | |
 	args, _ := docopt.ParseDoc(headline + usage)
 	disabledPlugins := []string{}
 
+	viper.SetDefault("bot.dataDir", "$HOME/.slackhal")
+	viper.SetDefault("bot.pluginTimeout", "5m")
+	viper.SetDefault("bot.plugins.maxFailures", 3)
+	viper.SetDefault("bot.plugins.failureWindow", "1h")
+	viper.SetDefault("bot.plugins.directories", []string{"$HOME/.slackhal/plugins"})
+	viper.SetDefault("bot.plugins.remoteRefresh", "5m")
+	viper.SetDefault("bot.delivery.interval", "1s")
+	viper.SetDefault("bot.delivery.maxRetries", 5)
+	viper.SetDefault("bot.delivery.maxLength", 4000)
+	viper.SetDefault("bot.delivery.snippetThreshold", 12000)
+	viper.SetDefault("bot.bots.maxMessages", 5)
+	viper.SetDefault("bot.bots.window", "1m")
+	viper.SetDefault("bot.i18n.catalogs", "$HOME/.slackhal/i18n")
+
 	// Load configuration file and override some args if needed.
 
 	if args["--file"] != nil {
@@ -81,20 +104,77 @@ _\ \ | (_| | (__|   </ __  / (_| | |
 		disabledPlugins = viper.GetStringSlice("bot.plugins.disabled")
 	}
 
-	logutils.ConfigureWithOptions(viper.GetString("bot.log.level"), viper.GetString("bot.log.format"), "", false, false)
+	bot.ConfigFile = viper.ConfigFileUsed()
+
+	logConfig := logutils.ConfigureWithOptions(viper.GetString("bot.log.level"), viper.GetString("bot.log.format"), "", false, false)
+
+	// Levels of the plugins loggers, the passive triggers default to the level of their plugin
+	logLevels := map[string]struct{ Level, Passive string }{}
+	if err := viper.UnmarshalKey("bot.log.plugins", &logLevels); err != nil {
+		zap.L().Fatal("Cannot read the plugins log levels", zap.Error(err))
+	}
+	pluginLevels := map[string]string{}
+	for name, l := range logLevels {
+		if l.Level != "" {
+			pluginLevels[name] = l.Level
+		}
+		if l.Passive != "" {
+			pluginLevels[name+plugin.PassiveSuffix] = l.Passive
+		}
+	}
+	if err := bot.Loggers.Init(logConfig, pluginLevels); err != nil {
+		zap.L().Fatal("Cannot initialize the plugins loggers", zap.Error(err))
+	}
 
 	// Connect to slack and start runloop
 	if viper.GetString("bot.token") == "nil" {
 		zap.L().Fatal("You need to set the slack bot token!")
 	}
 
+	// The databases are kept in the data directory unless set
+	dataDir := os.ExpandEnv(viper.GetString("bot.dataDir"))
+
+	// In dry-run mode the bot keeps its state in its own data directory so it
+	// does not write to nor wait on the databases of the live bot
+	dryRun := args["--dry-run"] == true || viper.GetBool("bot.dryRun.enabled")
+	if dryRun {
+		viper.SetDefault("bot.dryRun.dataDir", filepath.Join(dataDir, "dryrun"))
+		live := dataDir
+		dataDir = os.ExpandEnv(viper.GetString("bot.dryRun.dataDir"))
+		if err := os.MkdirAll(dataDir, 0700); err != nil {
+			zap.L().Fatal("Cannot create the dry-run data directory", zap.Error(err))
+		}
+		if err := seedDryRun(live, dataDir); err != nil {
+			zap.L().Fatal("Cannot copy the live databases to the dry-run data directory", zap.Error(err))
+		}
+		for _, key := range []string{"bot.scheduler.database", "bot.plugins.database", "bot.i18n.database", "bot.tracker.database"} {
+			if viper.IsSet(key) {
+				viper.Set(key, filepath.Join(dataDir, filepath.Base(os.ExpandEnv(viper.GetString(key)))))
+			}
+		}
+	}
+
+	bot.DataDir = dataDir
+	viper.SetDefault("bot.scheduler.database", filepath.Join(dataDir, "scheduler.db"))
+	viper.SetDefault("bot.plugins.database", filepath.Join(dataDir, "plugins.db"))
+	viper.SetDefault("bot.i18n.database", filepath.Join(dataDir, "i18n.db"))
+
 	// Init our authorizer
-	err := authz.Init(os.ExpandEnv("$HOME/.slackhal/authz.db"))
+	err := authz.Init(filepath.Join(dataDir, "authz.db"))
 	if err != nil {
 		zap.L().Fatal("Cannot initialize the authorizer", zap.Error(err))
 	}
 
-	bot.API = slack.New(viper.GetString("bot.token"))
+	// Init the filter of messages from other bots
+	bots = newBotFilter(viper.GetStringSlice("bot.bots.allowed"), viper.GetInt("bot.bots.maxMessages"), viper.GetDuration("bot.bots.window"))
+
+	// In dry-run mode the calls with side effects are recorded instead of being sent
+	if dryRun {
+		zap.L().Warn("Dry-run mode, responses will not be sent to slack", zap.String("output", viper.GetString("bot.dryRun.output")), zap.String("dataDir", dataDir))
+		httpClient = newDryRunClient(os.ExpandEnv(viper.GetString("bot.dryRun.output")))
+	}
+
+	bot.API = slack.New(viper.GetString("bot.token"), slack.OptionHTTPClient(httpClient))
 	bot.RTM = bot.API.NewRTM()
 
 	go bot.RTM.ManageConnection()
@@ -102,13 +182,53 @@ _\ \ | (_| | (__|   </ __  / (_| | |
 	// output channels and start the runloop
 	output := make(chan *plugin.SlackResponse)
 
+	// Init our scheduler, jobs will start once connected
+	initScheduler(viper.GetString("bot.trigger"), output, &bot)
+
 	zap.L().Info("Putting myself to the fullest possible use, which is all I think that any conscious entity can ever hope to do...")
 
-	// Init our plugins
+	// Load the catalogs used to translate the replies
+	if err := bot.Translator.Init(os.ExpandEnv(viper.GetString("bot.i18n.catalogs")), os.ExpandEnv(viper.GetString("bot.i18n.database"))); err != nil {
+		zap.L().Fatal("Cannot initialize the translations", zap.Error(err))
+	}
+
+	// Open the storage shared by the plugins
+	if err := bot.Storage.Init(dataDir); err != nil {
+		zap.L().Fatal("Cannot initialize the plugins storage", zap.Error(err))
+	}
+
+	// Load the external plugins
+	for _, dir := range viper.GetStringSlice("bot.plugins.directories") {
+		plugin.PluginManager.PluginDirs = append(plugin.PluginManager.PluginDirs, os.ExpandEnv(dir))
+	}
+	if err := plugin.PluginManager.LoadPLugins(); err != nil {
+		zap.L().Error("Error while loading external plugins", zap.Error(err))
+	}
+
+	// Register the remote plugins
+	remotes := []plugin.Remote{}
+	if err := viper.UnmarshalKey("bot.plugins.remote", &remotes); err != nil {
+		zap.L().Error("Invalid remote plugins configuration", zap.Error(err))
+	}
+	for i := range remotes {
+		remotes[i].Secret = os.ExpandEnv(remotes[i].Secret)
+	}
+	plugin.PluginManager.LoadRemotePlugins(remotes, viper.GetDuration("bot.plugins.remoteRefresh"))
+	defer plugin.PluginManager.Stop()
+
+	// Init our plugins, the ones panicking too often will be disabled
+	// and the ones enabled or disabled at runtime keep their state
+	if err := plugin.PluginManager.InitState(os.ExpandEnv(viper.GetString("bot.plugins.database"))); err != nil {
+		zap.L().Fatal("Cannot initialize the plugins state", zap.Error(err))
+	}
+	plugin.PluginManager.MaxFailures = viper.GetInt("bot.plugins.maxFailures")
+	plugin.PluginManager.FailureWindow = viper.GetDuration("bot.plugins.failureWindow")
 	initPlugins(disabledPlugins, viper.GetString("bot.httpHandlerPort"), output, &bot)
 
-	// Initialize our message tracker
-	bot.Tracker.Init()
+	// Initialize our message tracker, persisted if a database is set
+	if err := bot.Tracker.Init(os.ExpandEnv(viper.GetString("bot.tracker.database"))); err != nil {
+		zap.L().Fatal("Cannot initialize the message tracker", zap.Error(err))
+	}
 
 	// Start our Response dispatching run loop
 	go DispatchResponses(output, &bot)
@@ -124,24 +244,24 @@ Loop:
 			bot.Name = info.User.Name
 			bot.ID = info.User.ID
 			zap.L().Info("Connected", zap.String("name", bot.Name), zap.String("id", bot.ID))
+			bot.Scheduler.Start()
 
 		case *slack.MessageEvent:
 			// zap.L().Debug("Message event received", zap.Reflect("event", ev))
-			// Discard messages coming from myself or bots
-			if ev.SubType == "bot_message" || ev.User == bot.ID {
+			// Discard messages coming from myself
+			if ev.User == bot.ID {
 				continue
 			}
-			if ev.SubType == "message_changed" {
-				if ev.SubMessage.SubType == "bot_message" || ev.SubMessage.User == bot.ID {
-					continue
-				}
+			if ev.SubType == "message_changed" && (ev.SubMessage == nil || ev.SubMessage.User == bot.ID) {
+				continue
+			}
+			// Discard messages coming from bots not allowed
+			if plugin.IsBotMessage(ev) && !bots.Allow(ev) {
+				continue
 			}
 
 			go DispatchMessage(viper.GetString("bot.trigger"), ev, output)
 
-		case *slack.AckMessage:
-			bot.Tracker.UpdateTracking(ev)
-
 		case *slack.RTMError:
 			zap.L().Error("RTM error", zap.String("error", ev.Error()))
 
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/CyrilPeponnet/slackhal/plugin/blocks"
+	"github.com/slack-go/slack"
+)
+
+/*
+Oversized responses handling.
+
+Slack truncates long messages, so responses with a long text are either
+split on line boundaries, keeping code fences balanced, or uploaded as a
+snippet when they are really too long. Responses with blocks are split on
+block boundaries, sections longer than slack accepts are split first.
+*/
+
+// splitResponse split a response with a text longer than maxLength.
+// Above snippetThreshold the response is turned into a snippet instead, unless it is ephemeral.
+// Responses with blocks are split on block boundaries, the ones with attachments are left untouched.
+func splitResponse(msg *plugin.SlackResponse, maxLength int, snippetThreshold int) []*plugin.SlackResponse {
+
+	if msg.Snippet != nil || (msg.Mode != plugin.ModePost && msg.Mode != plugin.ModeEphemeral) {
+		return []*plugin.SlackResponse{msg}
+	}
+
+	_, values, err := slack.UnsafeApplyMsgOptions("", msg.Channel, "", msg.Options...)
+	if err != nil || values.Get("attachments") != "" {
+		return []*plugin.SlackResponse{msg}
+	}
+
+	if values.Get("blocks") != "" {
+		return splitBlocks(msg, values, maxLength)
+	}
+
+	text := values.Get("text")
+	length := utf8.RuneCountInString(text)
+
+	// A snippet would be seen by the whole channel
+	if msg.Mode == plugin.ModeEphemeral && maxLength <= 0 {
+		maxLength = snippetThreshold
+	}
+
+	switch {
+	case snippetThreshold > 0 && length > snippetThreshold && msg.Mode != plugin.ModeEphemeral:
+		r := *msg
+		r.Snippet = &plugin.Snippet{Filename: "message.txt", Filetype: "text"}
+		return []*plugin.SlackResponse{&r}
+
+	case maxLength > 0 && length > maxLength:
+		responses := []*plugin.SlackResponse{}
+		for i, chunk := range blocks.Split(text, maxLength) {
+			r := *msg
+			r.Options = textOptions(values, chunk)
+			// Only the first part is tracked
+			if i > 0 {
+				r.TrackerID = ""
+			}
+			responses = append(responses, &r)
+		}
+		return responses
+	}
+
+	return []*plugin.SlackResponse{msg}
+}
+
+// splitBlocks split a response with more blocks than slack accepts or with blocks longer than maxLength.
+// Sections longer than blocks.MaxTextLength are split in several sections.
+// Each part is sent with the text of its blocks as notification fallback.
+func splitBlocks(msg *plugin.SlackResponse, values url.Values, maxLength int) []*plugin.SlackResponse {
+
+	set := slack.Blocks{}
+	if err := json.Unmarshal([]byte(values.Get("blocks")), &set); err != nil {
+		return []*plugin.SlackResponse{msg}
+	}
+
+	var parts [][]slack.Block
+	var texts [][]string
+	var part []slack.Block
+	var text []string
+	length := 0
+
+	split := []slack.Block{}
+	for _, b := range set.BlockSet {
+		split = append(split, splitSection(b)...)
+	}
+
+	for _, b := range split {
+		t := blockText(b)
+		l := utf8.RuneCountInString(t)
+		if len(part) > 0 && (len(part) == blocks.MaxBlocks || (maxLength > 0 && length+l > maxLength)) {
+			parts = append(parts, part)
+			texts = append(texts, text)
+			part, text, length = nil, nil, 0
+		}
+		part = append(part, b)
+		if t != "" {
+			text = append(text, t)
+		}
+		length += l
+	}
+	parts = append(parts, part)
+	texts = append(texts, text)
+
+	if len(parts) == 1 && len(split) == len(set.BlockSet) && (maxLength <= 0 || utf8.RuneCountInString(values.Get("text")) <= maxLength) {
+		return []*plugin.SlackResponse{msg}
+	}
+
+	responses := []*plugin.SlackResponse{}
+	for i, part := range parts {
+		r := *msg
+		fallback := strings.Join(texts[i], "\n")
+		if maxLength > 0 {
+			fallback = blocks.Truncate(fallback, maxLength)
+		}
+		r.Options = append(textOptions(values, fallback), slack.MsgOptionBlocks(part...))
+		// Only the first part is tracked
+		if i > 0 {
+			r.TrackerID = ""
+		}
+		responses = append(responses, &r)
+	}
+	return responses
+}
+
+// splitSection split a section with a text longer than blocks.MaxTextLength in several sections.
+// Other blocks and sections with fields or an accessory are returned as is.
+func splitSection(b slack.Block) []slack.Block {
+	s, ok := b.(*slack.SectionBlock)
+	if !ok || s.Text == nil || len(s.Fields) > 0 || s.Accessory != nil || utf8.RuneCountInString(s.Text.Text) <= blocks.MaxTextLength {
+		return []slack.Block{b}
+	}
+	sections := []slack.Block{}
+	for _, part := range blocks.Split(s.Text.Text, blocks.MaxTextLength) {
+		sections = append(sections, slack.NewSectionBlock(slack.NewTextBlockObject(s.Text.Type, part, s.Text.Emoji, s.Text.Verbatim), nil, nil))
+	}
+	return sections
+}
+
+// blockText return the texts of a section or a context block
+func blockText(b slack.Block) string {
+	texts := []string{}
+	switch b := b.(type) {
+	case *slack.SectionBlock:
+		if b.Text != nil {
+			texts = append(texts, b.Text.Text)
+		}
+		for _, f := range b.Fields {
+			texts = append(texts, f.Text)
+		}
+	case *slack.ContextBlock:
+		for _, e := range b.ContextElements.Elements {
+			if t, ok := e.(*slack.TextBlockObject); ok {
+				texts = append(texts, t.Text)
+			}
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// textOptions return the options of a message with another text
+func textOptions(values url.Values, text string) []slack.MsgOption {
+
+	params := slack.NewPostMessageParameters()
+	if values.Get("link_names") == "1" {
+		params.LinkNames = 1
+	}
+	params.AsUser = values.Get("as_user") == "true"
+	params.Username = values.Get("username")
+	params.IconURL = values.Get("icon_url")
+	params.IconEmoji = values.Get("icon_emoji")
+	params.ThreadTimestamp = values.Get("thread_ts")
+	params.ReplyBroadcast = values.Get("reply_broadcast") == "true"
+	params.Markdown = values.Get("mrkdwn") != "false"
+	params.UnfurlMedia = values.Get("unfurl_media") != "false"
+	params.UnfurlLinks = values.Get("unfurl_links") == "true"
+	if values.Get("parse") != "" {
+		params.Parse = values.Get("parse")
+	}
+
+	return []slack.MsgOption{
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionPostMessageParameters(params),
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/CyrilPeponnet/slackhal/plugin"
+	"github.com/CyrilPeponnet/slackhal/plugin/blocks"
+	"github.com/slack-go/slack"
+)
+
+// responseValues return the values posted for a response
+func responseValues(t *testing.T, r *plugin.SlackResponse) (text string, set slack.Blocks) {
+	_, values, err := slack.UnsafeApplyMsgOptions("", r.Channel, "", r.Options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := values.Get("blocks"); b != "" {
+		if err := json.Unmarshal([]byte(b), &set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return values.Get("text"), set
+}
+
+func TestSplitResponse(t *testing.T) {
+
+	long := strings.Repeat("some words to say\n", 300)
+	longSection := &slack.SectionBlock{Type: slack.MBTSection, Text: slack.NewTextBlockObject(slack.MarkdownType, strings.Repeat("a & b\n", 1000), false, false)}
+
+	tests := []struct {
+		name     string
+		response *plugin.SlackResponse
+		// Expected responses and if they have blocks
+		parts   int
+		blocks  bool
+		snippet bool
+	}{
+		{"short", &plugin.SlackResponse{Options: []slack.MsgOption{slack.MsgOptionText("hello", false)}}, 1, false, false},
+		{"long", &plugin.SlackResponse{TrackerID: "t", Options: []slack.MsgOption{slack.MsgOptionText(long, false)}}, 2, false, false},
+		{"snippet", &plugin.SlackResponse{Options: []slack.MsgOption{slack.MsgOptionText(long+long+long, false)}}, 1, false, true},
+		{"ephemeral", &plugin.SlackResponse{Mode: plugin.ModeEphemeral, User: "U1", Options: []slack.MsgOption{slack.MsgOptionText(long+long+long, false)}}, 5, false, false},
+		{"scheduled", &plugin.SlackResponse{Mode: plugin.ModeScheduled, Options: []slack.MsgOption{slack.MsgOptionText(long+long+long, false)}}, 1, false, false},
+		{"blocks", blocks.New().Section("title").Code(long).Code(long).Response("C1"), 4, true, false},
+		{"long section", &plugin.SlackResponse{Options: []slack.MsgOption{slack.MsgOptionText("fallback", false), slack.MsgOptionBlocks(longSection)}}, 2, true, false},
+	}
+
+	for _, test := range tests {
+		test.response.Channel = "C1"
+		got := splitResponse(test.response, 4000, 12000)
+		if len(got) != test.parts {
+			t.Errorf("%s: got %d responses, want %d", test.name, len(got), test.parts)
+			continue
+		}
+		for i, r := range got {
+			text, set := responseValues(t, r)
+			if (len(set.BlockSet) > 0) != test.blocks {
+				t.Errorf("%s: response %d has %d blocks", test.name, i, len(set.BlockSet))
+			}
+			if (r.Snippet != nil) != test.snippet {
+				t.Errorf("%s: response %d snippet is %v", test.name, i, r.Snippet)
+			}
+			// Only posted messages are split
+			if r.Snippet == nil && r.Mode != plugin.ModeScheduled && utf8.RuneCountInString(text) > 4000 {
+				t.Errorf("%s: response %d text is %d long", test.name, i, utf8.RuneCountInString(text))
+			}
+			for _, b := range set.BlockSet {
+				if s, ok := b.(*slack.SectionBlock); ok && s.Text != nil && utf8.RuneCountInString(s.Text.Text) > blocks.MaxTextLength {
+					t.Errorf("%s: response %d has a section %d long", test.name, i, utf8.RuneCountInString(s.Text.Text))
+				}
+			}
+			if i > 0 && r.TrackerID != "" {
+				t.Errorf("%s: response %d is tracked", test.name, i)
+			}
+		}
+	}
+}
+
+func TestSplitResponseKeepsLongSection(t *testing.T) {
+
+	text := strings.Repeat("a & b\n", 1000)
+	section := &slack.SectionBlock{Type: slack.MBTSection, Text: slack.NewTextBlockObject(slack.MarkdownType, text, false, false)}
+	r := &plugin.SlackResponse{Channel: "C1", Options: []slack.MsgOption{slack.MsgOptionText("fallback", false), slack.MsgOptionBlocks(section)}}
+
+	got := splitResponse(r, 0, 0)
+	if len(got) != 1 {
+		t.Fatalf("got %d responses", len(got))
+	}
+	_, set := responseValues(t, got[0])
+	rendered := []string{}
+	for _, b := range set.BlockSet {
+		rendered = append(rendered, b.(*slack.SectionBlock).Text.Text)
+	}
+	if len(rendered) < 2 || strings.Join(rendered, "\n") != strings.TrimSuffix(text, "\n") {
+		t.Errorf("section not split without loss in %d sections", len(rendered))
+	}
+}